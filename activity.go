@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"cosmossdk.io/math"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var TX_SEARCH_PER_PAGE = 100
+
+// TX_SEARCH_MAX_RESULTS bounds the transactions a search pages through; a
+// query matching more has to be narrowed to a range of heights.
+var TX_SEARCH_MAX_RESULTS = 10000
+
+type ActivityType int
+
+const (
+	ACTIVITY_SEND ActivityType = iota
+	ACTIVITY_RECEIVE
+	ACTIVITY_IBC_TRANSFER_OUT
+	ACTIVITY_IBC_TRANSFER_IN
+	ACTIVITY_DELEGATE
+	ACTIVITY_UNDELEGATE
+	ACTIVITY_REDELEGATE
+	ACTIVITY_REWARD_WITHDRAWAL
+)
+
+func (t ActivityType) String() string {
+	switch t {
+	case ACTIVITY_SEND:
+		return "send"
+	case ACTIVITY_RECEIVE:
+		return "receive"
+	case ACTIVITY_IBC_TRANSFER_OUT:
+		return "ibc_transfer_out"
+	case ACTIVITY_IBC_TRANSFER_IN:
+		return "ibc_transfer_in"
+	case ACTIVITY_DELEGATE:
+		return "delegate"
+	case ACTIVITY_UNDELEGATE:
+		return "undelegate"
+	case ACTIVITY_REDELEGATE:
+		return "redelegate"
+	case ACTIVITY_REWARD_WITHDRAWAL:
+		return "reward_withdrawal"
+	}
+	return strconv.Itoa(int(t))
+}
+
+func (t ActivityType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *ActivityType) UnmarshalText(b []byte) error {
+	for candidate := ACTIVITY_SEND; candidate <= ACTIVITY_REWARD_WITHDRAWAL; candidate++ {
+		if candidate.String() == string(b) {
+			*t = candidate
+			return nil
+		}
+	}
+	return errors.Errorf("unknown activity type %s", b)
+}
+
+// Message actions as reported by the message event, by type URL since Cosmos
+// SDK v0.46 and by route before.
+var (
+	sendActions               = map[string]bool{"/cosmos.bank.v1beta1.MsgSend": true, "/cosmos.bank.v1beta1.MsgMultiSend": true, "send": true, "multisend": true}
+	ibcTransferActions        = map[string]bool{"/ibc.applications.transfer.v1.MsgTransfer": true, "transfer": true}
+	ibcRecvPacketActions      = map[string]bool{"/ibc.core.channel.v1.MsgRecvPacket": true, "recv_packet": true}
+	withdrawRewardActions     = map[string]bool{"/cosmos.distribution.v1beta1.MsgWithdrawDelegatorReward": true, "withdraw_delegator_reward": true}
+	base64AttributeKeyPattern = regexp.MustCompile(`^[a-z_.]+$`)
+)
+
+// Activity is one movement of an address's funds decoded from a transaction.
+type Activity struct {
+	Chain   string    `json:"chain"`
+	Address string    `json:"address"`
+	Height  int64     `json:"height"`
+	Time    time.Time `json:"time"`
+	TxHash  string    `json:"txHash"`
+	// MsgIndex is the message of the transaction that caused the activity, or
+	// -1 when the chain does not tell.
+	MsgIndex int          `json:"msgIndex"`
+	Type     ActivityType `json:"type"`
+	// Counterparty is the other address of a transfer, or the validator of a
+	// staking activity, the source validator of a redelegation.
+	Counterparty         string      `json:"counterparty,omitempty"`
+	DestinationValidator string      `json:"destinationValidator,omitempty"`
+	Amount               types.Coins `json:"amount"`
+	// AutoClaimed marks rewards withdrawn by a delegation change rather than
+	// by a withdraw message.
+	AutoClaimed bool `json:"autoClaimed,omitempty"`
+
+	txIndex int64
+}
+
+// txMessage is what the message event tells about a message of a transaction.
+type txMessage struct {
+	action string
+	sender string
+}
+
+// queryActivity pages through the transactions that address sent or received
+// funds in between fromHeight and toHeight, both optional, and decodes them
+// into an activity feed ordered by height.
+func queryActivity(ctx context.Context, chain, address string, fromHeight, toHeight int64) ([]Activity, error) {
+	// The address is embedded in the tx_search query.
+	if _, _, err := bech32.DecodeAndConvert(address); err != nil {
+		return nil, errors.Wrapf(err, "invalid address %s", address)
+	}
+
+	var conditions []string
+	if fromHeight > 0 {
+		conditions = append(conditions, fmt.Sprintf("tx.height>=%d", fromHeight))
+	}
+	if toHeight > 0 {
+		conditions = append(conditions, fmt.Sprintf("tx.height<=%d", toHeight))
+	}
+
+	var (
+		seen       = make(map[string]bool)
+		activities []Activity
+	)
+	for _, condition := range []string{"message.sender='%s'", "transfer.recipient='%s'"} {
+		query := strings.Join(append([]string{fmt.Sprintf(condition, address)}, conditions...), " AND ")
+		txs, err := coalesce(ctx, chain, "tx_search", query, func(ctx context.Context) ([]TxResult, error) {
+			return searchTxs(ctx, chain, query)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range txs {
+			if seen[tx.Hash] || tx.TxResult.Code != 0 {
+				continue
+			}
+			seen[tx.Hash] = true
+
+			decoded, err := decodeActivities(chain, address, tx)
+			if err != nil {
+				return nil, errors.Wrapf(err, "tx %s", tx.Hash)
+			}
+			activities = append(activities, decoded...)
+		}
+	}
+
+	var blockTimes = make(map[int64]time.Time)
+	for i := range activities {
+		blockTime, ok := blockTimes[activities[i].Height]
+		if !ok {
+			t, err := GetBlockTime(ctx, chain, activities[i].Height)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get block time")
+			}
+			blockTime = *t
+			blockTimes[activities[i].Height] = blockTime
+		}
+		activities[i].Time = blockTime
+	}
+
+	sort.SliceStable(activities, func(i, j int) bool {
+		if activities[i].Height != activities[j].Height {
+			return activities[i].Height < activities[j].Height
+		}
+		if activities[i].txIndex != activities[j].txIndex {
+			return activities[i].txIndex < activities[j].txIndex
+		}
+		return activities[i].MsgIndex < activities[j].MsgIndex
+	})
+
+	return activities, nil
+}
+
+// searchTxs returns every transaction matching query, oldest first, or fails
+// when more than TX_SEARCH_MAX_RESULTS match.
+func searchTxs(ctx context.Context, chain, query string) ([]TxResult, error) {
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+
+	var txs []TxResult
+	for page := 1; ; page++ {
+		resp, err := c.Client.Query(ctx, TX_SEARCH_PATH, map[string]string{
+			"query":    fmt.Sprintf("\"%s\"", query),
+			"prove":    "false",
+			"page":     strconv.Itoa(page),
+			"per_page": strconv.Itoa(TX_SEARCH_PER_PAGE),
+			"order_by": "\"asc\"",
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query /tx_search?query=%s", query)
+		}
+
+		var r = &TxSearchResponse{}
+		if err := json.Unmarshal(resp, r); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal json")
+		}
+		if r.Error != nil {
+			return nil, errors.Errorf("tx_search failed: %s %s", r.Error.Message, r.Error.Data)
+		}
+		if r.Result == nil {
+			return nil, errors.New("request didn't complete successfully")
+		}
+
+		totalCount, err := strconv.Atoi(r.Result.TotalCount)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse total_count")
+		}
+		if totalCount > TX_SEARCH_MAX_RESULTS {
+			return nil, tooManyResults("%d transactions match, more than %d; narrow the range", totalCount, TX_SEARCH_MAX_RESULTS)
+		}
+
+		txs = append(txs, r.Result.Txs...)
+		// A node whose total grows while paging stops at the bound too.
+		if len(r.Result.Txs) == 0 || len(txs) >= totalCount || len(txs) >= TX_SEARCH_MAX_RESULTS {
+			return txs, nil
+		}
+	}
+}
+
+// decodeActivities finds the activities of address in the events of tx.
+func decodeActivities(chain, address string, tx TxResult) ([]Activity, error) {
+	height, err := strconv.ParseInt(tx.Height, 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse height")
+	}
+
+	events := decodeEvents(tx.TxResult.Events)
+
+	// Since Cosmos SDK v0.50 the events of a message carry its index, and the
+	// events of the ante handler, e.g. fee payment, carry none.
+	var (
+		indexed  bool
+		messages []txMessage
+	)
+	for _, e := range events {
+		if _, ok := e.attributes["msg_index"]; ok {
+			indexed = true
+		}
+	}
+	for _, e := range events {
+		if e.kind != "message" || e.attributes["action"] == "" {
+			continue
+		}
+		m := txMessage{action: e.attributes["action"], sender: e.attributes["sender"]}
+		if i, ok := e.msgIndex(); ok {
+			for len(messages) <= i {
+				messages = append(messages, txMessage{})
+			}
+			messages[i] = m
+		} else {
+			messages = append(messages, m)
+		}
+	}
+
+	// candidates returns the messages that may have emitted e and the index
+	// of the message, or -1 when there may be several.
+	candidates := func(e decodedEvent) ([]txMessage, int) {
+		if i, ok := e.msgIndex(); ok {
+			if i < len(messages) {
+				return messages[i : i+1], i
+			}
+			return nil, -1
+		}
+		if indexed {
+			return nil, -1
+		}
+		if len(messages) == 1 {
+			return messages, 0
+		}
+		return messages, -1
+	}
+	ownedBy := func(e decodedEvent, msgs []txMessage) bool {
+		if delegator, ok := e.attributes["delegator"]; ok {
+			return delegator == address
+		}
+		for _, m := range msgs {
+			if m.sender == address {
+				return true
+			}
+		}
+		return false
+	}
+	anyAction := func(msgs []txMessage, actions map[string]bool) bool {
+		for _, m := range msgs {
+			if actions[m.action] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var activities []Activity
+	for _, e := range events {
+		msgs, msgIndex := candidates(e)
+		if len(msgs) == 0 {
+			continue
+		}
+
+		amount, ok := parseAmount(chain, e.attributes["amount"])
+		if !ok {
+			continue
+		}
+
+		activity := Activity{
+			Chain:    chain,
+			Address:  address,
+			Height:   height,
+			TxHash:   tx.Hash,
+			MsgIndex: msgIndex,
+			Amount:   amount,
+			txIndex:  tx.Index,
+		}
+
+		sender, recipient := e.attributes["sender"], e.attributes["recipient"]
+		switch e.kind {
+		case "transfer":
+			switch {
+			case anyAction(msgs, sendActions) && sender == address && recipient != address:
+				if isFeeCollector(recipient) {
+					continue
+				}
+				activity.Type = ACTIVITY_SEND
+				activity.Counterparty = recipient
+			case anyAction(msgs, sendActions) && recipient == address && sender != address:
+				activity.Type = ACTIVITY_RECEIVE
+				activity.Counterparty = sender
+			case anyAction(msgs, ibcTransferActions) && sender == address:
+				activity.Type = ACTIVITY_IBC_TRANSFER_OUT
+				activity.Counterparty = findAttribute(events, "ibc_transfer", "sender", address, "receiver")
+			case anyAction(msgs, ibcRecvPacketActions) && recipient == address:
+				activity.Type = ACTIVITY_IBC_TRANSFER_IN
+				activity.Counterparty = findAttribute(events, "fungible_token_packet", "receiver", address, "sender")
+			default:
+				continue
+			}
+		case "delegate", "unbond":
+			if !ownedBy(e, msgs) {
+				continue
+			}
+			activity.Type = ACTIVITY_DELEGATE
+			if e.kind == "unbond" {
+				activity.Type = ACTIVITY_UNDELEGATE
+			}
+			activity.Counterparty = e.attributes["validator"]
+		case "redelegate":
+			if !ownedBy(e, msgs) {
+				continue
+			}
+			activity.Type = ACTIVITY_REDELEGATE
+			activity.Counterparty = e.attributes["source_validator"]
+			activity.DestinationValidator = e.attributes["destination_validator"]
+		case "withdraw_rewards":
+			if !ownedBy(e, msgs) {
+				continue
+			}
+			activity.Type = ACTIVITY_REWARD_WITHDRAWAL
+			activity.Counterparty = e.attributes["validator"]
+			activity.AutoClaimed = !anyAction(msgs, withdrawRewardActions)
+		default:
+			continue
+		}
+
+		activities = append(activities, activity)
+	}
+
+	return activities, nil
+}
+
+type decodedEvent struct {
+	kind       string
+	attributes map[string]string
+}
+
+func (e decodedEvent) msgIndex() (int, bool) {
+	raw, ok := e.attributes["msg_index"]
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(raw)
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+// decodeEvents flattens event attributes into maps. CometBFT before v0.37
+// encodes attribute keys and values in base64.
+func decodeEvents(events []Event) []decodedEvent {
+	var encoded = true
+	for _, e := range events {
+		for _, a := range e.Attributes {
+			key, err := base64.StdEncoding.DecodeString(a.Key)
+			if err != nil || !base64AttributeKeyPattern.Match(key) {
+				encoded = false
+			}
+		}
+	}
+
+	var decoded = make([]decodedEvent, 0, len(events))
+	for _, e := range events {
+		d := decodedEvent{kind: e.Type, attributes: make(map[string]string)}
+		for _, a := range e.Attributes {
+			key, value := a.Key, a.Value
+			if encoded {
+				k, _ := base64.StdEncoding.DecodeString(a.Key)
+				v, _ := base64.StdEncoding.DecodeString(a.Value)
+				key, value = string(k), string(v)
+			}
+			d.attributes[key] = value
+		}
+		decoded = append(decoded, d)
+	}
+	return decoded
+}
+
+// parseAmount reads the amount attribute of an event, which older chains
+// report without denom for the staking token.
+func parseAmount(chain, amount string) (types.Coins, bool) {
+	if amount == "" {
+		return nil, false
+	}
+	coins, err := types.ParseCoinsNormalized(amount)
+	if err == nil {
+		return coins, !coins.IsZero()
+	}
+
+	if n, ok := math.NewIntFromString(amount); ok {
+		if denom := cfg.Chains[chain].StakingTokenDenom; denom != "" && n.IsPositive() {
+			return types.NewCoins(types.NewCoin(denom, n)), true
+		}
+	}
+	return nil, false
+}
+
+func findAttribute(events []decodedEvent, eventType, matchKey, matchValue, key string) string {
+	for _, e := range events {
+		if e.kind == eventType && e.attributes[matchKey] == matchValue {
+			return e.attributes[key]
+		}
+	}
+	return ""
+}
+
+// isFeeCollector reports whether address is the fee collector module account,
+// whose incoming transfers are fees rather than sends.
+func isFeeCollector(address string) bool {
+	_, b, err := bech32.DecodeAndConvert(address)
+	if err != nil {
+		return false
+	}
+	return types.AccAddress(b).Equals(authtypes.NewModuleAddress(authtypes.FeeCollectorName))
+}
+
+// getActivity answers the activity feed of an address, optionally limited to
+// the "from" and "to" query parameters, each a height, a date or an RFC 3339
+// time.
+func getActivity(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	ctx, cancel, err := requestContext(c)
+	if err != nil {
+		respondError(c, c.Request.Context(), invalidParameter(errors.Wrap(err, "failed to parse timeout")))
+		return
+	}
+	defer cancel()
+
+	var heights [2]int64
+	for i, value := range []string{c.Query("from"), c.Query("to")} {
+		if value == "" {
+			continue
+		}
+
+		height, t, err := parsePoint(value)
+		if err != nil {
+			respondError(c, ctx, invalidParameter(err))
+			return
+		}
+		if height == 0 {
+			height, err = resolveHeight(ctx, chainParam, t)
+			if err != nil {
+				respondError(c, ctx, errors.Wrapf(err, "failed to resolve the height of %s", value))
+				return
+			}
+		}
+		heights[i] = height
+	}
+	if heights[0] != 0 && heights[1] != 0 && heights[1] < heights[0] {
+		respondError(c, ctx, invalidDateRange("to is before from"))
+		return
+	}
+
+	activities, err := queryActivity(ctx, chainParam, addressParam, heights[0], heights[1])
+	if err != nil {
+		respondError(c, ctx, errors.Wrap(err, "failed to query activity"))
+		return
+	}
+
+	respond(c, http.StatusOK, activities)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"cosmossdk.io/math"
+	"fmt"
+	log "github.com/xlab/suplog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ALERT_BELOW     = "below"
+	ALERT_ABOVE     = "above"
+	ALERT_CHANGE    = "change"
+	ALERT_NEW_DENOM = "new_denom"
+
+	ALERT_UP   = "up"
+	ALERT_DOWN = "down"
+
+	ALERT_FIRING   = "firing"
+	ALERT_RESOLVED = "resolved"
+)
+
+// NEW_DENOM_LOOKBACK bounds how far back the previous snapshot of a
+// new_denom rule is looked for.
+var NEW_DENOM_LOOKBACK = 31 * 24 * time.Hour
+
+// alertEngine evaluates the configured alert rules. It is nil unless rules
+// are configured.
+var alertEngine *AlertEngine
+
+// Alert is a notification that a rule started or stopped firing for an
+// address.
+type Alert struct {
+	Status    string    `json:"status"`
+	Rule      string    `json:"rule"`
+	Kind      string    `json:"kind"`
+	Chain     string    `json:"chain"`
+	Address   string    `json:"address"`
+	Source    string    `json:"source,omitempty"`
+	Denom     string    `json:"denom"`
+	Value     math.Int  `json:"value"`
+	Message   string    `json:"message"`
+	Height    int64     `json:"height"`
+	BlockTime time.Time `json:"blockTime"`
+}
+
+// AlertEngine evaluates alert rules against snapshots and notifies only
+// when a rule starts or stops firing for an address, so that a condition
+// that persists across snapshots is notified once. The alerts firing are
+// kept in the store, so a restart neither repeats them nor loses their
+// resolution.
+type AlertEngine struct {
+	rules     []AlertRule
+	notifiers map[string]Notifier
+	store     SnapshotStore
+
+	mtx sync.Mutex
+	// firing holds the keys of the alerts firing, see alertKey.
+	firing map[string]bool
+}
+
+func NewAlertEngine(ctx context.Context, config AlertsConfig, store SnapshotStore) (*AlertEngine, error) {
+	notifiers := make(map[string]Notifier)
+	for name, notifierConfig := range config.Notifiers {
+		notifier, err := NewNotifier(notifierConfig)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[name] = notifier
+	}
+
+	var firing = make(map[string]bool)
+	if store != nil {
+		keys, err := store.FiringAlerts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			firing[key] = true
+		}
+	}
+
+	return &AlertEngine{
+		rules:     config.Rules,
+		notifiers: notifiers,
+		store:     store,
+		firing:    firing,
+	}, nil
+}
+
+func alertKey(rule, chain, address, denom string) string {
+	return strings.Join([]string{rule, chain, address, denom}, "/")
+}
+
+// Evaluate checks the rules that apply to the address of snapshot, which
+// must be stored already, and sends the resulting notifications.
+func (e *AlertEngine) Evaluate(ctx context.Context, snapshot Snapshot) {
+	for _, rule := range e.rules {
+		if rule.Chain != snapshot.Chain || (rule.Address != "" && rule.Address != snapshot.Address) {
+			continue
+		}
+
+		alerts, err := e.evaluate(ctx, rule, snapshot)
+		if err != nil {
+			log.WithError(err).Warningf("failed to evaluate the alert rule %s for %s", rule.Name, snapshot.Address)
+			continue
+		}
+		for _, alert := range alerts {
+			e.notify(ctx, rule, alert)
+		}
+	}
+}
+
+// evaluate returns the alerts of rule that changed state with snapshot.
+func (e *AlertEngine) evaluate(ctx context.Context, rule AlertRule, snapshot Snapshot) ([]Alert, error) {
+	// conditions describes, by denom, what fires.
+	var conditions = make(map[string]string)
+
+	switch rule.Kind {
+	case ALERT_BELOW, ALERT_ABOVE:
+		threshold, _ := math.NewIntFromString(rule.Threshold)
+		value := ruleAmount(rule, snapshot, rule.Denom)
+		if (rule.Kind == ALERT_BELOW && value.LT(threshold)) || (rule.Kind == ALERT_ABOVE && value.GT(threshold)) {
+			conditions[rule.Denom] = fmt.Sprintf("%s, %s the threshold of %s", value, rule.Kind, threshold)
+		}
+
+	case ALERT_CHANGE:
+		series, err := e.store.Series(ctx, snapshot.Chain, snapshot.Address, snapshot.BlockTime.Add(-rule.Window-DAILY_SNAPSHOT_TOLERANCE), snapshot.BlockTime)
+		if err != nil {
+			return nil, err
+		}
+		if len(series) == 0 {
+			// No history to compare with, so nothing changed.
+			break
+		}
+		reference := series[0]
+
+		was, value := ruleAmount(rule, reference, rule.Denom), ruleAmount(rule, snapshot, rule.Denom)
+		delta := value.Sub(was)
+		if delta.IsZero() || (rule.Direction == ALERT_UP && delta.IsNegative()) || (rule.Direction == ALERT_DOWN && delta.IsPositive()) {
+			break
+		}
+		if !was.IsZero() {
+			percent, _ := math.LegacyNewDecFromInt(delta.Abs()).MulInt64(100).QuoInt(was).Float64()
+			if percent < rule.Percent {
+				break
+			}
+		}
+		conditions[rule.Denom] = fmt.Sprintf("%s, changed by %s since %s", value, delta, reference.BlockTime.Format(time.RFC3339))
+
+	case ALERT_NEW_DENOM:
+		var previous map[string]bool
+		if len(rule.Denoms) == 0 {
+			series, err := e.store.Series(ctx, snapshot.Chain, snapshot.Address, snapshot.BlockTime.Add(-NEW_DENOM_LOOKBACK), snapshot.BlockTime)
+			if err != nil {
+				return nil, err
+			}
+			if len(series) == 0 {
+				// Every denom is new to an address seen for the first time.
+				previous = ruleDenoms(rule, snapshot)
+			} else {
+				previous = ruleDenoms(rule, series[len(series)-1])
+			}
+		} else {
+			previous = make(map[string]bool)
+			for _, denom := range rule.Denoms {
+				previous[denom] = true
+			}
+		}
+
+		for denom := range ruleDenoms(rule, snapshot) {
+			if !previous[denom] {
+				conditions[denom] = fmt.Sprintf("%s, an unexpected denom", ruleAmount(rule, snapshot, denom))
+			}
+		}
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	var alerts []Alert
+	newAlert := func(status, denom, condition string) Alert {
+		return Alert{
+			Status:    status,
+			Rule:      rule.Name,
+			Kind:      rule.Kind,
+			Chain:     snapshot.Chain,
+			Address:   snapshot.Address,
+			Source:    rule.Source,
+			Denom:     denom,
+			Value:     ruleAmount(rule, snapshot, denom),
+			Message:   fmt.Sprintf("[%s] %s: %s of %s on %s is %s", strings.ToUpper(status), rule.Name, denom, snapshot.Address, snapshot.Chain, condition),
+			Height:    snapshot.Height,
+			BlockTime: snapshot.BlockTime,
+		}
+	}
+
+	var denoms = make([]string, 0, len(conditions))
+	for denom := range conditions {
+		denoms = append(denoms, denom)
+	}
+	sort.Strings(denoms)
+	for _, denom := range denoms {
+		key := alertKey(rule.Name, snapshot.Chain, snapshot.Address, denom)
+		if !e.firing[key] {
+			e.firing[key] = true
+			e.persist(ctx, key, true)
+			alerts = append(alerts, newAlert(ALERT_FIRING, denom, conditions[denom]))
+		}
+	}
+
+	prefix := alertKey(rule.Name, snapshot.Chain, snapshot.Address, "")
+	var resolved []string
+	for key := range e.firing {
+		if denom, ok := strings.CutPrefix(key, prefix); ok {
+			if _, still := conditions[denom]; !still {
+				resolved = append(resolved, denom)
+			}
+		}
+	}
+	sort.Strings(resolved)
+	for _, denom := range resolved {
+		delete(e.firing, prefix+denom)
+		e.persist(ctx, prefix+denom, false)
+		alerts = append(alerts, newAlert(ALERT_RESOLVED, denom, ruleAmount(rule, snapshot, denom).String()+", back to normal"))
+	}
+
+	return alerts, nil
+}
+
+// persist records the state of the alert with key. The alert is notified
+// even if that fails, at the cost of a repeat after a restart.
+func (e *AlertEngine) persist(ctx context.Context, key string, firing bool) {
+	if err := e.store.PutAlert(ctx, key, firing); err != nil {
+		log.WithError(err).Warningln("failed to store the state of an alert")
+	}
+}
+
+func (e *AlertEngine) notify(ctx context.Context, rule AlertRule, alert Alert) {
+	log.Infoln(alert.Message)
+
+	for _, name := range rule.Notify {
+		err := e.notifiers[name].Notify(ctx, alert)
+		alertNotifications.WithLabelValues(name, resultLabel(err)).Inc()
+		if err != nil {
+			log.WithError(err).Warningf("failed to notify %s of %s", name, alert.Rule)
+		}
+	}
+}
+
+// ruleAmount is the amount of denom in the source of rule, or across sources
+// when the rule has none.
+func ruleAmount(rule AlertRule, snapshot Snapshot, denom string) math.Int {
+	var amount = math.ZeroInt()
+	for source, coins := range snapshot.Balances {
+		if rule.Source == "" || rule.Source == source.String() {
+			amount = amount.Add(coins.AmountOf(denom))
+		}
+	}
+	return amount
+}
+
+// ruleDenoms are the denoms held in the source of rule, or across sources.
+func ruleDenoms(rule AlertRule, snapshot Snapshot) map[string]bool {
+	var denoms = make(map[string]bool)
+	for source, coins := range snapshot.Balances {
+		if rule.Source == "" || rule.Source == source.String() {
+			for _, coin := range coins {
+				if coin.IsPositive() {
+					denoms[coin.Denom] = true
+				}
+			}
+		}
+	}
+	return denoms
+}
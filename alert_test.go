@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cosmos/cosmos-sdk/types"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAlertEngine(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		received []Alert
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("expected the configured header, got %q", r.Header.Get("Authorization"))
+		}
+		mtx.Lock()
+		received = append(received, alert)
+		mtx.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	take := func() []Alert {
+		mtx.Lock()
+		defer mtx.Unlock()
+		alerts := received
+		received = nil
+		return alerts
+	}
+
+	config := AlertsConfig{
+		Rules: []AlertRule{
+			{Name: "low", Chain: "alertchain", Source: "bank", Denom: "uatom", Kind: ALERT_BELOW, Threshold: "1000", Notify: []string{"hook"}},
+			{Name: "drop", Chain: "alertchain", Denom: "uatom", Kind: ALERT_CHANGE, Percent: 50, Window: 24 * time.Hour, Direction: ALERT_DOWN, Notify: []string{"hook"}},
+			{Name: "airdrop", Chain: "alertchain", Kind: ALERT_NEW_DENOM, Notify: []string{"hook"}},
+		},
+		Notifiers: map[string]NotifierConfig{
+			"hook": {Webhook: &WebhookConfig{URL: Secret{Value: server.URL}, Headers: map[string]Secret{"Authorization": {Value: "Bearer token"}}}},
+		},
+	}
+	if err := config.validate(map[string]ChainConfig{"alertchain": {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "alerts.db")
+	store, err := NewSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	engine, err := NewAlertEngine(ctx, config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restart := func() {
+		store.Close()
+		if store, err = NewSQLiteStore(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+		if engine, err = NewAlertEngine(ctx, config, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	evaluate := func(hours int, coins types.Coins) []Alert {
+		snapshot := Snapshot{
+			Chain:     "alertchain",
+			Address:   "cosmos1alerts",
+			Height:    int64(hours + 1),
+			BlockTime: start.Add(time.Duration(hours) * time.Hour),
+			Balances:  map[BalanceSource]types.Coins{COSMOSSDK_BANK_BALANCE: coins},
+		}
+		if err := store.Put(ctx, snapshot); err != nil {
+			t.Fatal(err)
+		}
+		engine.Evaluate(ctx, snapshot)
+		return take()
+	}
+	expect := func(alerts []Alert, expected ...string) {
+		t.Helper()
+		if len(alerts) != len(expected)/2 {
+			t.Fatalf("expected %d alerts, got %+v", len(expected)/2, alerts)
+		}
+		for i, alert := range alerts {
+			if alert.Rule != expected[2*i] || alert.Status != expected[2*i+1] {
+				t.Errorf("expected %s %s, got %+v", expected[2*i], expected[2*i+1], alert)
+			}
+		}
+	}
+
+	expect(evaluate(0, types.NewCoins(types.NewInt64Coin("uatom", 1500))))
+	expect(evaluate(1, types.NewCoins(types.NewInt64Coin("uatom", 900))), "low", ALERT_FIRING)
+	// Still below the threshold: no duplicate notification, even after a
+	// restart.
+	restart()
+	expect(evaluate(2, types.NewCoins(types.NewInt64Coin("uatom", 800))))
+	expect(evaluate(3, types.NewCoins(types.NewInt64Coin("uatom", 1200))), "low", ALERT_RESOLVED)
+	// 1500 at hour 0 is the oldest in the window, 700 is more than 50% less.
+	expect(evaluate(4, types.NewCoins(types.NewInt64Coin("uatom", 700))), "low", ALERT_FIRING, "drop", ALERT_FIRING)
+
+	alerts := evaluate(30, types.NewCoins(types.NewInt64Coin("uatom", 1100), types.NewInt64Coin("uosmo", 5)))
+	expect(alerts, "low", ALERT_RESOLVED, "drop", ALERT_RESOLVED, "airdrop", ALERT_FIRING)
+	if alerts[2].Denom != "uosmo" || alerts[2].Value.Int64() != 5 {
+		t.Errorf("expected 5uosmo to be new, got %+v", alerts[2])
+	}
+}
+
+func TestAlertRuleDenom(t *testing.T) {
+	for _, denom := range []string{"u", "1atom"} {
+		config := AlertsConfig{
+			Rules: []AlertRule{{Name: "low", Chain: "alertchain", Denom: denom, Kind: ALERT_BELOW, Threshold: "1000"}},
+		}
+		if err := config.validate(map[string]ChainConfig{"alertchain": {}}); err == nil {
+			t.Errorf("expected denom %q to be rejected", denom)
+		}
+	}
+}
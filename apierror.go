@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// Codes of the errors answered by the HTTP API. They are stable, unlike the
+// messages next to them.
+const (
+	ERROR_INVALID_PARAMETER    = "invalid_parameter"
+	ERROR_INVALID_DATE_RANGE   = "invalid_date_range"
+	ERROR_TOO_MANY_RESULTS     = "too_many_results"
+	ERROR_INVALID_ADDRESS      = "invalid_address"
+	ERROR_UNKNOWN_CHAIN        = "unknown_chain"
+	ERROR_NOT_FOUND            = "not_found"
+	ERROR_CONFLICT             = "conflict"
+	ERROR_HEIGHT_PRUNED        = "height_pruned"
+	ERROR_UPSTREAM_ERROR       = "upstream_error"
+	ERROR_UPSTREAM_UNAVAILABLE = "upstream_unavailable"
+	ERROR_UPSTREAM_TIMEOUT     = "upstream_timeout"
+	ERROR_NOT_READY            = "not_ready"
+	ERROR_UNAUTHORIZED         = "unauthorized"
+	ERROR_FORBIDDEN            = "forbidden"
+	ERROR_RATE_LIMITED         = "rate_limited"
+	ERROR_QUOTA_EXCEEDED       = "quota_exceeded"
+	ERROR_SHUTTING_DOWN        = "shutting_down"
+	ERROR_INTERNAL             = "internal"
+)
+
+// APIError is an error with the HTTP status and code it is answered with.
+type APIError struct {
+	Status int
+	Code   string
+	Err    error
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+func newAPIError(status int, code string, format string, args ...interface{}) *APIError {
+	return &APIError{status, code, errors.Errorf(format, args...)}
+}
+
+func invalidParameter(err error) *APIError {
+	return &APIError{http.StatusBadRequest, ERROR_INVALID_PARAMETER, err}
+}
+
+func invalidDateRange(format string, args ...interface{}) *APIError {
+	return newAPIError(http.StatusBadRequest, ERROR_INVALID_DATE_RANGE, format, args...)
+}
+
+func tooManyResults(format string, args ...interface{}) *APIError {
+	return newAPIError(http.StatusBadRequest, ERROR_TOO_MANY_RESULTS, format, args...)
+}
+
+func unknownChain(chain string) *APIError {
+	return newAPIError(http.StatusNotFound, ERROR_UNKNOWN_CHAIN, "chain %s is not configured", chain)
+}
+
+func notFound(format string, args ...interface{}) *APIError {
+	return newAPIError(http.StatusNotFound, ERROR_NOT_FOUND, format, args...)
+}
+
+func conflict(format string, args ...interface{}) *APIError {
+	return newAPIError(http.StatusConflict, ERROR_CONFLICT, format, args...)
+}
+
+var errSnapshotsNotStored = notFound("snapshots are not stored")
+
+var errShuttingDown = newAPIError(http.StatusServiceUnavailable, ERROR_SHUTTING_DOWN, "the server is shutting down")
+
+// validateAddress checks that chain is configured and that address is a
+// bech32 address, with the prefix of chain when it has one.
+func validateAddress(chain, address string) error {
+	c, ok := cfg.Chains[chain]
+	if !ok {
+		return unknownChain(chain)
+	}
+
+	if err := checkBech32(address, c.Bech32Prefix); err != nil {
+		return &APIError{http.StatusBadRequest, ERROR_INVALID_ADDRESS, errors.Wrapf(err, "chain %s", chain)}
+	}
+	return nil
+}
+
+// checkBech32 checks that address is a bech32 address with prefix, or with
+// any prefix when prefix is empty.
+func checkBech32(address, prefix string) error {
+	got, _, err := bech32.DecodeAndConvert(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address %s", address)
+	}
+	if prefix != "" && got != prefix {
+		return errors.Errorf("address %s does not have the %s prefix", address, prefix)
+	}
+	return nil
+}
+
+// toAPIError classifies err, which failed a request whose upstream calls ran
+// under ctx.
+func toAPIError(ctx context.Context, err error) *APIError {
+	var (
+		apiErr      *APIError
+		upstreamErr *UpstreamError
+		rpcErr      *RPCError
+	)
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &APIError{http.StatusGatewayTimeout, ERROR_UPSTREAM_TIMEOUT, err}
+	case isPrunedError(err):
+		return &APIError{http.StatusGone, ERROR_HEIGHT_PRUNED, err}
+	case errors.As(err, &upstreamErr):
+		return &APIError{http.StatusServiceUnavailable, ERROR_UPSTREAM_UNAVAILABLE, err}
+	case errors.As(err, &rpcErr):
+		return &APIError{http.StatusBadGateway, ERROR_UPSTREAM_ERROR, err}
+	}
+	return &APIError{http.StatusInternalServerError, ERROR_INTERNAL, err}
+}
+
+// respond answers content with status.
+func respond(c *gin.Context, status int, content interface{}) {
+	c.IndentedJSON(status, Message{
+		Error:     "",
+		IsSuccess: true,
+		Content:   content,
+	})
+}
+
+// respondError answers err with the status and code it classifies as, see
+// toAPIError.
+func respondError(c *gin.Context, ctx context.Context, err error) {
+	apiErr := toAPIError(ctx, err)
+	c.IndentedJSON(apiErr.Status, Message{
+		Error:     apiErr.Error(),
+		Code:      apiErr.Code,
+		IsSuccess: false,
+		Content:   struct{}{},
+	})
+}
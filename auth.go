@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"golang.org/x/time/rate"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const API_KEY_HEADER = "X-API-Key"
+
+// PUBLIC_PATHS are served without an API key.
+var PUBLIC_PATHS = map[string]bool{"/healthz": true, "/readyz": true}
+
+// UNSCOPED_PATHS span every chain and address, so keys restricted to some
+// chains or address groups may not use them.
+var UNSCOPED_PATHS = map[string]bool{"/metrics": true, "/admin/cache": true}
+
+// rangeRoute names the query parameters bounding the historical range a
+// route scans. Without the from bound, the route scans no range, unless
+// fromRequired, in which case it scans the whole history.
+type rangeRoute struct {
+	from, to     string
+	fromRequired bool
+}
+
+var rangeRoutes = map[string]rangeRoute{
+	"/balances/:chain/:address":       {"startedAt", "endedAt", false},
+	"/income/:chain/:address":         {"startedAt", "endedAt", false},
+	"/activity/:chain/:address":       {"from", "to", true},
+	"/admin/backfill/:chain/:address": {"from", "to", false},
+	"/admin/check/:chain/:address":    {"from", "to", false},
+	"/admin/repair/:chain/:address":   {"from", "to", false},
+	"/snapshots/:chain/:address":      {"from", "to", true},
+}
+
+// authenticator checks the API key of every request. It is nil unless keys
+// are configured.
+var authenticator *Authenticator
+
+type apiKey struct {
+	name         string
+	limiter      *rate.Limiter
+	inFlight     chan struct{}
+	maxRangeDays int
+	// chains and addresses are nil when the key is not restricted.
+	chains    map[string]bool
+	addresses map[string]bool
+	admin     bool
+}
+
+// AuditEntry records a request made to the API, authenticated or not.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Key      string    `json:"key"`
+	ClientIP string    `json:"clientIp"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Query    string    `json:"query,omitempty"`
+	Status   int       `json:"status"`
+	Duration float64   `json:"durationSeconds"`
+}
+
+type Authenticator struct {
+	// keys are indexed by the SHA-256 of their value, so that looking a key
+	// up does not leak its value through timing.
+	keys map[[sha256.Size]byte]*apiKey
+
+	auditMtx sync.Mutex
+	audit    *os.File
+}
+
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{keys: make(map[[sha256.Size]byte]*apiKey)}
+
+	for _, keyConfig := range config.Keys {
+		value, err := keyConfig.Key.Resolve()
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %s", keyConfig.Name)
+		}
+		if value == "" {
+			return nil, errors.Errorf("key %s is empty", keyConfig.Name)
+		}
+
+		key := &apiKey{
+			name:         keyConfig.Name,
+			maxRangeDays: keyConfig.MaxRangeDays,
+			admin:        keyConfig.Admin,
+		}
+		if limit := keyConfig.RateLimit; limit != nil {
+			if limit.RequestsPerSecond > 0 {
+				var burst = limit.Burst
+				if burst <= 0 {
+					burst = 1
+				}
+				key.limiter = rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), burst)
+			}
+			if limit.MaxInFlight > 0 {
+				key.inFlight = make(chan struct{}, limit.MaxInFlight)
+			}
+		}
+		if len(keyConfig.Chains) > 0 {
+			key.chains = make(map[string]bool)
+			for _, chain := range keyConfig.Chains {
+				key.chains[chain] = true
+			}
+		}
+		if len(keyConfig.AddressGroups) > 0 {
+			key.addresses = make(map[string]bool)
+			for _, group := range keyConfig.AddressGroups {
+				for _, address := range config.AddressGroups[group] {
+					key.addresses[address] = true
+				}
+			}
+		}
+
+		hash := sha256.Sum256([]byte(value))
+		if _, ok := a.keys[hash]; ok {
+			return nil, errors.Errorf("key %s has the value of another key", keyConfig.Name)
+		}
+		a.keys[hash] = key
+	}
+
+	if config.AuditLog != "" {
+		f, err := os.OpenFile(config.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open auditLog")
+		}
+		a.audit = f
+	}
+
+	return a, nil
+}
+
+// Middleware rejects the requests without a valid API key, or that the key
+// is not allowed to make, and audits every request.
+func (a *Authenticator) Middleware(c *gin.Context) {
+	if PUBLIC_PATHS[c.FullPath()] {
+		c.Next()
+		return
+	}
+
+	started := time.Now()
+	var name string
+	defer func() {
+		a.log(AuditEntry{
+			Time:     started.UTC(),
+			Key:      name,
+			ClientIP: c.ClientIP(),
+			Method:   c.Request.Method,
+			Path:     c.Request.URL.Path,
+			Query:    c.Request.URL.RawQuery,
+			Status:   c.Writer.Status(),
+			Duration: time.Since(started).Seconds(),
+		})
+	}()
+
+	key := a.lookup(c)
+	if key == nil {
+		c.Header("WWW-Authenticate", "Bearer")
+		abortError(c, newAPIError(http.StatusUnauthorized, ERROR_UNAUTHORIZED, "a valid API key is required in the %s or Authorization header", API_KEY_HEADER))
+		return
+	}
+	name = key.name
+
+	if err := a.authorize(c, key); err != nil {
+		abortError(c, err)
+		return
+	}
+
+	if key.inFlight != nil {
+		select {
+		case key.inFlight <- struct{}{}:
+			defer func() { <-key.inFlight }()
+		default:
+			abortError(c, newAPIError(http.StatusTooManyRequests, ERROR_RATE_LIMITED, "key %s has too many requests in flight", key.name))
+			return
+		}
+	}
+	if key.limiter != nil && !key.limiter.Allow() {
+		c.Header("Retry-After", "1")
+		abortError(c, newAPIError(http.StatusTooManyRequests, ERROR_RATE_LIMITED, "key %s exceeded its rate limit", key.name))
+		return
+	}
+
+	if err := a.checkRange(c.Request.Context(), c, key); err != nil {
+		abortError(c, err)
+		return
+	}
+
+	c.Next()
+}
+
+func (a *Authenticator) lookup(c *gin.Context) *apiKey {
+	value := c.GetHeader(API_KEY_HEADER)
+	if value == "" {
+		value, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	if value == "" {
+		return nil
+	}
+	return a.keys[sha256.Sum256([]byte(value))]
+}
+
+// authorize checks the restrictions of key against the route of c.
+func (a *Authenticator) authorize(c *gin.Context, key *apiKey) error {
+	path := c.FullPath()
+
+	if strings.HasPrefix(path, "/admin/") && !key.admin {
+		return newAPIError(http.StatusForbidden, ERROR_FORBIDDEN, "key %s may not use the admin routes", key.name)
+	}
+	if (key.chains != nil || key.addresses != nil) && UNSCOPED_PATHS[path] {
+		return newAPIError(http.StatusForbidden, ERROR_FORBIDDEN, "key %s is restricted and may not use %s", key.name, path)
+	}
+	if chain := c.Param("chain"); key.chains != nil && chain != "" && !key.chains[chain] {
+		return newAPIError(http.StatusForbidden, ERROR_FORBIDDEN, "key %s may not query chain %s", key.name, chain)
+	}
+	if address := c.Param("address"); key.addresses != nil && address != "" && !key.addresses[address] {
+		return newAPIError(http.StatusForbidden, ERROR_FORBIDDEN, "key %s may not query %s", key.name, address)
+	}
+	return nil
+}
+
+// checkRange rejects the requests of key scanning a longer range than its
+// quota. Heights are converted with their block time; bounds that fail to
+// parse are left for the handler to report.
+func (a *Authenticator) checkRange(ctx context.Context, c *gin.Context, key *apiKey) error {
+	route, ok := rangeRoutes[c.FullPath()]
+	if !ok || key.maxRangeDays == 0 {
+		return nil
+	}
+
+	if c.Query(route.from) == "" {
+		if route.fromRequired {
+			return newAPIError(http.StatusForbidden, ERROR_QUOTA_EXCEEDED, "key %s must bound its ranges with %s", key.name, route.from)
+		}
+		return nil
+	}
+
+	var bounds = [2]time.Time{{}, time.Now()}
+	for i, value := range []string{c.Query(route.from), c.Query(route.to)} {
+		if value == "" {
+			continue
+		}
+		height, t, err := parsePoint(value)
+		if err != nil {
+			return nil
+		}
+		if height != 0 {
+			blockTime, err := GetBlockTime(ctx, c.Param("chain"), height)
+			if err != nil {
+				return errors.Wrapf(err, "failed to get the block time of %s", value)
+			}
+			t = *blockTime
+		}
+		bounds[i] = t
+	}
+
+	if bounds[1].Sub(bounds[0]) > time.Duration(key.maxRangeDays)*24*time.Hour {
+		return newAPIError(http.StatusForbidden, ERROR_QUOTA_EXCEEDED, "key %s may not scan more than %d days", key.name, key.maxRangeDays)
+	}
+	return nil
+}
+
+func (a *Authenticator) log(entry AuditEntry) {
+	if a.audit == nil {
+		log.WithFields(log.Fields{
+			"key":    entry.Key,
+			"client": entry.ClientIP,
+			"status": entry.Status,
+		}).Infof("audit: %s %s?%s", entry.Method, entry.Path, entry.Query)
+		return
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	a.auditMtx.Lock()
+	defer a.auditMtx.Unlock()
+	if _, err := a.audit.Write(append(b, '\n')); err != nil {
+		log.WithError(err).Warningln("failed to write the audit log")
+	}
+}
+
+// Close closes the audit log.
+func (a *Authenticator) Close() error {
+	if a.audit == nil {
+		return nil
+	}
+	a.auditMtx.Lock()
+	defer a.auditMtx.Unlock()
+	return a.audit.Close()
+}
+
+// abortError answers err, see respondError, and skips the handlers left.
+func abortError(c *gin.Context, err error) {
+	respondError(c, c.Request.Context(), err)
+	c.Abort()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticatorE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	config := AuthConfig{
+		Keys: []APIKeyConfig{
+			{Name: "ops", Key: Secret{Value: "ops-key"}, Admin: true},
+			{Name: "treasury", Key: Secret{Value: "treasury-key"}, MaxRangeDays: 10, Chains: []string{"testchain"}, AddressGroups: []string{"treasury"}},
+			{Name: "throttled", Key: Secret{Value: "throttled-key"}, RateLimit: &RateLimitConfig{RequestsPerSecond: 0.001, Burst: 2}},
+		},
+		AddressGroups: map[string][]string{"treasury": {address}},
+		AuditLog:      auditLog,
+	}
+	if err := config.validate(cfg.Chains); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator = a
+	t.Cleanup(func() {
+		authenticator = nil
+		a.Close()
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := newRouter()
+	request := func(key, target string) (int, Message) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		router.ServeHTTP(recorder, req)
+
+		var message Message
+		if recorder.Code != http.StatusOK || target != "/metrics" {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &message); err != nil {
+				t.Fatalf("failed to decode %s: %s", recorder.Body.String(), err)
+			}
+		}
+		return recorder.Code, message
+	}
+
+	other := e2eAddress(t, "cosmos", 2)
+	for _, tc := range []struct {
+		key, target string
+		status      int
+		code        string
+	}{
+		{"", "/healthz", http.StatusOK, ""},
+		{"", "/balances/testchain/" + address, http.StatusUnauthorized, ERROR_UNAUTHORIZED},
+		{"wrong-key", "/balances/testchain/" + address, http.StatusUnauthorized, ERROR_UNAUTHORIZED},
+		{"ops-key", "/balances/testchain/" + address, http.StatusOK, ""},
+		{"ops-key", "/admin/cache", http.StatusNotFound, ERROR_NOT_FOUND},
+		{"treasury-key", "/admin/cache", http.StatusForbidden, ERROR_FORBIDDEN},
+		{"treasury-key", "/metrics", http.StatusForbidden, ERROR_FORBIDDEN},
+		{"treasury-key", "/balances/testchain/" + other, http.StatusForbidden, ERROR_FORBIDDEN},
+		{"treasury-key", "/balances/testchain/" + address + "?startedAt=2024-10-25&endedAt=2024-10-31", http.StatusOK, ""},
+		{"treasury-key", "/balances/testchain/" + address + "?startedAt=2024-10-01&endedAt=2024-10-31", http.StatusForbidden, ERROR_QUOTA_EXCEEDED},
+		{"treasury-key", "/activity/testchain/" + address, http.StatusForbidden, ERROR_QUOTA_EXCEEDED},
+		{"treasury-key", "/snapshots/testchain/" + address, http.StatusForbidden, ERROR_QUOTA_EXCEEDED},
+		{"treasury-key", "/snapshots/testchain/" + address + "?from=2024-10-01&to=2024-10-31", http.StatusForbidden, ERROR_QUOTA_EXCEEDED},
+		// Within the quota, the request reaches the handler.
+		{"treasury-key", "/snapshots/testchain/" + address + "?from=2024-10-25&to=2024-10-31", http.StatusNotFound, ERROR_NOT_FOUND},
+		{"throttled-key", "/chains", http.StatusOK, ""},
+		{"throttled-key", "/chains", http.StatusOK, ""},
+		{"throttled-key", "/chains", http.StatusTooManyRequests, ERROR_RATE_LIMITED},
+	} {
+		status, message := request(tc.key, tc.target)
+		if status != tc.status || message.Code != tc.code {
+			t.Errorf("%s with %q: expected %d %s, got %d %s: %s", tc.target, tc.key, tc.status, tc.code, status, message.Code, message.Error)
+		}
+	}
+
+	f, err := os.Open(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	// Every request but the probe is audited, rejected ones included.
+	if len(entries) != 16 {
+		t.Fatalf("expected 16 audit entries, got %d", len(entries))
+	}
+	for _, entry := range entries[:2] {
+		if entry.Key != "" || entry.Status != http.StatusUnauthorized {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+	}
+	if entries[7].Key != "treasury" || entries[7].Query != "startedAt=2024-10-25&endedAt=2024-10-31" || entries[7].Status != http.StatusOK {
+		t.Errorf("unexpected entry %+v", entries[7])
+	}
+}
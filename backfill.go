@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BACKFILL_ATTEMPTS is how many times a day is tried before the backfill
+// stops, to be resumed later.
+var BACKFILL_ATTEMPTS = 3
+
+var BACKFILL_RETRY_INTERVAL = 5 * time.Second
+
+// BackfillGap is a day whose snapshot cannot be taken.
+type BackfillGap struct {
+	Date   time.Time `json:"date"`
+	Reason string    `json:"reason"`
+}
+
+// BackfillCheckpoint records how far the backfill of an address over a range
+// of days has come, so that an interrupted backfill resumes where it stopped.
+type BackfillCheckpoint struct {
+	Chain   string    `json:"chain"`
+	Address string    `json:"address"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	// Next is the first day still to be walked; past To when done.
+	Next time.Time `json:"next"`
+	// Stored and Skipped count the days taken and those stored already.
+	Stored    int           `json:"stored"`
+	Skipped   int           `json:"skipped"`
+	Gaps      []BackfillGap `json:"gaps"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+}
+
+func (c BackfillCheckpoint) Done() bool {
+	return c.Next.After(c.To)
+}
+
+// backfill takes the daily snapshots of address from the start of from to
+// the start of to that are not stored yet, saving a checkpoint after every
+// day. A backfill of the same range resumes from its checkpoint. Days before
+// the earliest height the node keeps are reported as gaps; other failures
+// stop the backfill once BACKFILL_ATTEMPTS are used up.
+func backfill(ctx context.Context, store SnapshotStore, chain, address string, from, to time.Time) (BackfillCheckpoint, error) {
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	if to.Before(from) {
+		return BackfillCheckpoint{}, errors.New("to must not be before from")
+	}
+
+	checkpoint, ok, err := store.Checkpoint(ctx, chain, address)
+	if err != nil {
+		return BackfillCheckpoint{}, err
+	}
+	if !ok || !checkpoint.From.Equal(from) || !checkpoint.To.Equal(to) {
+		checkpoint = BackfillCheckpoint{Chain: chain, Address: address, From: from, To: to, Next: from}
+	}
+	if checkpoint.Done() {
+		return checkpoint, nil
+	}
+
+	_, earliestBlockTime, err := earliestBlock(ctx, chain)
+	if err != nil {
+		return checkpoint, err
+	}
+	_, latestBlockTime, _, err := latestBlock(ctx, chain)
+	if err != nil {
+		return checkpoint, err
+	}
+
+	for ; !checkpoint.Done(); checkpoint.Next = checkpoint.Next.Add(24 * time.Hour) {
+		day := checkpoint.Next
+		if day.After(latestBlockTime) {
+			// The rest of the range is left for a later run.
+			break
+		}
+
+		_, stored, err := store.Daily(ctx, chain, address, day)
+		switch {
+		case err != nil:
+			return checkpoint, err
+		case stored:
+			checkpoint.Skipped++
+		case day.Before(earliestBlockTime):
+			checkpoint.Gaps = append(checkpoint.Gaps, BackfillGap{day, "pruned: before the earliest block at " + earliestBlockTime.Format(time.RFC3339)})
+		default:
+			err := takeDailySnapshot(ctx, store, chain, address, day)
+			if isPrunedError(err) {
+				checkpoint.Gaps = append(checkpoint.Gaps, BackfillGap{day, "pruned: " + err.Error()})
+			} else if err != nil {
+				return checkpoint, errors.Wrapf(err, "failed to take the snapshot of %s", day.Format(time.DateOnly))
+			} else {
+				checkpoint.Stored++
+			}
+		}
+
+		next := checkpoint
+		next.Next = day.Add(24 * time.Hour)
+		next.UpdatedAt = time.Now().UTC()
+		if err := store.PutCheckpoint(ctx, next); err != nil {
+			return checkpoint, err
+		}
+	}
+
+	return checkpoint, nil
+}
+
+// takeDailySnapshot takes the daily snapshot of day, retrying failures other
+// than pruned heights.
+func takeDailySnapshot(ctx context.Context, store SnapshotStore, chain, address string, day time.Time) error {
+	var err error
+	for attempt := 1; attempt <= BACKFILL_ATTEMPTS; attempt++ {
+		_, err = takeSnapshot(ctx, store, chain, address, day, true)
+		if err == nil || isPrunedError(err) || ctx.Err() != nil {
+			return err
+		}
+		log.WithError(err).Warningf("failed to backfill %s on %s at %s, attempt %d", address, chain, day.Format(time.DateOnly), attempt)
+
+		if attempt < BACKFILL_ATTEMPTS {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(BACKFILL_RETRY_INTERVAL << (attempt - 1)):
+			}
+		}
+	}
+	return err
+}
+
+// backfillJobs tracks the backfills started through the API, one per
+// address at a time.
+var backfillJobs = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: make(map[string]bool)}
+
+// postBackfill starts the backfill of an address between the "from" and "to"
+// dates in the background, or resumes it.
+func postBackfill(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if snapshotStore == nil {
+		respondError(c, c.Request.Context(), errSnapshotsNotStored)
+		return
+	}
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	key := memoryStoreKey(chainParam, addressParam)
+	backfillJobs.Lock()
+	if backfillJobs.running[key] {
+		backfillJobs.Unlock()
+		respondError(c, c.Request.Context(), conflict("a backfill of %s is running already", addressParam))
+		return
+	}
+	backfillJobs.running[key] = true
+	backfillJobs.Unlock()
+
+	started := backgroundJobs.Go(func(ctx context.Context) {
+		defer func() {
+			backfillJobs.Lock()
+			delete(backfillJobs.running, key)
+			backfillJobs.Unlock()
+		}()
+
+		checkpoint, err := backfill(ctx, snapshotStore, chainParam, addressParam, from, to)
+		if err != nil {
+			log.WithError(err).Errorf("backfill of %s on %s stopped", addressParam, chainParam)
+			return
+		}
+		log.Infof("backfill of %s on %s walked up to %s: %d stored, %d skipped, %d gaps", addressParam, chainParam, checkpoint.Next.Format(time.DateOnly), checkpoint.Stored, checkpoint.Skipped, len(checkpoint.Gaps))
+	})
+	if !started {
+		backfillJobs.Lock()
+		delete(backfillJobs.running, key)
+		backfillJobs.Unlock()
+		respondError(c, c.Request.Context(), errShuttingDown)
+		return
+	}
+
+	respond(c, http.StatusAccepted, struct{}{})
+}
+
+// getBackfill answers the checkpoint of the latest backfill of an address,
+// with its progress and gaps.
+func getBackfill(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if snapshotStore == nil {
+		respondError(c, c.Request.Context(), errSnapshotsNotStored)
+		return
+	}
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	checkpoint, ok, err := snapshotStore.Checkpoint(c.Request.Context(), chainParam, addressParam)
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+	if !ok {
+		respondError(c, c.Request.Context(), notFound("no backfill of %s was started", addressParam))
+		return
+	}
+
+	backfillJobs.Lock()
+	running := backfillJobs.running[memoryStoreKey(chainParam, addressParam)]
+	backfillJobs.Unlock()
+
+	respond(c, http.StatusOK, struct {
+		BackfillCheckpoint
+		Running bool `json:"running"`
+		Done    bool `json:"done"`
+	}{checkpoint, running, checkpoint.Done()})
+}
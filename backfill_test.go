@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// failingStore fails to store the snapshot of one day, once.
+type failingStore struct {
+	SnapshotStore
+	day time.Time
+}
+
+func (s *failingStore) Put(ctx context.Context, snapshot Snapshot) error {
+	if snapshot.Date.Equal(s.day) {
+		s.day = time.Time{}
+		return errors.New("disk full")
+	}
+	return s.SnapshotStore.Put(ctx, snapshot)
+}
+
+func TestBackfillResume(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	chain := dailyBalanceChain(t, address)
+	chain.Prune(chain.HeightAt(time.Date(2024, 10, 28, 0, 0, 0, 0, time.UTC)))
+	startFakeNode(t, chain)
+
+	attempts := BACKFILL_ATTEMPTS
+	BACKFILL_ATTEMPTS = 1
+	t.Cleanup(func() { BACKFILL_ATTEMPTS = attempts })
+
+	sqlite, err := NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	day := func(month time.Month, day int) time.Time {
+		return time.Date(2024, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	ctx := context.Background()
+	if err := sqlite.Put(ctx, Snapshot{Chain: "testchain", Address: address, Date: day(10, 30), Height: 1, BlockTime: day(10, 30), Daily: true}); err != nil {
+		t.Fatal(err)
+	}
+	store := &failingStore{SnapshotStore: sqlite, day: day(10, 29)}
+
+	checkpoint, err := backfill(ctx, store, "testchain", address, day(10, 26), day(11, 1))
+	if err == nil {
+		t.Fatal("expected the backfill to stop on the 29th")
+	}
+	if !checkpoint.Next.Equal(day(10, 29)) || checkpoint.Stored != 1 || len(checkpoint.Gaps) != 2 {
+		t.Fatalf("unexpected checkpoint %+v", checkpoint)
+	}
+
+	checkpoint, err = backfill(ctx, store, "testchain", address, day(10, 26), day(11, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.Done() || checkpoint.Stored != 4 || checkpoint.Skipped != 1 {
+		t.Fatalf("unexpected checkpoint %+v", checkpoint)
+	}
+	for i, gap := range checkpoint.Gaps {
+		if !gap.Date.Equal(day(10, 26+i)) {
+			t.Errorf("expected a gap on Oct %d, got %s", 26+i, gap.Date)
+		}
+	}
+	if len(checkpoint.Gaps) != 2 {
+		t.Errorf("expected 2 gaps, got %+v", checkpoint.Gaps)
+	}
+
+	stored, ok, err := sqlite.Checkpoint(ctx, "testchain", address)
+	if err != nil || !ok || !stored.Done() || len(stored.Gaps) != 2 {
+		t.Fatalf("unexpected stored checkpoint %+v, %v, %v", stored, ok, err)
+	}
+
+	snapshot, ok, err := sqlite.Daily(ctx, "testchain", address, day(10, 31))
+	if err != nil || !ok {
+		t.Fatalf("expected the snapshot of the 31st, got %v, %v", ok, err)
+	}
+	if got := snapshot.Balances[COSMOSSDK_BANK_BALANCE].String(); got != "4000utest" {
+		t.Errorf("expected 4000utest on the 31st, got %s", got)
+	}
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
+	"context"
 	authv1beta1 "cosmossdk.io/api/cosmos/auth/v1beta1"
 	bankv1beta1 "cosmossdk.io/api/cosmos/bank/v1beta1"
 	distributionv1beta1 "cosmossdk.io/api/cosmos/distribution/v1beta1"
 	stakingv1beta1 "cosmossdk.io/api/cosmos/staking/v1beta1"
 	"cosmossdk.io/api/tendermint/abci"
-	"cosmossdk.io/math"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -22,6 +22,7 @@ import (
 	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -53,7 +54,7 @@ const (
 	SAT
 )
 
-type QueryBalanceFunction func(chain, address string, height int64) (types.Coins, error)
+type QueryBalanceFunction func(ctx context.Context, chain, address string, height int64) (types.Coins, error)
 
 type BalanceSource int
 
@@ -66,6 +67,34 @@ const (
 	COSMOSSDK_AUTH_VESTING
 )
 
+func (s BalanceSource) String() string {
+	switch s {
+	case COSMOSSDK_BANK_BALANCE:
+		return "bank"
+	case COSMOSSDK_STAKING_DELEGATION:
+		return "delegation"
+	case COSMOSSDK_STAKING_UNBONDING:
+		return "unbonding"
+	case COSMOSSDK_DISTRIBUTION_REWARD:
+		return "reward"
+	case COSMOSSDK_DISTRIBUTION_COMMISSION:
+		return "commission"
+	case COSMOSSDK_AUTH_VESTING:
+		return "vesting"
+	}
+	return strconv.Itoa(int(s))
+}
+
+// parseBalanceSource is the inverse of BalanceSource.String.
+func parseBalanceSource(name string) (BalanceSource, error) {
+	for s := COSMOSSDK_BANK_BALANCE; s <= COSMOSSDK_AUTH_VESTING; s++ {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, errors.Errorf("unknown balance source %s", name)
+}
+
 var (
 	methods = map[BalanceSource]QueryBalanceFunction{
 		COSMOSSDK_BANK_BALANCE:        queryBankAllBalances,
@@ -77,19 +106,43 @@ var (
 	}
 )
 
-func queryEveryBalances(chain, address string, height int64) (map[BalanceSource]types.Coins, error) {
+func queryEveryBalances(ctx context.Context, chain, address string, height int64) (map[BalanceSource]types.Coins, error) {
+	result, _, err := queryBalanceSources(ctx, chain, address, height)
+	return result, err
+}
+
+// queryCompleteBalances is queryEveryBalances failing when any source fails,
+// for balances that are stored rather than answered right away.
+func queryCompleteBalances(ctx context.Context, chain, address string, height int64) (map[BalanceSource]types.Coins, error) {
+	result, failed, err := queryBalanceSources(ctx, chain, address, height)
+	if err != nil {
+		return nil, err
+	}
+	for source := COSMOSSDK_BANK_BALANCE; source <= COSMOSSDK_AUTH_VESTING; source++ {
+		if err, ok := failed[source]; ok {
+			return nil, errors.Wrapf(err, "failed to query the %s balance", source)
+		}
+	}
+	return result, nil
+}
+
+// queryBalanceSources queries every balance source at height. A source that
+// fails is logged and left empty in the result, and its error returned
+// aside.
+func queryBalanceSources(ctx context.Context, chain, address string, height int64) (map[BalanceSource]types.Coins, map[BalanceSource]error, error) {
 
 	var (
 		wg     = sync.WaitGroup{}
 		mtx    = sync.Mutex{}
 		result = make(map[BalanceSource]types.Coins)
+		failed = make(map[BalanceSource]error)
 	)
 	for source, method := range methods {
 		wg.Add(1)
 		go func(source BalanceSource, m QueryBalanceFunction) {
 			defer wg.Done()
 
-			coins, err := m(chain, address, height)
+			coins, err := balanceCache.Query(ctx, source, coalesceBalance(source, m), chain, address, height)
 			if err != nil {
 				log.WithFields(log.Fields{
 					"func": runtime.FuncForPC(reflect.ValueOf(m).Pointer()).Name(),
@@ -97,6 +150,9 @@ func queryEveryBalances(chain, address string, height int64) (map[BalanceSource]
 			}
 			mtx.Lock()
 			result[source] = coins
+			if err != nil {
+				failed[source] = err
+			}
 			mtx.Unlock()
 
 		}(source, method)
@@ -104,10 +160,53 @@ func queryEveryBalances(chain, address string, height int64) (map[BalanceSource]
 
 	wg.Wait()
 
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return result, failed, nil
+}
+
+// isPrunedError reports whether err comes from a node that no longer keeps
+// the blocks or the state at the height queried.
+func isPrunedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, pruned := range []string{
+		"version does not exist",
+		"is not available, lowest height is",
+		"could not find results for height",
+	} {
+		if strings.Contains(msg, pruned) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryDailyBalances queries every balance source at each of heights, failing
+// when any source fails.
+func queryDailyBalances(ctx context.Context, chain, address string, heights map[time.Time]int64) (map[time.Time]map[BalanceSource]types.Coins, error) {
+	var result = make(map[time.Time]map[BalanceSource]types.Coins)
+	for day, height := range heights {
+		if snapshot, ok := storedDailySnapshot(ctx, chain, address, day); ok {
+			result[day] = snapshot.Balances
+			continue
+		}
+
+		coins, err := queryCompleteBalances(ctx, chain, address, height)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query balances at height %d", height)
+		}
+		result[day] = coins
+	}
+
 	return result, nil
 }
 
-func queryBankAllBalances(chain, address string, height int64) (types.Coins, error) {
+func queryBankAllBalances(ctx context.Context, chain, address string, height int64) (types.Coins, error) {
 
 	msg := banktypes.QueryAllBalancesRequest{
 		Address: address,
@@ -128,22 +227,24 @@ func queryBankAllBalances(chain, address string, height int64) (types.Coins, err
 	log.Debug("Hex-encoded Protobuf data: 0x%s\n", hexString)
 
 	var resp []byte
-	if c, exists := cfg.Chains[chain]; exists {
-		req := abci.RequestQuery{
-			Data:   b,
-			Path:   bankv1beta1.Query_AllBalances_FullMethodName,
-			Height: height,
-		}
-
-		resp, err = c.Client.Query(ABCI_QUERY_PATH, map[string]string{
-			"data":   fmt.Sprintf("0x%x", req.Data),
-			"path":   fmt.Sprintf("\"%s\"", req.Path),
-			"prove":  fmt.Sprintf("%t", req.Prove),
-			"height": fmt.Sprintf("%d", req.Height),
-		})
-		if err != nil {
-			return nil, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	req := abci.RequestQuery{
+		Data:   b,
+		Path:   bankv1beta1.Query_AllBalances_FullMethodName,
+		Height: height,
+	}
+
+	resp, err = c.Client.Query(ctx, ABCI_QUERY_PATH, map[string]string{
+		"data":   fmt.Sprintf("0x%x", req.Data),
+		"path":   fmt.Sprintf("\"%s\"", req.Path),
+		"prove":  fmt.Sprintf("%t", req.Prove),
+		"height": fmt.Sprintf("%d", req.Height),
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var abciResponse = &ABCIQueryResult{}
@@ -156,6 +257,10 @@ func queryBankAllBalances(chain, address string, height int64) (types.Coins, err
 		return nil, errors.New("request didn't complete successfully")
 	}
 
+	if abciResponse.Result.Response.Code != 0 {
+		return nil, errors.Errorf("query failed with code %d: %s", abciResponse.Result.Response.Code, abciResponse.Result.Response.Log)
+	}
+
 	var allBalancesResponse = &banktypes.QueryAllBalancesResponse{}
 	x, err := base64.StdEncoding.DecodeString(abciResponse.Result.Response.Value)
 
@@ -173,7 +278,7 @@ func queryBankAllBalances(chain, address string, height int64) (types.Coins, err
 	return coins, nil
 }
 
-func queryStakingDelegatorUnbondingDelegations(chain, address string, height int64) (types.Coins, error) {
+func queryStakingDelegatorUnbondingDelegations(ctx context.Context, chain, address string, height int64) (types.Coins, error) {
 
 	if cfg.Chains[chain].StakingTokenDenom == "" {
 		return nil, errors.New("stakingTokenDenom must be set")
@@ -198,22 +303,24 @@ func queryStakingDelegatorUnbondingDelegations(chain, address string, height int
 	log.Debug("Hex-encoded Protobuf data: 0x%s\n", hexString)
 
 	var resp []byte
-	if c, exists := cfg.Chains[chain]; exists {
-		req := abci.RequestQuery{
-			Data:   b,
-			Path:   stakingv1beta1.Query_DelegatorUnbondingDelegations_FullMethodName,
-			Height: height,
-		}
-
-		resp, err = c.Client.Query(ABCI_QUERY_PATH, map[string]string{
-			"data":   fmt.Sprintf("0x%x", req.Data),
-			"path":   fmt.Sprintf("\"%s\"", req.Path),
-			"prove":  fmt.Sprintf("%t", req.Prove),
-			"height": fmt.Sprintf("%d", req.Height),
-		})
-		if err != nil {
-			return nil, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	req := abci.RequestQuery{
+		Data:   b,
+		Path:   stakingv1beta1.Query_DelegatorUnbondingDelegations_FullMethodName,
+		Height: height,
+	}
+
+	resp, err = c.Client.Query(ctx, ABCI_QUERY_PATH, map[string]string{
+		"data":   fmt.Sprintf("0x%x", req.Data),
+		"path":   fmt.Sprintf("\"%s\"", req.Path),
+		"prove":  fmt.Sprintf("%t", req.Prove),
+		"height": fmt.Sprintf("%d", req.Height),
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var abciResponse = &ABCIQueryResult{}
@@ -226,6 +333,10 @@ func queryStakingDelegatorUnbondingDelegations(chain, address string, height int
 		return nil, errors.New("request didn't complete successfully")
 	}
 
+	if abciResponse.Result.Response.Code != 0 {
+		return nil, errors.Errorf("query failed with code %d: %s", abciResponse.Result.Response.Code, abciResponse.Result.Response.Log)
+	}
+
 	var unbonding = &stakingtypes.QueryDelegatorUnbondingDelegationsResponse{}
 	x, err := base64.StdEncoding.DecodeString(abciResponse.Result.Response.Value)
 
@@ -244,10 +355,10 @@ func queryStakingDelegatorUnbondingDelegations(chain, address string, height int
 		}
 	}
 
-	return coins, nil
+	return sumCoins(coins), nil
 }
 
-func queryStakingDelegatorDelegations(chain, address string, height int64) (types.Coins, error) {
+func queryStakingDelegatorDelegations(ctx context.Context, chain, address string, height int64) (types.Coins, error) {
 
 	msg := stakingtypes.QueryDelegatorDelegationsRequest{
 		DelegatorAddr: address,
@@ -268,22 +379,24 @@ func queryStakingDelegatorDelegations(chain, address string, height int64) (type
 	log.Debug("Hex-encoded Protobuf data: 0x%s\n", hexString)
 
 	var resp []byte
-	if c, exists := cfg.Chains[chain]; exists {
-		req := abci.RequestQuery{
-			Data:   b,
-			Path:   stakingv1beta1.Query_DelegatorDelegations_FullMethodName,
-			Height: height,
-		}
-
-		resp, err = c.Client.Query(ABCI_QUERY_PATH, map[string]string{
-			"data":   fmt.Sprintf("0x%x", req.Data),
-			"path":   fmt.Sprintf("\"%s\"", req.Path),
-			"prove":  fmt.Sprintf("%t", req.Prove),
-			"height": fmt.Sprintf("%d", req.Height),
-		})
-		if err != nil {
-			return nil, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	req := abci.RequestQuery{
+		Data:   b,
+		Path:   stakingv1beta1.Query_DelegatorDelegations_FullMethodName,
+		Height: height,
+	}
+
+	resp, err = c.Client.Query(ctx, ABCI_QUERY_PATH, map[string]string{
+		"data":   fmt.Sprintf("0x%x", req.Data),
+		"path":   fmt.Sprintf("\"%s\"", req.Path),
+		"prove":  fmt.Sprintf("%t", req.Prove),
+		"height": fmt.Sprintf("%d", req.Height),
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var abciResponse = &ABCIQueryResult{}
@@ -296,6 +409,10 @@ func queryStakingDelegatorDelegations(chain, address string, height int64) (type
 		return nil, errors.New("request didn't complete successfully")
 	}
 
+	if abciResponse.Result.Response.Code != 0 {
+		return nil, errors.Errorf("query failed with code %d: %s", abciResponse.Result.Response.Code, abciResponse.Result.Response.Log)
+	}
+
 	var delegations = &stakingtypes.QueryDelegatorDelegationsResponse{}
 	x, err := base64.StdEncoding.DecodeString(abciResponse.Result.Response.Value)
 
@@ -309,10 +426,44 @@ func queryStakingDelegatorDelegations(chain, address string, height int64) (type
 		coins = append(coins, delegation.Balance)
 	}
 
-	return coins, nil
+	return sumCoins(coins), nil
+}
+
+// queryDistributionDelegationRewards returns the outstanding rewards of address
+// across validators. Rewards are decimal coins; the fractions can't be
+// withdrawn and are truncated.
+func queryDistributionDelegationRewards(ctx context.Context, chain, address string, height int64) (types.Coins, error) {
+	rewardResponse, err := queryDistributionDelegationTotalRewards(ctx, chain, address, height)
+	if err != nil {
+		return nil, err
+	}
+
+	var coins = types.Coins{}
+	for _, rewards := range rewardResponse.Rewards {
+		for _, dcoin := range rewards.Reward {
+			coins = append(coins, types.Coin{
+				Denom:  dcoin.Denom,
+				Amount: dcoin.Amount.TruncateInt(),
+			})
+		}
+	}
+
+	return sumCoins(coins), nil
+}
+
+// sumCoins merges the per-validator entries of coins into one sorted amount
+// per denom, the form types.Coins methods such as Add and AmountOf expect.
+func sumCoins(coins types.Coins) types.Coins {
+	var sum = types.NewCoins()
+	for _, coin := range coins {
+		sum = sum.Add(coin)
+	}
+	return sum
 }
 
-func queryDistributionDelegationRewards(chain, address string, height int64) (types.Coins, error) {
+// queryDistributionDelegationTotalRewards returns the outstanding rewards of
+// address per validator.
+func queryDistributionDelegationTotalRewards(ctx context.Context, chain, address string, height int64) (*distributiontypes.QueryDelegationTotalRewardsResponse, error) {
 
 	msg := distributiontypes.QueryDelegationTotalRewardsRequest{
 		DelegatorAddress: address,
@@ -327,22 +478,24 @@ func queryDistributionDelegationRewards(chain, address string, height int64) (ty
 	log.Debug("Hex-encoded Protobuf data: 0x%s\n", hexString)
 
 	var resp []byte
-	if c, exists := cfg.Chains[chain]; exists {
-		req := abci.RequestQuery{
-			Data:   b,
-			Path:   distributionv1beta1.Query_DelegationRewards_FullMethodName,
-			Height: height,
-		}
-
-		resp, err = c.Client.Query(ABCI_QUERY_PATH, map[string]string{
-			"data":   fmt.Sprintf("0x%x", req.Data),
-			"path":   fmt.Sprintf("\"%s\"", req.Path),
-			"prove":  fmt.Sprintf("%t", req.Prove),
-			"height": fmt.Sprintf("%d", req.Height),
-		})
-		if err != nil {
-			return nil, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	req := abci.RequestQuery{
+		Data:   b,
+		Path:   distributionv1beta1.Query_DelegationTotalRewards_FullMethodName,
+		Height: height,
+	}
+
+	resp, err = c.Client.Query(ctx, ABCI_QUERY_PATH, map[string]string{
+		"data":   fmt.Sprintf("0x%x", req.Data),
+		"path":   fmt.Sprintf("\"%s\"", req.Path),
+		"prove":  fmt.Sprintf("%t", req.Prove),
+		"height": fmt.Sprintf("%d", req.Height),
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var abciResponse = &ABCIQueryResult{}
@@ -355,6 +508,10 @@ func queryDistributionDelegationRewards(chain, address string, height int64) (ty
 		return nil, errors.New("request didn't complete successfully")
 	}
 
+	if abciResponse.Result.Response.Code != 0 {
+		return nil, errors.Errorf("query failed with code %d: %s", abciResponse.Result.Response.Code, abciResponse.Result.Response.Log)
+	}
+
 	var rewardResponse = &distributiontypes.QueryDelegationTotalRewardsResponse{}
 	x, err := base64.StdEncoding.DecodeString(abciResponse.Result.Response.Value)
 
@@ -363,20 +520,10 @@ func queryDistributionDelegationRewards(chain, address string, height int64) (ty
 		return nil, err
 	}
 
-	var coins = types.Coins{}
-	for _, rewards := range rewardResponse.Rewards {
-		for _, dcoin := range rewards.Reward {
-			coins = append(coins, types.Coin{
-				Denom:  dcoin.Denom,
-				Amount: math.Int(dcoin.Amount),
-			})
-		}
-	}
-
-	return coins, nil
+	return rewardResponse, nil
 }
 
-func queryAccountInfo(chain, address string, height int64) (*authtypes.QueryAccountInfoResponse, error) {
+func queryAccountInfo(ctx context.Context, chain, address string, height int64) (*authtypes.QueryAccountInfoResponse, error) {
 	accountInfoReq := authtypes.QueryAccountInfoRequest{
 		Address: address,
 	}
@@ -390,22 +537,24 @@ func queryAccountInfo(chain, address string, height int64) (*authtypes.QueryAcco
 	log.Debug("Hex-encoded Protobuf data: 0x%s\n", hexString)
 
 	var resp []byte
-	if c, exists := cfg.Chains[chain]; exists {
-		req := abci.RequestQuery{
-			Data:   accountInfoReqB,
-			Path:   distributionv1beta1.Query_ValidatorCommission_FullMethodName,
-			Height: height,
-		}
-
-		resp, err = c.Client.Query(ABCI_QUERY_PATH, map[string]string{
-			"data":   fmt.Sprintf("0x%x", req.Data),
-			"path":   fmt.Sprintf("\"%s\"", req.Path),
-			"prove":  fmt.Sprintf("%t", req.Prove),
-			"height": fmt.Sprintf("%d", req.Height),
-		})
-		if err != nil {
-			return nil, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	req := abci.RequestQuery{
+		Data:   accountInfoReqB,
+		Path:   distributionv1beta1.Query_ValidatorCommission_FullMethodName,
+		Height: height,
+	}
+
+	resp, err = c.Client.Query(ctx, ABCI_QUERY_PATH, map[string]string{
+		"data":   fmt.Sprintf("0x%x", req.Data),
+		"path":   fmt.Sprintf("\"%s\"", req.Path),
+		"prove":  fmt.Sprintf("%t", req.Prove),
+		"height": fmt.Sprintf("%d", req.Height),
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var abciResponse = &ABCIQueryResult{}
@@ -418,6 +567,10 @@ func queryAccountInfo(chain, address string, height int64) (*authtypes.QueryAcco
 		return nil, errors.New("request didn't complete successfully")
 	}
 
+	if abciResponse.Result.Response.Code != 0 {
+		return nil, errors.Errorf("query failed with code %d: %s", abciResponse.Result.Response.Code, abciResponse.Result.Response.Log)
+	}
+
 	var accountInfoResponse = &authtypes.QueryAccountInfoResponse{}
 	x, err := base64.StdEncoding.DecodeString(abciResponse.Result.Response.Value)
 
@@ -430,7 +583,7 @@ func queryAccountInfo(chain, address string, height int64) (*authtypes.QueryAcco
 }
 
 // it should be validatorAddress
-func queryDistributionValidatorCommission(chain, address string, height int64) (types.Coins, error) {
+func queryDistributionValidatorCommission(ctx context.Context, chain, address string, height int64) (types.Coins, error) {
 
 	msg := distributiontypes.QueryValidatorCommissionRequest{
 		ValidatorAddress: address,
@@ -446,22 +599,24 @@ func queryDistributionValidatorCommission(chain, address string, height int64) (
 	log.Debug("Hex-encoded Protobuf data: 0x%s\n", hexString)
 
 	var resp []byte
-	if c, exists := cfg.Chains[chain]; exists {
-		req := abci.RequestQuery{
-			Data:   b,
-			Path:   distributionv1beta1.Query_ValidatorCommission_FullMethodName,
-			Height: height,
-		}
-
-		resp, err = c.Client.Query(ABCI_QUERY_PATH, map[string]string{
-			"data":   fmt.Sprintf("0x%x", req.Data),
-			"path":   fmt.Sprintf("\"%s\"", req.Path),
-			"prove":  fmt.Sprintf("%t", req.Prove),
-			"height": fmt.Sprintf("%d", req.Height),
-		})
-		if err != nil {
-			return nil, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	req := abci.RequestQuery{
+		Data:   b,
+		Path:   distributionv1beta1.Query_ValidatorCommission_FullMethodName,
+		Height: height,
+	}
+
+	resp, err = c.Client.Query(ctx, ABCI_QUERY_PATH, map[string]string{
+		"data":   fmt.Sprintf("0x%x", req.Data),
+		"path":   fmt.Sprintf("\"%s\"", req.Path),
+		"prove":  fmt.Sprintf("%t", req.Prove),
+		"height": fmt.Sprintf("%d", req.Height),
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var abciResponse = &ABCIQueryResult{}
@@ -474,6 +629,10 @@ func queryDistributionValidatorCommission(chain, address string, height int64) (
 		return nil, errors.New("request didn't complete successfully")
 	}
 
+	if abciResponse.Result.Response.Code != 0 {
+		return nil, errors.Errorf("query failed with code %d: %s", abciResponse.Result.Response.Code, abciResponse.Result.Response.Log)
+	}
+
 	var allBalancesResponse = &distributiontypes.QueryValidatorCommissionResponse{}
 	x, err := base64.StdEncoding.DecodeString(abciResponse.Result.Response.Value)
 
@@ -487,14 +646,14 @@ func queryDistributionValidatorCommission(chain, address string, height int64) (
 	for _, dcoin := range allBalancesResponse.Commission.Commission {
 		coins = append(coins, types.Coin{
 			Denom:  dcoin.Denom,
-			Amount: math.Int(dcoin.Amount),
+			Amount: dcoin.Amount.TruncateInt(),
 		})
 	}
 
 	return coins, nil
 }
 
-func queryAuthVesting(chain, address string, height int64) (*authtypes.QueryAccountResponse, error) {
+func queryAuthVesting(ctx context.Context, chain, address string, height int64) (*authtypes.QueryAccountResponse, error) {
 
 	msg := authtypes.QueryAccountRequest{
 		Address: address,
@@ -510,22 +669,24 @@ func queryAuthVesting(chain, address string, height int64) (*authtypes.QueryAcco
 	log.Debug("Hex-encoded Protobuf data: 0x%s\n", hexString)
 
 	var resp []byte
-	if c, exists := cfg.Chains[chain]; exists {
-		req := abci.RequestQuery{
-			Data:   b,
-			Path:   authv1beta1.Query_Accounts_FullMethodName,
-			Height: height,
-		}
-
-		resp, err = c.Client.Query(ABCI_QUERY_PATH, map[string]string{
-			"data":   fmt.Sprintf("0x%x", req.Data),
-			"path":   fmt.Sprintf("\"%s\"", req.Path),
-			"prove":  fmt.Sprintf("%t", req.Prove),
-			"height": fmt.Sprintf("%d", req.Height),
-		})
-		if err != nil {
-			return nil, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	req := abci.RequestQuery{
+		Data:   b,
+		Path:   authv1beta1.Query_Accounts_FullMethodName,
+		Height: height,
+	}
+
+	resp, err = c.Client.Query(ctx, ABCI_QUERY_PATH, map[string]string{
+		"data":   fmt.Sprintf("0x%x", req.Data),
+		"path":   fmt.Sprintf("\"%s\"", req.Path),
+		"prove":  fmt.Sprintf("%t", req.Prove),
+		"height": fmt.Sprintf("%d", req.Height),
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	var abciResponse = &ABCIQueryResult{}
@@ -538,6 +699,10 @@ func queryAuthVesting(chain, address string, height int64) (*authtypes.QueryAcco
 		return nil, errors.New("request didn't complete successfully")
 	}
 
+	if abciResponse.Result.Response.Code != 0 {
+		return nil, errors.Errorf("query failed with code %d: %s", abciResponse.Result.Response.Code, abciResponse.Result.Response.Log)
+	}
+
 	var allBalancesResponse = &authtypes.QueryAccountResponse{}
 	x, err := base64.StdEncoding.DecodeString(abciResponse.Result.Response.Value)
 
@@ -549,20 +714,27 @@ func queryAuthVesting(chain, address string, height int64) (*authtypes.QueryAcco
 	return allBalancesResponse, nil
 }
 
-func GetBlockTime(chain string, height int64) (*time.Time, error) {
+func GetBlockTime(ctx context.Context, chain string, height int64) (*time.Time, error) {
+	return coalesce(ctx, chain, "block_time", strconv.FormatInt(height, 10), func(ctx context.Context) (*time.Time, error) {
+		return getBlockTime(ctx, chain, height)
+	})
+}
+
+func getBlockTime(ctx context.Context, chain string, height int64) (*time.Time, error) {
 
 	var (
 		resp []byte
 		err  error
 	)
-	if c, exists := cfg.Chains[chain]; exists {
-
-		resp, err = c.Client.Query(BLOCK_PATH, map[string]string{
-			"height": fmt.Sprintf("%d", height),
-		})
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to query /block?height=%d", height)
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
+	}
+	resp, err = c.Client.Query(ctx, BLOCK_PATH, map[string]string{
+		"height": fmt.Sprintf("%d", height),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query /block?height=%d", height)
 	}
 
 	var r = &BlockResponse{}
@@ -580,29 +752,64 @@ func GetBlockTime(chain string, height int64) (*time.Time, error) {
 	return &parsedTime, nil
 }
 
-func GetLatestHeight(chain string) (int64, error) {
+func GetLatestHeight(ctx context.Context, chain string) (int64, error) {
+	return coalesce(ctx, chain, "latest_height", "", func(ctx context.Context) (int64, error) {
+		return getLatestHeight(ctx, chain)
+	})
+}
+
+func getLatestHeight(ctx context.Context, chain string) (int64, error) {
+	status, err := getNodeStatus(ctx, chain)
+	if err != nil {
+		return 0, err
+	}
+
+	latestHeight, err := strconv.ParseInt(status.SyncInfo.LatestBlockHeight, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	return latestHeight, nil
+}
+
+// getEarliestBlock returns the earliest height the node of chain keeps and
+// its block time.
+func getEarliestBlock(ctx context.Context, chain string) (int64, time.Time, error) {
+	status, err := getNodeStatus(ctx, chain)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	earliestHeight, err := strconv.ParseInt(status.SyncInfo.EarliestBlockHeight, 0, 64)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	earliestBlockTime, err := time.Parse(time.RFC3339Nano, status.SyncInfo.EarliestBlockTime)
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "failed to parse earliest_block_time")
+	}
+	return earliestHeight, earliestBlockTime, nil
+}
+
+// getNodeStatus returns the /status of the node of chain.
+func getNodeStatus(ctx context.Context, chain string) (*StatusResult, error) {
 
 	var (
 		resp []byte
 		err  error
 	)
-	if c, exists := cfg.Chains[chain]; exists {
-
-		resp, err = c.Client.Query(STATUS_PATH, map[string]string{})
-		if err != nil {
-			return 0, err
-		}
+	c, exists := cfg.Chains[chain]
+	if !exists {
+		return nil, unknownChain(chain)
 	}
-
-	var r = &StatusResponse{}
-	err = json.Unmarshal(resp, r)
+	resp, err = c.Client.Query(ctx, STATUS_PATH, map[string]string{})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	latestHeight, err := strconv.ParseInt(r.Result.SyncInfo.LatestBlockHeight, 0, 64)
+	var r = &StatusResponse{}
+	err = json.Unmarshal(resp, r)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return latestHeight, nil
+	return &r.Result, nil
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"cosmos-balance-collector/fakenode"
+	"cosmossdk.io/math"
+	"github.com/cosmos/cosmos-sdk/types"
+	"testing"
+	"time"
+)
+
+// syntheticBalances are the balances in the synthetic fixtures, see
+// main_test.go.
+var syntheticBalances = []struct {
+	chain             string
+	stakingTokenDenom string
+	address           string
+	height            int64
+	bank              string
+	delegations       string
+	unbonding         string
+	rewards           string
+}{
+	{
+		chain:             "osmosis",
+		stakingTokenDenom: "uosmo",
+		address:           "osmo1009yj3rp6e46w44r8rhnn6h4mq4z0gu3js9aky",
+		height:            27000000,
+		bank:              "5000000ibc/27394FB092D2ECCD56123C74F36E4C1F926001CEADA9CA97EA622B25F41E5EB2,1234567890uosmo",
+		delegations:       "125000000uosmo",
+		unbonding:         "10000000uosmo",
+		rewards:           "1542uosmo",
+	},
+	{
+		chain:             "injective",
+		stakingTokenDenom: "inj",
+		address:           "inj1qna7jl5q9jhau7845dr8yttd56a3ec8j5zs4t8",
+		height:            90000000,
+		bank:              "5250000000000000000inj,1500000000peggy0xdAC17F958D2ee523a2206206994597C13D831ec7",
+		delegations:       "100000000000000000000inj",
+		unbonding:         "",
+		rewards:           "512345678901234567inj",
+	},
+	{
+		chain:             "celestia",
+		stakingTokenDenom: "utia",
+		address:           "celestia1qsmy9lqra0907w847ldhjcep8lmmp4rs8vfsw9",
+		height:            2500000,
+		bank:              "987654321utia",
+		delegations:       "750000000utia",
+		unbonding:         "50000000utia",
+		rewards:           "6481utia",
+	},
+}
+
+func TestQueryBalanceSources(t *testing.T) {
+	for _, f := range syntheticBalances {
+		for _, tc := range []struct {
+			name     string
+			method   QueryBalanceFunction
+			expected string
+		}{
+			{"bank", queryBankAllBalances, f.bank},
+			{"delegations", queryStakingDelegatorDelegations, f.delegations},
+			{"unbonding", queryStakingDelegatorUnbondingDelegations, f.unbonding},
+			{"rewards", queryDistributionDelegationRewards, f.rewards},
+		} {
+			t.Run(f.chain+"/"+tc.name, func(t *testing.T) {
+				useFixture(t, f.chain, f.stakingTokenDenom)
+
+				coins, err := tc.method(context.Background(), f.chain, f.address, f.height)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if coins.String() != tc.expected {
+					t.Errorf("expected %q, got %q", tc.expected, coins.String())
+				}
+			})
+		}
+	}
+}
+
+func TestQueryEveryBalances(t *testing.T) {
+	for _, f := range syntheticBalances {
+		t.Run(f.chain, func(t *testing.T) {
+			useFixture(t, f.chain, f.stakingTokenDenom)
+
+			result, err := queryEveryBalances(context.Background(), f.chain, f.address, f.height)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for source, expected := range map[BalanceSource]string{
+				COSMOSSDK_BANK_BALANCE:        f.bank,
+				COSMOSSDK_STAKING_DELEGATION:  f.delegations,
+				COSMOSSDK_STAKING_UNBONDING:   f.unbonding,
+				COSMOSSDK_DISTRIBUTION_REWARD: f.rewards,
+			} {
+				if result[source].String() != expected {
+					t.Errorf("source %d: expected %q, got %q", source, expected, result[source].String())
+				}
+			}
+		})
+	}
+}
+
+func TestQueryUnbondingRequiresStakingTokenDenom(t *testing.T) {
+	useFixture(t, "celestia", "")
+
+	_, err := queryStakingDelegatorUnbondingDelegations(context.Background(), "celestia", syntheticBalances[2].address, syntheticBalances[2].height)
+	if err == nil {
+		t.Fatal("expected an error without stakingTokenDenom")
+	}
+}
+
+func TestQueryPrunedHeight(t *testing.T) {
+	useFixture(t, "osmosis", "uosmo")
+
+	_, err := queryBankAllBalances(context.Background(), "osmosis", syntheticBalances[0].address, 100)
+	if err == nil {
+		t.Fatal("expected an error for a pruned height")
+	}
+}
+
+func TestQueryDecimalRewards(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	first, second := e2eAddress(t, "cosmosvaloper", 2), e2eAddress(t, "cosmosvaloper", 3)
+
+	chain := fakenode.NewChain("testchain-1", e2eGenesis, 6*time.Second)
+	chain.SetLatestHeight(10)
+	chain.SetRewards(address, first, 1, types.NewDecCoins(types.NewDecCoinFromDec("utest", math.LegacyMustNewDecFromStr("12.75"))))
+	chain.SetRewards(address, second, 1, types.NewDecCoins(types.NewDecCoinFromDec("utest", math.LegacyMustNewDecFromStr("0.5"))))
+	chain.SetCommission(first, 1, types.NewDecCoins(types.NewDecCoinFromDec("utest", math.LegacyMustNewDecFromStr("3.999"))))
+	startFakeNode(t, chain)
+
+	// The node only answers the DelegationTotalRewards method, and each
+	// validator's fraction is dropped.
+	rewards, err := queryDistributionDelegationRewards(context.Background(), "testchain", address, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewards.String() != "12utest" {
+		t.Errorf("expected 12utest of rewards, got %s", rewards)
+	}
+
+	commission, err := queryDistributionValidatorCommission(context.Background(), "testchain", first, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commission.String() != "3utest" {
+		t.Errorf("expected 3utest of commission, got %s", commission)
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var balanceCache *BalanceCache
+
+// CacheKey identifies a balance at a past height. Balances at a committed
+// height never change, so entries never expire and are only evicted for space.
+type CacheKey struct {
+	Chain   string        `json:"chain"`
+	Source  BalanceSource `json:"source"`
+	Address string        `json:"address"`
+	Height  int64         `json:"height"`
+}
+
+type cacheEntry struct {
+	Key   CacheKey    `json:"key"`
+	Coins types.Coins `json:"coins"`
+}
+
+type CacheStats struct {
+	Size         int    `json:"size"`
+	Capacity     int    `json:"capacity"`
+	DiskEntries  int    `json:"diskEntries"`
+	DiskCapacity int    `json:"diskCapacity,omitempty"`
+	Hits         uint64 `json:"hits"`
+	DiskHits     uint64 `json:"diskHits"`
+	Misses       uint64 `json:"misses"`
+}
+
+// BalanceCache is an in-memory LRU of historical balances, optionally backed by
+// a directory that keeps entries evicted from memory and survives restarts.
+// The directory holds at most diskCapacity entries; beyond that, the files
+// least recently written or read are removed.
+type BalanceCache struct {
+	mtx      sync.Mutex
+	capacity int
+	entries  map[CacheKey]*list.Element
+	lru      *list.List
+	dir      string
+	// generation changes with every Purge, so that a Get that read a file
+	// before the purge doesn't bring the entry back into memory.
+	generation uint64
+
+	diskCapacity int
+	// diskEntries approximates the number of files; every eviction counts
+	// them again.
+	diskEntries int
+	// evicting is held by the eviction of files in progress.
+	evicting sync.Mutex
+
+	hits     uint64
+	diskHits uint64
+	misses   uint64
+}
+
+func NewBalanceCache(capacity int, dir string, diskCapacity int) (*BalanceCache, error) {
+	if capacity <= 0 {
+		return nil, errors.New("cache size must be positive")
+	}
+
+	c := &BalanceCache{
+		capacity:     capacity,
+		entries:      make(map[CacheKey]*list.Element),
+		lru:          list.New(),
+		dir:          dir,
+		diskCapacity: diskCapacity,
+	}
+
+	if dir != "" {
+		if diskCapacity <= 0 {
+			return nil, errors.New("cache disk size must be positive")
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, errors.Wrap(err, "failed to create cache directory")
+		}
+		files, err := c.files()
+		if err != nil {
+			return nil, err
+		}
+		c.diskEntries = len(files)
+		c.evict()
+	}
+
+	return c, nil
+}
+
+// Query serves a historical balance from the cache, calling m on a miss.
+// Latest-height queries (height 0) always go to the node.
+func (c *BalanceCache) Query(ctx context.Context, source BalanceSource, m QueryBalanceFunction, chain, address string, height int64) (types.Coins, error) {
+	if c == nil || height <= 0 {
+		return m(ctx, chain, address, height)
+	}
+
+	key := CacheKey{
+		Chain:   chain,
+		Source:  source,
+		Address: address,
+		Height:  height,
+	}
+
+	if coins, ok := c.Get(key); ok {
+		return coins, nil
+	}
+
+	coins, err := m(ctx, chain, address, height)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Add(key, coins)
+	return coins, nil
+}
+
+// Get looks key up in memory, then on disk. The mutex guards the memory tier
+// only; files are read and written without it so that disk I/O doesn't block
+// other lookups.
+func (c *BalanceCache) Get(key CacheKey) (types.Coins, bool) {
+	c.mtx.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		c.mtx.Unlock()
+		cacheLookups.WithLabelValues(key.Chain, "hit").Inc()
+		return e.Value.(*cacheEntry).Coins, true
+	}
+	generation := c.generation
+	c.mtx.Unlock()
+
+	if c.dir != "" {
+		path := c.path(key)
+		entry, err := c.readFile(path)
+		if err == nil && entry.Key == key {
+			// Reading a file counts as a use for the eviction of files.
+			now := time.Now()
+			os.Chtimes(path, now, now)
+
+			c.mtx.Lock()
+			if c.generation == generation {
+				c.add(entry)
+			}
+			c.diskHits++
+			c.mtx.Unlock()
+			cacheLookups.WithLabelValues(key.Chain, "disk_hit").Inc()
+			return entry.Coins, true
+		}
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			log.Warningf("failed to read cache entry: %s", err)
+		}
+	}
+
+	c.mtx.Lock()
+	c.misses++
+	c.mtx.Unlock()
+	cacheLookups.WithLabelValues(key.Chain, "miss").Inc()
+	return nil, false
+}
+
+func (c *BalanceCache) Add(key CacheKey, coins types.Coins) {
+	entry := &cacheEntry{Key: key, Coins: coins}
+
+	c.mtx.Lock()
+	c.add(entry)
+	c.mtx.Unlock()
+
+	if c.dir != "" {
+		created, err := c.writeFile(entry)
+		if err != nil {
+			log.Warningf("failed to write cache entry: %s", err)
+			return
+		}
+		if created {
+			c.mtx.Lock()
+			c.diskEntries++
+			full := c.diskEntries > c.diskCapacity
+			c.mtx.Unlock()
+			if full {
+				c.evict()
+			}
+		}
+	}
+}
+
+// evict removes the files least recently written or read until a tenth of
+// the disk capacity is free, so that a full cache isn't listed on every Add.
+// An eviction already in progress makes it return at once.
+func (c *BalanceCache) evict() {
+	if !c.evicting.TryLock() {
+		return
+	}
+	defer c.evicting.Unlock()
+
+	files, err := c.files()
+	if err != nil {
+		log.Warningf("failed to evict cache entries: %s", err)
+		return
+	}
+	var count = len(files)
+	if count > c.diskCapacity {
+		var modified = make(map[string]time.Time, count)
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				modified[file] = info.ModTime()
+			}
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			return modified[files[i]].Before(modified[files[j]])
+		})
+
+		for _, file := range files[:count-(c.diskCapacity-c.diskCapacity/10)] {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Warningf("failed to evict cache entry: %s", err)
+				continue
+			}
+			count--
+		}
+	}
+
+	c.mtx.Lock()
+	c.diskEntries = count
+	c.mtx.Unlock()
+}
+
+func (c *BalanceCache) add(entry *cacheEntry) {
+	if e, ok := c.entries[entry.Key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.entries[entry.Key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).Key)
+	}
+}
+
+// Keys lists the keys held in memory that match filter.
+func (c *BalanceCache) Keys(filter func(CacheKey) bool) []CacheKey {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var keys []CacheKey
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		key := e.Value.(*cacheEntry).Key
+		if filter(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Purge removes every entry matching filter from disk and memory and returns
+// how many were removed. A concurrent Get may still return an entry it read
+// before its file was removed, but doesn't keep it in memory.
+func (c *BalanceCache) Purge(filter func(CacheKey) bool) (int, error) {
+	var (
+		purged  = make(map[CacheKey]struct{})
+		removed int
+	)
+
+	if c.dir != "" {
+		files, err := c.files()
+		if err != nil {
+			return 0, err
+		}
+		for _, file := range files {
+			entry, err := c.readFile(file)
+			if err != nil {
+				log.Warningf("failed to read cache entry: %s", err)
+				continue
+			}
+			if !filter(entry.Key) {
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				return len(purged), errors.Wrap(err, "failed to remove cache entry")
+			}
+			purged[entry.Key] = struct{}{}
+			removed++
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.generation++
+	c.diskEntries -= removed
+
+	for key, e := range c.entries {
+		if filter(key) {
+			c.lru.Remove(e)
+			delete(c.entries, key)
+			purged[key] = struct{}{}
+		}
+	}
+
+	return len(purged), nil
+}
+
+func (c *BalanceCache) Stats() (CacheStats, error) {
+	c.mtx.Lock()
+	stats := CacheStats{
+		Size:         c.lru.Len(),
+		Capacity:     c.capacity,
+		DiskCapacity: c.diskCapacity,
+		Hits:         c.hits,
+		DiskHits:     c.diskHits,
+		Misses:       c.misses,
+	}
+	c.mtx.Unlock()
+
+	if c.dir != "" {
+		files, err := c.files()
+		if err != nil {
+			return stats, err
+		}
+		stats.DiskEntries = len(files)
+	}
+
+	return stats, nil
+}
+
+// HitRatio is the share of lookups served from memory or disk. Unlike Stats it
+// doesn't list the disk tier.
+func (c *BalanceCache) HitRatio() float64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	lookups := c.hits + c.diskHits + c.misses
+	if lookups == 0 {
+		return 0
+	}
+	return float64(c.hits+c.diskHits) / float64(lookups)
+}
+
+func (c *BalanceCache) path(key CacheKey) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%s/%d", key.Chain, key.Source, key.Address, key.Height)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *BalanceCache) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list cache directory")
+	}
+	return files, nil
+}
+
+func (c *BalanceCache) readFile(path string) (*cacheEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var entry = &cacheEntry{}
+	if err := json.Unmarshal(b, entry); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", path)
+	}
+	return entry, nil
+}
+
+// writeFile writes entry to its file and reports whether the file is new.
+func (c *BalanceCache) writeFile(entry *cacheEntry) (bool, error) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	// Concurrent writers of one key each write their own temporary file.
+	path := c.path(entry.Key)
+	tmp, err := os.CreateTemp(c.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	_, err = os.Stat(path)
+	created := os.IsNotExist(err)
+	return created, os.Rename(tmp.Name(), path)
+}
+
+// cacheFilter matches entries against the optional chain and address query
+// parameters.
+func cacheFilter(c *gin.Context) func(CacheKey) bool {
+	chain := c.Query("chain")
+	address := c.Query("address")
+
+	return func(key CacheKey) bool {
+		return (chain == "" || key.Chain == chain) &&
+			(address == "" || strings.EqualFold(key.Address, address))
+	}
+}
+
+func getCache(c *gin.Context) {
+	if balanceCache == nil {
+		respondError(c, c.Request.Context(), notFound("cache is disabled"))
+		return
+	}
+
+	stats, err := balanceCache.Stats()
+	if err != nil {
+		respondError(c, c.Request.Context(), errors.Wrap(err, "failed to inspect cache"))
+		return
+	}
+
+	respond(c, http.StatusOK, struct {
+		Stats   CacheStats `json:"stats"`
+		Entries []CacheKey `json:"entries"`
+	}{stats, balanceCache.Keys(cacheFilter(c))})
+}
+
+func deleteCache(c *gin.Context) {
+	if balanceCache == nil {
+		respondError(c, c.Request.Context(), notFound("cache is disabled"))
+		return
+	}
+
+	purged, err := balanceCache.Purge(cacheFilter(c))
+	if err != nil {
+		respondError(c, c.Request.Context(), errors.Wrap(err, "failed to purge cache"))
+		return
+	}
+
+	respond(c, http.StatusOK, struct {
+		Purged int `json:"purged"`
+	}{purged})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func cacheKey(chain string, height int64) CacheKey {
+	return CacheKey{Chain: chain, Source: COSMOSSDK_BANK_BALANCE, Address: "cosmos1cache", Height: height}
+}
+
+func TestBalanceCacheEviction(t *testing.T) {
+	cache, err := NewBalanceCache(2, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Add(cacheKey("cachechain", 1), types.NewCoins(types.NewInt64Coin("utest", 1)))
+	cache.Add(cacheKey("cachechain", 2), types.NewCoins(types.NewInt64Coin("utest", 2)))
+	// Height 1 is now the most recently used, so height 2 is evicted.
+	if _, ok := cache.Get(cacheKey("cachechain", 1)); !ok {
+		t.Fatal("expected height 1 to be cached")
+	}
+	cache.Add(cacheKey("cachechain", 3), types.NewCoins(types.NewInt64Coin("utest", 3)))
+
+	if _, ok := cache.Get(cacheKey("cachechain", 2)); ok {
+		t.Error("expected height 2 to be evicted")
+	}
+	for _, height := range []int64{1, 3} {
+		coins, ok := cache.Get(cacheKey("cachechain", height))
+		if !ok || coins.AmountOf("utest").Int64() != height {
+			t.Errorf("expected height %d to be cached, got %v", height, coins)
+		}
+	}
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Size != 2 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBalanceCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewBalanceCache(1, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Add(cacheKey("cachechain", 1), types.NewCoins(types.NewInt64Coin("utest", 1)))
+	cache.Add(cacheKey("cachechain", 2), types.NewCoins(types.NewInt64Coin("utest", 2)))
+
+	// Height 1 was evicted from memory and is promoted back from disk.
+	coins, ok := cache.Get(cacheKey("cachechain", 1))
+	if !ok || coins.AmountOf("utest").Int64() != 1 {
+		t.Fatalf("expected height 1 from disk, got %v", coins)
+	}
+	if keys := cache.Keys(func(CacheKey) bool { return true }); len(keys) != 1 || keys[0].Height != 1 {
+		t.Errorf("expected height 1 to be back in memory, got %v", keys)
+	}
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DiskHits != 1 || stats.DiskEntries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// The disk tier survives a restart.
+	restarted, err := NewBalanceCache(1, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restarted.Get(cacheKey("cachechain", 2)); !ok {
+		t.Error("expected height 2 to survive a restart")
+	}
+}
+
+func TestBalanceCacheDiskEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewBalanceCache(1, dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are dated an hour apart; height 1 was read last.
+	started := time.Now().Add(-time.Hour)
+	for height := int64(1); height <= 3; height++ {
+		cache.Add(cacheKey("cachechain", height), types.NewCoins(types.NewInt64Coin("utest", height)))
+		modified := started.Add(time.Duration(height) * time.Minute)
+		if err := os.Chtimes(cache.path(cacheKey("cachechain", height)), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := cache.Get(cacheKey("cachechain", 1)); !ok {
+		t.Fatal("expected height 1 from disk")
+	}
+
+	// A fourth file is over the capacity of 3: the least recently used is
+	// removed.
+	cache.Add(cacheKey("cachechain", 4), types.NewCoins(types.NewInt64Coin("utest", 4)))
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DiskEntries != 3 || stats.DiskCapacity != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if _, err := os.Stat(cache.path(cacheKey("cachechain", 2))); !os.IsNotExist(err) {
+		t.Errorf("expected height 2 to be evicted, got %v", err)
+	}
+	for _, height := range []int64{1, 3, 4} {
+		if _, err := os.Stat(cache.path(cacheKey("cachechain", height))); err != nil {
+			t.Errorf("expected height %d to be kept: %s", height, err)
+		}
+	}
+
+	// A directory that outgrew a lowered capacity is trimmed on start.
+	restarted, err := NewBalanceCache(1, dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := restarted.Stats(); stats.DiskEntries != 2 {
+		t.Errorf("expected 2 entries after a restart, got %+v", stats)
+	}
+}
+
+func TestCacheEndpoints(t *testing.T) {
+	cache, err := NewBalanceCache(10, t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := balanceCache
+	balanceCache = cache
+	t.Cleanup(func() { balanceCache = previous })
+
+	cache.Add(cacheKey("cachechain", 1), types.NewCoins(types.NewInt64Coin("utest", 1)))
+	cache.Add(cacheKey("cachechain", 2), types.NewCoins(types.NewInt64Coin("utest", 2)))
+	cache.Add(cacheKey("otherchain", 1), types.NewCoins(types.NewInt64Coin("uother", 1)))
+
+	gin.SetMode(gin.TestMode)
+	router := newRouter()
+	request := func(method, target string, v interface{}) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected status 200, got %d: %s", method, target, recorder.Code, recorder.Body.String())
+		}
+		var message Message
+		if err := json.Unmarshal(recorder.Body.Bytes(), &message); err != nil {
+			t.Fatal(err)
+		}
+		decodeContent(t, message.Content, v)
+	}
+
+	var listed struct {
+		Stats   CacheStats `json:"stats"`
+		Entries []CacheKey `json:"entries"`
+	}
+	request(http.MethodGet, "/admin/cache?chain=cachechain", &listed)
+	if listed.Stats.Size != 3 || listed.Stats.DiskEntries != 3 || len(listed.Entries) != 2 {
+		t.Errorf("unexpected cache listing %+v", listed)
+	}
+
+	var purged struct {
+		Purged int `json:"purged"`
+	}
+	request(http.MethodDelete, "/admin/cache?chain=cachechain", &purged)
+	if purged.Purged != 2 {
+		t.Errorf("expected 2 purged entries, got %d", purged.Purged)
+	}
+
+	request(http.MethodGet, "/admin/cache", &listed)
+	if listed.Stats.Size != 1 || listed.Stats.DiskEntries != 1 || len(listed.Entries) != 1 || listed.Entries[0].Chain != "otherchain" {
+		t.Errorf("unexpected cache listing after the purge %+v", listed)
+	}
+}
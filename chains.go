@@ -1 +1,203 @@
 package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BLOCK_TIME_SAMPLE is the number of blocks the block time of a chain is
+// measured over.
+var BLOCK_TIME_SAMPLE int64 = 100
+
+// chainIDs remembers the chain ID each node reported, which doesn't change.
+var chainIDs sync.Map
+
+type ChainInfo struct {
+	Name              string          `json:"name"`
+	ChainID           string          `json:"chainId,omitempty"`
+	StakingTokenDenom string          `json:"stakingTokenDenom,omitempty"`
+	Bech32Prefix      string          `json:"bech32Prefix,omitempty"`
+	Sources           []string        `json:"sources"`
+	Endpoints         []ChainEndpoint `json:"endpoints"`
+}
+
+type ChainEndpoint struct {
+	// Kind is rpc or archive.
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+}
+
+type ChainStatus struct {
+	Chain             string    `json:"chain"`
+	ChainID           string    `json:"chainId"`
+	NodeVersion       string    `json:"nodeVersion"`
+	LatestHeight      int64     `json:"latestHeight"`
+	LatestBlockTime   time.Time `json:"latestBlockTime"`
+	EarliestHeight    int64     `json:"earliestHeight"`
+	EarliestBlockTime time.Time `json:"earliestBlockTime"`
+	CatchingUp        bool      `json:"catchingUp"`
+	// BlockTimeSeconds is the average interval of the last BLOCK_TIME_SAMPLE
+	// blocks.
+	BlockTimeSeconds float64 `json:"blockTimeSeconds"`
+}
+
+// chainSources are the balance sources queried on chain that it can answer.
+func chainSources(chain string) []BalanceSource {
+	var sources []BalanceSource
+	for source := range methods {
+		if source == COSMOSSDK_STAKING_UNBONDING && cfg.Chains[chain].StakingTokenDenom == "" {
+			continue
+		}
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+	return sources
+}
+
+// chainInfo describes chain from its configuration and the chain ID its node
+// reported, if any.
+func chainInfo(chain string) ChainInfo {
+	c := cfg.Chains[chain]
+
+	info := ChainInfo{
+		Name:              chain,
+		StakingTokenDenom: c.StakingTokenDenom,
+		Bech32Prefix:      c.Bech32Prefix,
+		Sources:           []string{},
+		Endpoints:         []ChainEndpoint{{"rpc", redactURL(c.RPCUrl)}},
+	}
+	if id, ok := chainIDs.Load(chain); ok {
+		info.ChainID = id.(string)
+	}
+	for _, source := range chainSources(chain) {
+		info.Sources = append(info.Sources, source.String())
+	}
+	if c.Archive != nil {
+		info.Endpoints = append(info.Endpoints, ChainEndpoint{"archive", redactURL(c.Archive.RPCUrl)})
+	}
+	return info
+}
+
+// redactURL drops the credentials and query string that an endpoint URL may
+// carry.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+// queryChainStatus reports the status of the node of chain and measures its
+// block time.
+func queryChainStatus(ctx context.Context, chain string) (ChainStatus, error) {
+	status, err := getNodeStatus(ctx, chain)
+	if err != nil {
+		return ChainStatus{}, err
+	}
+	chainIDs.Store(chain, status.NodeInfo.Network)
+
+	result := ChainStatus{
+		Chain:       chain,
+		ChainID:     status.NodeInfo.Network,
+		NodeVersion: status.NodeInfo.Version,
+		CatchingUp:  status.SyncInfo.CatchingUp,
+	}
+	if result.LatestHeight, err = strconv.ParseInt(status.SyncInfo.LatestBlockHeight, 0, 64); err != nil {
+		return ChainStatus{}, errors.Wrap(err, "failed to parse latest_block_height")
+	}
+	if result.LatestBlockTime, err = time.Parse(time.RFC3339Nano, status.SyncInfo.LatestBlockTime); err != nil {
+		return ChainStatus{}, errors.Wrap(err, "failed to parse latest_block_time")
+	}
+	if result.EarliestHeight, err = strconv.ParseInt(status.SyncInfo.EarliestBlockHeight, 0, 64); err != nil {
+		return ChainStatus{}, errors.Wrap(err, "failed to parse earliest_block_height")
+	}
+	if result.EarliestBlockTime, err = time.Parse(time.RFC3339Nano, status.SyncInfo.EarliestBlockTime); err != nil {
+		return ChainStatus{}, errors.Wrap(err, "failed to parse earliest_block_time")
+	}
+
+	sampleHeight := max(result.LatestHeight-BLOCK_TIME_SAMPLE, result.EarliestHeight)
+	if blocks := result.LatestHeight - sampleHeight; blocks > 0 {
+		sampleTime, err := GetBlockTime(ctx, chain, sampleHeight)
+		if err != nil {
+			return ChainStatus{}, errors.Wrap(err, "failed to measure the block time")
+		}
+		result.BlockTimeSeconds = result.LatestBlockTime.Sub(*sampleTime).Seconds() / float64(blocks)
+	}
+
+	return result, nil
+}
+
+// getChains answers the configured chains. The chain ID of a chain is asked
+// to its node until it answers once.
+func getChains(c *gin.Context) {
+	ctx, cancel, err := requestContext(c)
+	if err != nil {
+		respondError(c, c.Request.Context(), invalidParameter(errors.Wrap(err, "failed to parse timeout")))
+		return
+	}
+	defer cancel()
+
+	chains := cfg.getChains()
+	sort.Strings(chains)
+
+	var wg sync.WaitGroup
+	for _, chain := range chains {
+		if _, ok := chainIDs.Load(chain); ok {
+			continue
+		}
+		wg.Add(1)
+		go func(chain string) {
+			defer wg.Done()
+			status, err := getNodeStatus(ctx, chain)
+			if err != nil {
+				log.WithError(err).Debugf("failed to get the chain ID of %s", chain)
+				return
+			}
+			chainIDs.Store(chain, status.NodeInfo.Network)
+		}(chain)
+	}
+	wg.Wait()
+
+	var infos = make([]ChainInfo, 0, len(chains))
+	for _, chain := range chains {
+		infos = append(infos, chainInfo(chain))
+	}
+
+	respond(c, http.StatusOK, infos)
+}
+
+// getChainStatus answers the status of the node of a chain.
+func getChainStatus(c *gin.Context) {
+	chainParam := c.Param("chain")
+
+	if _, ok := cfg.Chains[chainParam]; !ok {
+		respondError(c, c.Request.Context(), unknownChain(chainParam))
+		return
+	}
+
+	ctx, cancel, err := requestContext(c)
+	if err != nil {
+		respondError(c, c.Request.Context(), invalidParameter(errors.Wrap(err, "failed to parse timeout")))
+		return
+	}
+	defer cancel()
+
+	status, err := queryChainStatus(ctx, chainParam)
+	if err != nil {
+		respondError(c, ctx, err)
+		return
+	}
+
+	respond(c, http.StatusOK, status)
+}
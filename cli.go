@@ -0,0 +1,757 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+)
+
+func newRootCommand() *cobra.Command {
+	var configPath string
+
+	root := &cobra.Command{
+		Use:          "cosmos-balance-collector",
+		Short:        "Collects account balances of Cosmos SDK chains at any height or date",
+		Long:         "Collects account balances of Cosmos SDK chains at any height or date.\nWithout a subcommand the HTTP server is started, as with serve.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setup(configPath); err != nil {
+				return err
+			}
+			return serve()
+		},
+	}
+	root.PersistentFlags().StringVar(&configPath, "config", DEFAULT_CONFIG_PATH, "path to the configuration file")
+
+	root.AddCommand(
+		newServeCommand(&configPath),
+		newQueryCommand(&configPath),
+		newHeightsCommand(&configPath),
+		newExportCommand(&configPath),
+		newBackfillCommand(&configPath),
+		newCheckCommand(&configPath),
+		newValidateConfigCommand(&configPath),
+	)
+
+	return root
+}
+
+func newServeCommand(configPath *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Start the HTTP server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setup(*configPath); err != nil {
+				return err
+			}
+			return serve()
+		},
+	}
+}
+
+func newQueryCommand(configPath *string) *cobra.Command {
+	var (
+		height  int64
+		at      string
+		from    string
+		to      string
+		output  string
+		timeout time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "query <chain> <address>",
+		Short: "Print the balances of an address at the latest height, a height, a date or every day of a range",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chain, address := args[0], args[1]
+
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			if (from == "") != (to == "") {
+				return errors.New("--from and --to must be set together")
+			}
+			var modes = 0
+			for _, set := range []bool{height != 0, at != "", from != ""} {
+				if set {
+					modes++
+				}
+			}
+			if modes > 1 {
+				return errors.New("only one of --height, --at and --from/--to can be set")
+			}
+			startedAt, endedAt, err := parseTimeRange(from, to)
+			if err != nil {
+				return err
+			}
+
+			if err := setupClients(*configPath); err != nil {
+				return err
+			}
+			if _, ok := cfg.Chains[chain]; !ok {
+				return errors.Errorf("chain %s is not configured", chain)
+			}
+
+			ctx, cancel := commandContext(timeout)
+			defer cancel()
+
+			if from != "" {
+				heights, err := resolveDailyHeights(ctx, chain, startedAt, endedAt)
+				if err != nil {
+					return err
+				}
+				daily, err := queryDailyBalances(ctx, chain, address, heights)
+				if err != nil {
+					return err
+				}
+				return printDailyBalances(cmd.OutOrStdout(), output, address, heights, daily)
+			}
+
+			if at != "" {
+				targetTime, err := parseTime(at)
+				if err != nil {
+					return errors.Wrap(err, "failed to parse --at")
+				}
+				height, err = resolveHeight(ctx, chain, targetTime)
+				if err != nil {
+					return err
+				}
+			}
+
+			coins, err := queryCompleteBalances(ctx, chain, address, height)
+			if err != nil {
+				return errors.Wrap(err, "failed to query balances")
+			}
+			return printBalances(cmd.OutOrStdout(), output, address, height, coins)
+		},
+	}
+
+	cmd.Flags().Int64Var(&height, "height", 0, "query at this height instead of the latest")
+	cmd.Flags().StringVar(&at, "at", "", "query at the height reached at this date (YYYY-MM-DD) or RFC 3339 time")
+	cmd.Flags().StringVar(&from, "from", "", "first day (YYYY-MM-DD) of a daily series")
+	cmd.Flags().StringVar(&to, "to", "", "last day (YYYY-MM-DD) of a daily series")
+	cmd.Flags().StringVarP(&output, "output", "o", OUTPUT_TABLE, "output format: table or json")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "overall deadline, e.g. 5m")
+
+	return cmd
+}
+
+func newHeightsCommand(configPath *string) *cobra.Command {
+	var (
+		date    string
+		to      string
+		output  string
+		timeout time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "heights <chain>",
+		Short: "Resolve the height at the start of a day, or of every day of a range",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chain := args[0]
+
+			if err := validateOutput(output); err != nil {
+				return err
+			}
+			startedAt, err := parseTime(date)
+			if err != nil {
+				return errors.Wrap(err, "failed to parse --date")
+			}
+			var endedAt = startedAt
+			if to != "" {
+				endedAt, err = parseTime(to)
+				if err != nil {
+					return errors.Wrap(err, "failed to parse --to")
+				}
+				if endedAt.Before(startedAt) {
+					return errors.New("--to must not be before --date")
+				}
+			}
+
+			if err := setupClients(*configPath); err != nil {
+				return err
+			}
+			if _, ok := cfg.Chains[chain]; !ok {
+				return errors.Errorf("chain %s is not configured", chain)
+			}
+
+			ctx, cancel := commandContext(timeout)
+			defer cancel()
+
+			heights, err := resolveDailyHeights(ctx, chain, startedAt, endedAt)
+			if err != nil {
+				return err
+			}
+			return printHeights(cmd.OutOrStdout(), output, heights)
+		},
+	}
+
+	cmd.Flags().StringVar(&date, "date", "", "day (YYYY-MM-DD) to resolve")
+	cmd.Flags().StringVar(&to, "to", "", "resolve every day from --date to this day (YYYY-MM-DD)")
+	cmd.Flags().StringVarP(&output, "output", "o", OUTPUT_TABLE, "output format: table or json")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "overall deadline, e.g. 5m")
+	_ = cmd.MarkFlagRequired("date")
+
+	return cmd
+}
+
+func newExportCommand(configPath *string) *cobra.Command {
+	var (
+		from    string
+		to      string
+		format  string
+		out     string
+		timeout time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export <chain>:<address>...",
+		Short: "Export the daily balance series of many addresses and chains",
+		Long: "Export the daily balance series of many addresses and chains as CSV or JSON Lines,\n" +
+			"one row per date, address, balance source and denom, or as Beancount or Ledger\n" +
+			"balance assertions. Without --from and --to the latest balances are exported.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if (from == "") != (to == "") {
+				return errors.New("--from and --to must be set together")
+			}
+			startedAt, endedAt, err := parseTimeRange(from, to)
+			if err != nil {
+				return err
+			}
+
+			chains, addresses, err := parseChainAddresses(args)
+			if err != nil {
+				return err
+			}
+
+			if err := setupClients(*configPath); err != nil {
+				return err
+			}
+			for _, chain := range chains {
+				if _, ok := cfg.Chains[chain]; !ok {
+					return errors.Errorf("chain %s is not configured", chain)
+				}
+			}
+
+			var w = cmd.OutOrStdout()
+			if out != "" {
+				f, err := os.Create(out)
+				if err != nil {
+					return errors.Wrap(err, "failed to create output file")
+				}
+				// The file is only complete once it closes.
+				defer func() {
+					if closeErr := f.Close(); closeErr != nil && err == nil {
+						err = errors.Wrap(closeErr, "failed to close output file")
+					}
+				}()
+				w = f
+			}
+
+			writer, err := NewSnapshotWriter(w, format)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := commandContext(timeout)
+			defer cancel()
+
+			for _, chain := range chains {
+				var snapshots []Snapshot
+				if from != "" {
+					heights, err := resolveDailyHeights(ctx, chain, startedAt, endedAt)
+					if err != nil {
+						return errors.Wrapf(err, "chain %s", chain)
+					}
+					snapshots, err = collectDailySnapshots(ctx, chain, addresses[chain], heights)
+					if err != nil {
+						return err
+					}
+				} else {
+					for _, address := range addresses[chain] {
+						snapshot, err := collectCompleteSnapshot(ctx, chain, address, 0)
+						if err != nil {
+							return errors.Wrapf(err, "%s %s", chain, address)
+						}
+						snapshots = append(snapshots, snapshot)
+					}
+				}
+
+				if err := writeSnapshots(ctx, writer, chain, addresses[chain], snapshots); err != nil {
+					return err
+				}
+			}
+
+			return writer.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "first day (YYYY-MM-DD) of the series")
+	cmd.Flags().StringVar(&to, "to", "", "last day (YYYY-MM-DD) of the series")
+	cmd.Flags().StringVar(&format, "format", FORMAT_CSV, "export format: csv, jsonl, beancount or ledger")
+	cmd.Flags().StringVarP(&out, "out", "f", "", "write to this file instead of stdout")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "overall deadline, e.g. 30m")
+
+	return cmd
+}
+
+func newBackfillCommand(configPath *string) *cobra.Command {
+	var (
+		from        string
+		to          string
+		concurrency int
+		timeout     time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "backfill <chain>:<address>...",
+		Short: "Store the daily snapshots of many addresses over a range of days",
+		Long: "Store the daily snapshots of many addresses from --from to --to in the configured\n" +
+			"store, skipping the days stored already. Progress is checkpointed after every day,\n" +
+			"so running the same backfill again resumes it. Days that cannot be filled, e.g.\n" +
+			"because the node pruned them, are reported as gaps.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startedAt, err := time.Parse(time.DateOnly, from)
+			if err != nil {
+				return errors.Wrap(err, "failed to parse --from")
+			}
+			endedAt, err := time.Parse(time.DateOnly, to)
+			if err != nil {
+				return errors.Wrap(err, "failed to parse --to")
+			}
+			if endedAt.Before(startedAt) {
+				return errors.New("--to must not be before --from")
+			}
+			if concurrency < 1 {
+				return errors.New("--concurrency must be positive")
+			}
+
+			chains, addresses, err := parseChainAddresses(args)
+			if err != nil {
+				return err
+			}
+
+			if err := setup(*configPath); err != nil {
+				return err
+			}
+			for _, chain := range chains {
+				if _, ok := cfg.Chains[chain]; !ok {
+					return errors.Errorf("chain %s is not configured", chain)
+				}
+			}
+			if cfg.Store.Driver != STORE_SQLITE && cfg.Store.Driver != STORE_POSTGRES {
+				return errors.New("backfill needs a sqlite or postgres store")
+			}
+			defer snapshotStore.Close()
+
+			ctx, cancel := commandContext(timeout)
+			defer cancel()
+
+			type job struct {
+				chain, address string
+				checkpoint     BackfillCheckpoint
+				err            error
+			}
+			var jobs []*job
+			for _, chain := range chains {
+				for _, address := range addresses[chain] {
+					jobs = append(jobs, &job{chain: chain, address: address})
+				}
+			}
+
+			var (
+				wg  sync.WaitGroup
+				sem = make(chan struct{}, concurrency)
+			)
+			for _, j := range jobs {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(j *job) {
+					defer func() { <-sem; wg.Done() }()
+					j.checkpoint, j.err = backfill(ctx, snapshotStore, j.chain, j.address, startedAt, endedAt)
+				}(j)
+			}
+			wg.Wait()
+
+			var (
+				w      = tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				failed int
+			)
+			fmt.Fprintln(w, "CHAIN\tADDRESS\tSTORED\tSKIPPED\tGAPS\tSTATUS")
+			for _, j := range jobs {
+				status := "done"
+				if j.err != nil {
+					failed++
+					status = "stopped at " + j.checkpoint.Next.Format(time.DateOnly) + ": " + j.err.Error()
+				} else if !j.checkpoint.Done() {
+					status = "waiting for " + j.checkpoint.Next.Format(time.DateOnly)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", j.chain, j.address, j.checkpoint.Stored, j.checkpoint.Skipped, len(j.checkpoint.Gaps), status)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			for _, j := range jobs {
+				for _, gap := range j.checkpoint.Gaps {
+					fmt.Fprintf(cmd.OutOrStdout(), "gap: %s %s %s: %s\n", j.chain, j.address, gap.Date.Format(time.DateOnly), gap.Reason)
+				}
+			}
+
+			if failed > 0 {
+				return errors.Errorf("%d of %d backfills stopped; run again to resume", failed, len(jobs))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "first day (YYYY-MM-DD) to backfill")
+	cmd.Flags().StringVar(&to, "to", "", "last day (YYYY-MM-DD) to backfill")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 4, "addresses backfilled at the same time")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "overall deadline, e.g. 6h")
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
+
+func newCheckCommand(configPath *string) *cobra.Command {
+	var (
+		from    string
+		to      string
+		repair  bool
+		timeout time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "check <chain>:<address>...",
+		Short: "Find missing or errored stored daily snapshots, and optionally repair them",
+		Long: "Scan the stored daily snapshots of many addresses from --from to --to for missing\n" +
+			"days and snapshots taken at the wrong height. With --repair they are taken again,\n" +
+			"through the archive endpoint of the chain if it has one, and the days that remain\n" +
+			"unrecoverable are reported.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			startedAt, err := time.Parse(time.DateOnly, from)
+			if err != nil {
+				return errors.Wrap(err, "failed to parse --from")
+			}
+			endedAt, err := time.Parse(time.DateOnly, to)
+			if err != nil {
+				return errors.Wrap(err, "failed to parse --to")
+			}
+			if endedAt.Before(startedAt) {
+				return errors.New("--to must not be before --from")
+			}
+
+			chains, addresses, err := parseChainAddresses(args)
+			if err != nil {
+				return err
+			}
+
+			if err := setup(*configPath); err != nil {
+				return err
+			}
+			for _, chain := range chains {
+				if _, ok := cfg.Chains[chain]; !ok {
+					return errors.Errorf("chain %s is not configured", chain)
+				}
+			}
+			if cfg.Store.Driver != STORE_SQLITE && cfg.Store.Driver != STORE_POSTGRES {
+				return errors.New("check needs a sqlite or postgres store")
+			}
+			defer snapshotStore.Close()
+
+			ctx, cancel := commandContext(timeout)
+			defer cancel()
+
+			var (
+				w      = cmd.OutOrStdout()
+				broken int
+			)
+			for _, chain := range chains {
+				for _, address := range addresses[chain] {
+					var report SeriesReport
+					if repair {
+						report, err = repairSeries(ctx, snapshotStore, chain, address, startedAt, endedAt)
+					} else {
+						report, err = checkSeries(ctx, snapshotStore, chain, address, startedAt, endedAt)
+					}
+					if err != nil {
+						return errors.Wrapf(err, "%s %s", chain, address)
+					}
+
+					fmt.Fprintf(w, "%s %s: %d days checked, %d missing, %d errored", chain, address, report.Checked, len(report.Missing), len(report.Errored))
+					if repair {
+						fmt.Fprintf(w, ", %d repaired, %d unrecoverable", len(report.Repaired), len(report.Unrecoverable))
+					}
+					fmt.Fprintln(w)
+
+					for _, day := range report.Missing {
+						fmt.Fprintf(w, "  missing: %s\n", day.Format(time.DateOnly))
+					}
+					for _, issue := range report.Errored {
+						fmt.Fprintf(w, "  errored: %s at height %d: %s\n", issue.Date.Format(time.DateOnly), issue.Height, issue.Problem)
+					}
+					for _, gap := range report.Unrecoverable {
+						fmt.Fprintf(w, "  unrecoverable: %s: %s\n", gap.Date.Format(time.DateOnly), gap.Reason)
+					}
+
+					if repair {
+						broken += len(report.Unrecoverable)
+					} else {
+						broken += len(report.Missing) + len(report.Errored)
+					}
+				}
+			}
+
+			if broken > 0 {
+				return errors.Errorf("%d days are missing or errored", broken)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "first day (YYYY-MM-DD) to check")
+	cmd.Flags().StringVar(&to, "to", "", "last day (YYYY-MM-DD) to check")
+	cmd.Flags().BoolVar(&repair, "repair", false, "take the missing and errored snapshots again")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "overall deadline, e.g. 1h")
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
+
+func newValidateConfigCommand(configPath *string) *cobra.Command {
+	var ping bool
+
+	cmd := &cobra.Command{
+		Use:   "validate-config",
+		Short: "Check the configuration file, optionally contacting every chain",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setup(*configPath); err != nil {
+				return err
+			}
+			if snapshotStore != nil {
+				defer snapshotStore.Close()
+			}
+
+			w := cmd.OutOrStdout()
+			if !ping {
+				fmt.Fprintf(w, "%s is valid (%d chains)\n", *configPath, len(cfg.Chains))
+				return nil
+			}
+
+			ctx, cancel := commandContext(0)
+			defer cancel()
+
+			var failed int
+			chains := cfg.getChains()
+			sort.Strings(chains)
+			for _, chain := range chains {
+				height, err := GetLatestHeight(ctx, chain)
+				if err != nil {
+					failed++
+					fmt.Fprintf(w, "%s: %s\n", chain, err)
+					continue
+				}
+				fmt.Fprintf(w, "%s: ok, latest height %d\n", chain, height)
+			}
+
+			if failed > 0 {
+				return errors.Errorf("%d of %d chains are unreachable", failed, len(chains))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&ping, "ping", false, "query the latest height of every chain")
+
+	return cmd
+}
+
+// parseChainAddresses reads <chain>:<address> arguments into the chains in
+// order of appearance and the addresses of each.
+func parseChainAddresses(args []string) ([]string, map[string][]string, error) {
+	var (
+		chains    []string
+		addresses = make(map[string][]string)
+	)
+	for _, arg := range args {
+		chain, address, ok := strings.Cut(arg, ":")
+		if !ok || chain == "" || address == "" {
+			return nil, nil, errors.Errorf("%s is not formatted as <chain>:<address>", arg)
+		}
+		if _, ok := addresses[chain]; !ok {
+			chains = append(chains, chain)
+		}
+		addresses[chain] = append(addresses[chain], address)
+	}
+	return chains, addresses, nil
+}
+
+// commandContext is canceled on interrupt and, if timeout is set, when it
+// expires.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// parseTimeRange parses the --from and --to flags of a range, which are
+// either both empty or both set with to not before from.
+func parseTimeRange(from, to string) (time.Time, time.Time, error) {
+	if from == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+
+	startedAt, err := parseTime(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err, "failed to parse --from")
+	}
+	endedAt, err := parseTime(to)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err, "failed to parse --to")
+	}
+	if endedAt.Before(startedAt) {
+		return time.Time{}, time.Time{}, errors.New("--to must not be before --from")
+	}
+	return startedAt, endedAt, nil
+}
+
+// parseTime accepts a date (YYYY-MM-DD) or an RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func validateOutput(output string) error {
+	if output != OUTPUT_TABLE && output != OUTPUT_JSON {
+		return errors.Errorf("unknown output format %s", output)
+	}
+	return nil
+}
+
+func sortedSources(balances map[BalanceSource]types.Coins) []BalanceSource {
+	var sources = make([]BalanceSource, 0, len(balances))
+	for source := range balances {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i] < sources[j] })
+	return sources
+}
+
+func sortedDays[T any](m map[time.Time]T) []time.Time {
+	var days = make([]time.Time, 0, len(m))
+	for day := range m {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(v)
+}
+
+func printBalances(w io.Writer, output, address string, height int64, balances map[BalanceSource]types.Coins) error {
+	if output == OUTPUT_JSON {
+		return writeJSON(w, struct {
+			Height int64 `json:"height"`
+			Balance
+		}{height, Balance{Address: address, Balances: balances}})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tDENOM\tAMOUNT")
+	for _, source := range sortedSources(balances) {
+		for _, coin := range balances[source] {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", source, coin.Denom, coin.Amount)
+		}
+	}
+	return tw.Flush()
+}
+
+func printDailyBalances(w io.Writer, output, address string, heights map[time.Time]int64, daily map[time.Time]map[BalanceSource]types.Coins) error {
+	days := sortedDays(daily)
+
+	if output == OUTPUT_JSON {
+		type snapshot struct {
+			Date     string                        `json:"date"`
+			Height   int64                         `json:"height"`
+			Balances map[BalanceSource]types.Coins `json:"balances"`
+		}
+		var snapshots = make([]snapshot, 0, len(days))
+		for _, day := range days {
+			snapshots = append(snapshots, snapshot{day.Format(time.DateOnly), heights[day], daily[day]})
+		}
+		return writeJSON(w, struct {
+			Address   string     `json:"address"`
+			Snapshots []snapshot `json:"snapshots"`
+		}{address, snapshots})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tHEIGHT\tSOURCE\tDENOM\tAMOUNT")
+	for _, day := range days {
+		for _, source := range sortedSources(daily[day]) {
+			for _, coin := range daily[day][source] {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", day.Format(time.DateOnly), heights[day], source, coin.Denom, coin.Amount)
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func printHeights(w io.Writer, output string, heights map[time.Time]int64) error {
+	days := sortedDays(heights)
+
+	if output == OUTPUT_JSON {
+		var result = make(map[string]int64, len(days))
+		for _, day := range days {
+			result[day.Format(time.DateOnly)] = heights[day]
+		}
+		return writeJSON(w, result)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tHEIGHT")
+	for _, day := range days {
+		fmt.Fprintf(tw, "%s\t%d\n", day.Format(time.DateOnly), heights[day])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"cosmos-balance-collector/fakenode"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startCLI serves chain from an in-process node as "testchain" and returns a
+// configuration file pointing to it, followed by extra. The globals setup
+// changes are restored afterwards.
+func startCLI(t *testing.T, chain *fakenode.Chain, extra string) string {
+	t.Helper()
+
+	server := httptest.NewServer(fakenode.New(chain))
+	t.Cleanup(server.Close)
+
+	previous, previousCache, previousStore := cfg, balanceCache, snapshotStore
+	t.Cleanup(func() {
+		waitCoalesced()
+		cfg, balanceCache, snapshotStore = previous, previousCache, previousStore
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "cache:\n  disabled: true\nchains:\n  testchain:\n    rpcURL: " + server.URL + "\n    stakingTokenDenom: utest\n" + extra
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func runCLI(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs(args)
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestQueryCommand(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	path := startCLI(t, dailyBalanceChain(t, address), "")
+
+	out, err := runCLI(t, "query", "testchain", address, "--config", path, "--at", "2024-10-05", "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var balance struct {
+		Height int64 `json:"height"`
+		Balance
+	}
+	if err := json.Unmarshal([]byte(out), &balance); err != nil {
+		t.Fatalf("failed to decode %s: %s", out, err)
+	}
+	// The balance changes at noon: on Oct 5 it is still the one of Oct 4.
+	if balance.Height == 0 || balance.Balances[COSMOSSDK_BANK_BALANCE].String() != "1400utest" || balance.Balances[COSMOSSDK_DISTRIBUTION_REWARD].String() != "12utest" {
+		t.Errorf("unexpected balance %s", out)
+	}
+
+	out, err = runCLI(t, "query", "testchain", address, "--config", path, "--from", "2024-10-02", "--to", "2024-10-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"2024-10-02", "1100", "2024-10-03", "1200"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %s in\n%s", line, out)
+		}
+	}
+}
+
+func TestHeightsCommand(t *testing.T) {
+	chain := dailyBalanceChain(t, e2eAddress(t, "cosmos", 1))
+	path := startCLI(t, chain, "")
+
+	out, err := runCLI(t, "heights", "testchain", "--config", path, "--date", "2024-10-10", "--to", "2024-10-11", "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var heights map[string]int64
+	if err := json.Unmarshal([]byte(out), &heights); err != nil {
+		t.Fatalf("failed to decode %s: %s", out, err)
+	}
+	for _, date := range []string{"2024-10-10", "2024-10-11"} {
+		if heights[date] == 0 {
+			t.Errorf("expected a height for %s, got %v", date, heights)
+		}
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	path := startCLI(t, dailyBalanceChain(t, address), "")
+	out := filepath.Join(t.TempDir(), "export.csv")
+
+	if _, err := runCLI(t, "export", "testchain:"+address, "--config", path, "--from", "2024-10-02", "--to", "2024-10-03", "-f", out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "2024-10-03") || !strings.Contains(string(b), "1200") {
+		t.Errorf("unexpected export\n%s", b)
+	}
+}
+
+func TestCommandArguments(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	path := startCLI(t, dailyBalanceChain(t, address), "")
+
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"query", "testchain", address, "--from", "2024-10-03"}, "--from and --to must be set together"},
+		{[]string{"query", "testchain", address, "--from", "2024-10-03", "--to", "2024-10-02"}, "--to must not be before --from"},
+		{[]string{"query", "testchain", address, "--height", "5", "--at", "2024-10-02"}, "only one of"},
+		{[]string{"query", "testchain", address, "-o", "yaml"}, "unknown output format"},
+		{[]string{"query", "otherchain", address}, "chain otherchain is not configured"},
+		{[]string{"heights", "testchain", "--date", "2024-10-03", "--to", "2024-10-02"}, "--to must not be before --date"},
+		{[]string{"export", "testchain:" + address, "--from", "2024-10-03", "--to", "2024-10-02"}, "--to must not be before --from"},
+		{[]string{"export", "testchain"}, "is not formatted as <chain>:<address>"},
+		{[]string{"backfill", "testchain:" + address, "--from", "2024-10-01", "--to", "2024-10-02"}, "backfill needs a sqlite or postgres store"},
+	} {
+		_, err := runCLI(t, append(tc.args, "--config", path)...)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%v: expected an error containing %q, got %v", tc.args, tc.expected, err)
+		}
+	}
+}
+
+func TestQueryCommandWithoutStore(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	dsn := filepath.Join(t.TempDir(), "snapshots.db")
+	path := startCLI(t, dailyBalanceChain(t, address), "store:\n  driver: sqlite\n  dsn: "+dsn+"\n")
+
+	// Commands that only query the chain leave the store alone.
+	if _, err := runCLI(t, "query", "testchain", address, "--config", path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dsn); !os.IsNotExist(err) {
+		t.Errorf("expected the store not to be opened, got %v", err)
+	}
+}
@@ -1,5 +1,38 @@
 package main
 
+import "context"
+
 type Client interface {
-	Query(path string, parameters map[string]string) ([]byte, error)
+	Query(ctx context.Context, path string, parameters map[string]string) ([]byte, error)
+}
+
+type archiveContextKey struct{}
+
+// withArchive routes the queries made with ctx to the archive endpoint of
+// the chain, if it has one.
+func withArchive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, archiveContextKey{}, true)
+}
+
+func isArchive(ctx context.Context) bool {
+	archive, _ := ctx.Value(archiveContextKey{}).(bool)
+	return archive
+}
+
+// ArchiveClient sends queries to Client, except those made with withArchive,
+// which go to an endpoint keeping the whole history of the chain.
+type ArchiveClient struct {
+	Client
+	archive Client
+}
+
+func NewArchiveClient(client, archive Client) *ArchiveClient {
+	return &ArchiveClient{Client: client, archive: archive}
+}
+
+func (c *ArchiveClient) Query(ctx context.Context, path string, parameters map[string]string) ([]byte, error) {
+	if isArchive(ctx) {
+		return c.archive.Query(ctx, path, parameters)
+	}
+	return c.Client.Query(ctx, path, parameters)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/cosmos/cosmos-sdk/types"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+// COALESCE_TIMEOUT bounds a shared upstream call, which no single caller can
+// cancel.
+var COALESCE_TIMEOUT = time.Minute
+
+var (
+	flights singleflight.Group
+	// flightWaiters counts the callers of shared calls that haven't
+	// finished yet, including callers that stopped waiting for them.
+	flightWaiters sync.WaitGroup
+)
+
+// coalesce runs fn once for concurrent callers sharing the same chain, call
+// and key, handing every caller the same result. fn runs detached from the
+// callers' cancellation, so a caller that goes away doesn't fail the others.
+func coalesce[T any](ctx context.Context, chain, call, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	if isArchive(ctx) {
+		call += "@archive"
+	}
+
+	var executed bool
+	flightWaiters.Add(1)
+	ch := flights.DoChan(fmt.Sprintf("%s/%s/%s", chain, call, key), func() (interface{}, error) {
+		executed = true
+		// The context keeps the caller's values, e.g. withArchive.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), COALESCE_TIMEOUT)
+		defer cancel()
+		return fn(ctx)
+	})
+
+	var zero T
+	select {
+	case <-ctx.Done():
+		go func() {
+			<-ch
+			flightWaiters.Done()
+		}()
+		return zero, ctx.Err()
+	case res := <-ch:
+		flightWaiters.Done()
+		if !executed {
+			coalescedCalls.WithLabelValues(chain, call).Inc()
+		}
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	}
+}
+
+// waitCoalesced returns once every shared call started so far finished, e.g.
+// before the configuration they read changes.
+func waitCoalesced() {
+	flightWaiters.Wait()
+}
+
+// coalesceBalance shares identical concurrent balance queries.
+func coalesceBalance(source BalanceSource, m QueryBalanceFunction) QueryBalanceFunction {
+	return func(ctx context.Context, chain, address string, height int64) (types.Coins, error) {
+		return coalesce(ctx, chain, "balance", fmt.Sprintf("%d/%s/%d", source, address, height), func(ctx context.Context) (types.Coins, error) {
+			return m(ctx, chain, address, height)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startFlight starts a shared call of fn under key and returns once fn runs.
+func startFlight(t *testing.T, ctx context.Context, key string, fn func(ctx context.Context) (int, error)) <-chan error {
+	t.Helper()
+
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := coalesce(ctx, "coalescechain", "test", key, func(ctx context.Context) (int, error) {
+			close(started)
+			return fn(ctx)
+		})
+		done <- err
+	}()
+	<-started
+	return done
+}
+
+func TestCoalesceSharesConcurrentCalls(t *testing.T) {
+	var (
+		calls   atomic.Int32
+		release = make(chan struct{})
+	)
+	fn := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+	first := startFlight(t, context.Background(), "shared", fn)
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]int, 5)
+	)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = coalesce(context.Background(), "coalescechain", "test", "shared", fn)
+		}(i)
+	}
+	// Let the callers join the call in flight.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected one call, got %d", n)
+	}
+	for i, result := range results {
+		if result != 42 {
+			t.Errorf("caller %d: expected 42, got %d", i, result)
+		}
+	}
+}
+
+func TestCoalesceCanceledCaller(t *testing.T) {
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	first := startFlight(t, ctx, "canceled", func(ctx context.Context) (int, error) {
+		<-release
+		// The caller that started the call went away, the call goes on.
+		return 7, ctx.Err()
+	})
+
+	second := make(chan int, 1)
+	go func() {
+		result, err := coalesce(context.Background(), "coalescechain", "test", "canceled", func(ctx context.Context) (int, error) {
+			t.Error("expected the call in flight to be shared")
+			return 0, nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		second <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("expected the canceled caller to give up, got %v", err)
+	}
+	close(release)
+	if result := <-second; result != 7 {
+		t.Errorf("expected the other caller to get 7, got %d", result)
+	}
+
+	waitCoalesced()
+}
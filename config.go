@@ -1,19 +1,284 @@
 package main
 
+import (
+	"cosmossdk.io/math"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
+	"os"
+	"strings"
+	"time"
+)
+
+var DEFAULT_CONFIG_PATH = "config.yaml"
+
 var DEFAULT_TIMEOUT = 3
 
+var DEFAULT_CACHE_SIZE = 10000
+
+var DEFAULT_CACHE_DISK_SIZE = 1000000
+
 type Config struct {
 	Server struct {
 		Port int    `yaml:"port"`
 		Host string `yaml:"host"`
+		// TLS serves HTTPS when set.
+		TLS *ServerTLSConfig `yaml:"tls"`
+		// ShutdownTimeout bounds the draining of in-flight requests, and
+		// separately the stop of background jobs, on SIGTERM. Defaults to 30s.
+		ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
 	} `yaml:"server"`
 
-	Chains map[string]struct {
-		StakingTokenDenom string `yaml:"stakingTokenDenom"`
-		RPCUrl            string `yaml:"rpcURL"`
-		Timeout           int    `yaml:"timeout"`
-		Client            Client
-	} `yaml:"chains"`
+	// Cache holds balances at past heights, which never change.
+	Cache struct {
+		Disabled bool `yaml:"disabled"`
+		Size     int  `yaml:"size"`
+		// Dir enables the on-disk tier when set.
+		Dir string `yaml:"dir"`
+		// DiskSize caps the entries kept in Dir.
+		DiskSize int `yaml:"diskSize"`
+	} `yaml:"cache"`
+
+	Chains map[string]ChainConfig `yaml:"chains"`
+
+	// Watch lists the addresses the scheduler collects snapshots of.
+	Watch WatchConfig `yaml:"watch"`
+
+	// Store is where snapshots are kept.
+	Store StoreConfig `yaml:"store"`
+
+	// Ledger names the accounts and commodities of the Beancount and Ledger
+	// exports.
+	Ledger LedgerConfig `yaml:"ledger"`
+
+	// Alerts are evaluated against every snapshot the scheduler takes.
+	Alerts AlertsConfig `yaml:"alerts"`
+
+	// Health tunes the endpoint checks behind /readyz.
+	Health HealthConfig `yaml:"health"`
+
+	// Auth requires an API key on every route but the probes once a key is
+	// configured.
+	Auth AuthConfig `yaml:"auth"`
+}
+
+type ChainConfig struct {
+	StakingTokenDenom string `yaml:"stakingTokenDenom"`
+	// Bech32Prefix is the account address prefix of the chain, e.g. cosmos.
+	// Addresses with another prefix are rejected when it is set.
+	Bech32Prefix     string `yaml:"bech32Prefix"`
+	RPCUrl           string `yaml:"rpcURL"`
+	Timeout          int    `yaml:"timeout"`
+	ConnectionConfig `yaml:",inline"`
+	// Archive is an endpoint keeping the whole history of the chain, through
+	// which stored series are repaired when RPCUrl has pruned the heights.
+	Archive *ArchiveConfig `yaml:"archive"`
+	// Optional chains don't hold back readiness when none of their
+	// endpoints is healthy.
+	Optional bool `yaml:"optional"`
+	Client   Client
+}
+
+type ArchiveConfig struct {
+	RPCUrl           string `yaml:"rpcURL"`
+	Timeout          int    `yaml:"timeout"`
+	ConnectionConfig `yaml:",inline"`
+}
+
+// ConnectionConfig customizes how the collector talks to an RPC provider.
+type ConnectionConfig struct {
+	// Headers are added to every request, e.g. an API key header.
+	Headers map[string]Secret `yaml:"headers"`
+	// QueryParams are added to every request's query string.
+	QueryParams map[string]Secret `yaml:"queryParams"`
+	BasicAuth   *BasicAuthConfig  `yaml:"basicAuth"`
+	// Proxy is the URL of an HTTP proxy. The environment's proxy settings are
+	// used when unset.
+	Proxy Secret     `yaml:"proxy"`
+	TLS   *TLSConfig `yaml:"tls"`
+	// RateLimit protects the endpoint from bursts of historical queries.
+	RateLimit *RateLimitConfig `yaml:"rateLimit"`
+}
+
+type RateLimitConfig struct {
+	// RequestsPerSecond is the token bucket refill rate; 0 disables it.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+	// MaxInFlight caps concurrent requests to the endpoint; 0 is unlimited.
+	MaxInFlight int `yaml:"maxInFlight"`
+}
+
+func (c *RateLimitConfig) validate() error {
+	if c != nil && (c.RequestsPerSecond < 0 || c.Burst < 0 || c.MaxInFlight < 0) {
+		return errors.New("rateLimit must not be negative")
+	}
+	return nil
+}
+
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// ServerTLSConfig is the certificate the API is served with. The files are
+// read again when they change, so that rotated certificates are picked up.
+type ServerTLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ClientCAFile enables mutual TLS: clients must present a certificate
+	// signed by one of its CAs.
+	ClientCAFile string `yaml:"clientCAFile"`
+	// AllowedSubjects restricts mutual TLS to the client certificates whose
+	// subject common name or distinguished name is listed.
+	AllowedSubjects []string `yaml:"allowedSubjects"`
+}
+
+type WatchConfig struct {
+	// Schedule is a cron expression (minute hour day month weekday, in UTC)
+	// for snapshots besides the daily ones taken at the start of each day.
+	Schedule string `yaml:"schedule"`
+	// CatchUpDays bounds how many missed daily snapshots are taken after
+	// downtime. Defaults to 7.
+	CatchUpDays int `yaml:"catchUpDays"`
+	// RetryInterval is the delay before the first retry of a failed
+	// snapshot, doubled on each further failure. Defaults to 1m.
+	RetryInterval time.Duration `yaml:"retryInterval"`
+	// RepairDays is how many days before today are checked for missing or
+	// errored snapshots every day, and repaired through the archive endpoint
+	// of the chain. 0 disables the repair.
+	RepairDays int              `yaml:"repairDays"`
+	Addresses  []WatchedAddress `yaml:"addresses"`
+}
+
+type HealthConfig struct {
+	// Interval between two checks of every endpoint. Defaults to 30s.
+	Interval time.Duration `yaml:"interval"`
+	// StaleAfter is the age of the latest block beyond which an endpoint is
+	// unhealthy. Defaults to 5m.
+	StaleAfter time.Duration `yaml:"staleAfter"`
+}
+
+type StoreConfig struct {
+	// Driver is memory, sqlite or postgres. Snapshots are kept in memory
+	// when addresses are watched and no driver is set.
+	Driver string `yaml:"driver"`
+	// DSN is the SQLite database file, or the PostgreSQL connection string.
+	DSN Secret `yaml:"dsn"`
+}
+
+type WatchedAddress struct {
+	Chain   string `yaml:"chain"`
+	Address string `yaml:"address"`
+}
+
+type LedgerConfig struct {
+	// Accounts maps a chain, then an address, then a balance source to an
+	// account name. "*" matches any address or source; an account matched by a
+	// "*" source gets the source appended, e.g. Assets:Crypto:Osmosis:Bank.
+	// Unmapped balances are booked to Assets:Crypto:<Chain>:<Address>:<Source>.
+	Accounts map[string]map[string]map[string]string `yaml:"accounts"`
+	// Commodities maps a denom to a commodity name, e.g. uosmo: UOSMO.
+	Commodities map[string]string `yaml:"commodities"`
+	// PadAccount absorbs the changes between balance assertions that no known
+	// transaction explains. Defaults to Equity:Unreconciled.
+	PadAccount string `yaml:"padAccount"`
+	// TransferAccount is the other side of known transfers in and out of the
+	// address. Defaults to Equity:Transfers.
+	TransferAccount string `yaml:"transferAccount"`
+}
+
+type AlertsConfig struct {
+	Rules []AlertRule `yaml:"rules"`
+	// Notifiers are named so that rules can share them.
+	Notifiers map[string]NotifierConfig `yaml:"notifiers"`
+}
+
+type AlertRule struct {
+	Name  string `yaml:"name"`
+	Chain string `yaml:"chain"`
+	// Address limits the rule to one watched address, otherwise it applies
+	// to every watched address of the chain.
+	Address string `yaml:"address"`
+	// Source limits the rule to one balance source, e.g. bank, otherwise the
+	// total across sources is considered.
+	Source string `yaml:"source"`
+	Denom  string `yaml:"denom"`
+	// Kind is below or above a Threshold, change by Percent over Window, or
+	// new_denom.
+	Kind string `yaml:"kind"`
+	// Threshold is an amount in base units of Denom.
+	Threshold string `yaml:"threshold"`
+	// Percent is the smallest change that fires; 0 fires on any change.
+	Percent float64       `yaml:"percent"`
+	Window  time.Duration `yaml:"window"`
+	// Direction limits a change rule to up or down moves.
+	Direction string `yaml:"direction"`
+	// Denoms are the denoms a new_denom rule expects. Without them, a denom
+	// is unexpected when the previous snapshot did not hold it.
+	Denoms []string `yaml:"denoms"`
+	// Notify names the notifiers of the rule.
+	Notify []string `yaml:"notify"`
+}
+
+// NotifierConfig sets exactly one of its fields.
+type NotifierConfig struct {
+	// Webhook receives every notification as a JSON document.
+	Webhook *WebhookConfig `yaml:"webhook"`
+	// Slack is a Slack-compatible incoming webhook.
+	Slack *WebhookConfig `yaml:"slack"`
+	SMTP  *SMTPConfig    `yaml:"smtp"`
+}
+
+type WebhookConfig struct {
+	URL     Secret            `yaml:"url"`
+	Headers map[string]Secret `yaml:"headers"`
+}
+
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password Secret   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+type AuthConfig struct {
+	Keys []APIKeyConfig `yaml:"keys"`
+	// KeysFile is a YAML list of keys, added to Keys.
+	KeysFile string `yaml:"keysFile"`
+	// AddressGroups name lists of addresses that keys can be restricted to.
+	AddressGroups map[string][]string `yaml:"addressGroups"`
+	// AuditLog is the file every request but the probes is appended to, as a
+	// JSON line, including those rejected for a missing or invalid key.
+	// Requests are logged with the other logs when unset.
+	AuditLog string `yaml:"auditLog"`
+}
+
+type APIKeyConfig struct {
+	// Name identifies the key in the audit log.
+	Name string `yaml:"name"`
+	Key  Secret `yaml:"key"`
+	// RateLimit applies to the requests made with the key; requests beyond
+	// it are rejected.
+	RateLimit *RateLimitConfig `yaml:"rateLimit"`
+	// MaxRangeDays caps the length of the historical ranges scanned with the
+	// key. 0 is unlimited.
+	MaxRangeDays int `yaml:"maxRangeDays"`
+	// Chains and AddressGroups restrict the key when set.
+	Chains        []string `yaml:"chains"`
+	AddressGroups []string `yaml:"addressGroups"`
+	// Admin keys may use the /admin routes.
+	Admin bool `yaml:"admin"`
 }
 
 func (c *Config) getChains() []string {
@@ -23,3 +288,248 @@ func (c *Config) getChains() []string {
 	}
 	return result
 }
+
+// loadConfig reads and validates the configuration file at path.
+func loadConfig(path string) (Config, error) {
+	var config = Config{}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, errors.Wrap(err, "failed to read config")
+	}
+
+	err = yaml.UnmarshalStrict(b, &config)
+	if err != nil {
+		return config, errors.Wrapf(err, "failed to parse %s", path)
+	}
+
+	if config.Auth.KeysFile != "" {
+		b, err := os.ReadFile(config.Auth.KeysFile)
+		if err != nil {
+			return config, errors.Wrap(err, "failed to read keysFile")
+		}
+		var keys []APIKeyConfig
+		if err := yaml.UnmarshalStrict(b, &keys); err != nil {
+			return config, errors.Wrapf(err, "failed to parse %s", config.Auth.KeysFile)
+		}
+		config.Auth.Keys = append(config.Auth.Keys, keys...)
+	}
+
+	return config, config.validate()
+}
+
+func (c *Config) validate() error {
+	if len(c.Chains) == 0 {
+		return errors.New("at least one chain must be configured")
+	}
+
+	for name, chain := range c.Chains {
+		if chain.RPCUrl == "" {
+			return errors.Errorf("chain %s: each chain must have rpcURL", name)
+		} else if !strings.HasPrefix(chain.RPCUrl, "http") {
+			return errors.Errorf("chain %s: rpcURL must be formatted as http.", name)
+		}
+		if chain.Timeout < 0 {
+			return errors.Errorf("chain %s: timeout must not be negative", name)
+		}
+		if err := chain.RateLimit.validate(); err != nil {
+			return errors.Wrapf(err, "chain %s", name)
+		}
+		if archive := chain.Archive; archive != nil {
+			if !strings.HasPrefix(archive.RPCUrl, "http") {
+				return errors.Errorf("chain %s: archive rpcURL must be formatted as http.", name)
+			}
+			if archive.Timeout < 0 {
+				return errors.Errorf("chain %s: archive timeout must not be negative", name)
+			}
+			if err := archive.RateLimit.validate(); err != nil {
+				return errors.Wrapf(err, "chain %s: archive", name)
+			}
+		}
+	}
+
+	for chain := range c.Ledger.Accounts {
+		if _, ok := c.Chains[chain]; !ok {
+			return errors.Errorf("ledger: chain %s is not configured", chain)
+		}
+	}
+
+	for _, watched := range c.Watch.Addresses {
+		if _, ok := c.Chains[watched.Chain]; !ok {
+			return errors.Errorf("watch: chain %s is not configured", watched.Chain)
+		}
+		if watched.Address == "" {
+			return errors.Errorf("watch: an address on chain %s is empty", watched.Chain)
+		}
+		if prefix := c.Chains[watched.Chain].Bech32Prefix; prefix != "" {
+			if err := checkBech32(watched.Address, prefix); err != nil {
+				return errors.Wrap(err, "watch")
+			}
+		}
+	}
+	if c.Watch.Schedule != "" {
+		if _, err := cron.ParseStandard(c.Watch.Schedule); err != nil {
+			return errors.Wrap(err, "watch: invalid schedule")
+		}
+	}
+	if t := c.Server.TLS; t != nil {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return errors.New("server: tls must have certFile and keyFile")
+		}
+		if len(t.AllowedSubjects) > 0 && t.ClientCAFile == "" {
+			return errors.New("server: tls allowedSubjects need clientCAFile")
+		}
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		return errors.New("server: shutdownTimeout must not be negative")
+	}
+	if c.Health.Interval < 0 || c.Health.StaleAfter < 0 {
+		return errors.New("health: interval and staleAfter must not be negative")
+	}
+	if c.Watch.CatchUpDays < 0 || c.Watch.RetryInterval < 0 || c.Watch.RepairDays < 0 {
+		return errors.New("watch: catchUpDays, retryInterval and repairDays must not be negative")
+	}
+
+	switch c.Store.Driver {
+	case "", STORE_MEMORY:
+	case STORE_SQLITE, STORE_POSTGRES:
+		if c.Store.DSN.IsZero() {
+			return errors.Errorf("store: %s needs a dsn", c.Store.Driver)
+		}
+	default:
+		return errors.Errorf("store: unknown driver %s", c.Store.Driver)
+	}
+
+	if err := c.Alerts.validate(c.Chains); err != nil {
+		return errors.Wrap(err, "alerts")
+	}
+	if err := c.Auth.validate(c.Chains); err != nil {
+		return errors.Wrap(err, "auth")
+	}
+
+	if c.Cache.Size < 0 {
+		return errors.New("cache size must not be negative")
+	}
+	if c.Cache.DiskSize < 0 {
+		return errors.New("cache disk size must not be negative")
+	}
+
+	return nil
+}
+
+func (c *AlertsConfig) validate(chains map[string]ChainConfig) error {
+	for name, notifier := range c.Notifiers {
+		var kinds int
+		for _, set := range []bool{notifier.Webhook != nil, notifier.Slack != nil, notifier.SMTP != nil} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return errors.Errorf("notifier %s must set exactly one of webhook, slack and smtp", name)
+		}
+		for _, webhook := range []*WebhookConfig{notifier.Webhook, notifier.Slack} {
+			if webhook != nil && webhook.URL.IsZero() {
+				return errors.Errorf("notifier %s must have url", name)
+			}
+		}
+		if smtp := notifier.SMTP; smtp != nil && (smtp.Host == "" || smtp.From == "" || len(smtp.To) == 0) {
+			return errors.Errorf("notifier %s must have host, from and to", name)
+		}
+	}
+
+	var names = make(map[string]bool)
+	for _, rule := range c.Rules {
+		if rule.Name == "" {
+			return errors.New("each rule must have name")
+		}
+		if names[rule.Name] {
+			return errors.Errorf("rule %s is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if _, ok := chains[rule.Chain]; !ok {
+			return errors.Errorf("rule %s: chain %s is not configured", rule.Name, rule.Chain)
+		}
+		if rule.Source != "" {
+			if _, err := parseBalanceSource(rule.Source); err != nil {
+				return errors.Wrapf(err, "rule %s", rule.Name)
+			}
+		}
+
+		switch rule.Kind {
+		case ALERT_BELOW, ALERT_ABOVE:
+			if rule.Denom == "" {
+				return errors.Errorf("rule %s: %s needs denom", rule.Name, rule.Kind)
+			}
+			if err := types.ValidateDenom(rule.Denom); err != nil {
+				return errors.Wrapf(err, "rule %s", rule.Name)
+			}
+			if _, ok := math.NewIntFromString(rule.Threshold); !ok {
+				return errors.Errorf("rule %s: threshold %q is not an integer amount", rule.Name, rule.Threshold)
+			}
+		case ALERT_CHANGE:
+			if rule.Denom == "" {
+				return errors.Errorf("rule %s: change needs denom", rule.Name)
+			}
+			if err := types.ValidateDenom(rule.Denom); err != nil {
+				return errors.Wrapf(err, "rule %s", rule.Name)
+			}
+			if rule.Window <= 0 || rule.Percent < 0 {
+				return errors.Errorf("rule %s: change needs a positive window and a non-negative percent", rule.Name)
+			}
+			if rule.Direction != "" && rule.Direction != ALERT_UP && rule.Direction != ALERT_DOWN {
+				return errors.Errorf("rule %s: direction must be up or down", rule.Name)
+			}
+		case ALERT_NEW_DENOM:
+		default:
+			return errors.Errorf("rule %s: unknown kind %s", rule.Name, rule.Kind)
+		}
+
+		if len(rule.Notify) == 0 {
+			return errors.Errorf("rule %s must notify at least one notifier", rule.Name)
+		}
+		for _, notifier := range rule.Notify {
+			if _, ok := c.Notifiers[notifier]; !ok {
+				return errors.Errorf("rule %s: notifier %s is not configured", rule.Name, notifier)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *AuthConfig) validate(chains map[string]ChainConfig) error {
+	var names = make(map[string]bool)
+	for _, key := range c.Keys {
+		if key.Name == "" {
+			return errors.New("each key must have name")
+		}
+		if names[key.Name] {
+			return errors.Errorf("key %s is defined twice", key.Name)
+		}
+		names[key.Name] = true
+
+		if key.Key.IsZero() {
+			return errors.Errorf("key %s must have key", key.Name)
+		}
+		if key.MaxRangeDays < 0 {
+			return errors.Errorf("key %s: maxRangeDays must not be negative", key.Name)
+		}
+		if err := key.RateLimit.validate(); err != nil {
+			return errors.Wrapf(err, "key %s", key.Name)
+		}
+		for _, chain := range key.Chains {
+			if _, ok := chains[chain]; !ok {
+				return errors.Errorf("key %s: chain %s is not configured", key.Name, chain)
+			}
+		}
+		for _, group := range key.AddressGroups {
+			if _, ok := c.AddressGroups[group]; !ok {
+				return errors.Errorf("key %s: address group %s is not configured", key.Name, group)
+			}
+		}
+	}
+
+	return nil
+}
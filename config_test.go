@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRateLimitValidation(t *testing.T) {
+	negative := &RateLimitConfig{RequestsPerSecond: 5, Burst: -1}
+
+	for name, config := range map[string]Config{
+		"chain": {Chains: map[string]ChainConfig{
+			"testchain": {RPCUrl: "http://node:26657", ConnectionConfig: ConnectionConfig{RateLimit: negative}},
+		}},
+		"archive": {Chains: map[string]ChainConfig{
+			"testchain": {RPCUrl: "http://node:26657", Archive: &ArchiveConfig{RPCUrl: "http://archive:26657", ConnectionConfig: ConnectionConfig{RateLimit: negative}}},
+		}},
+		"key": {
+			Chains: map[string]ChainConfig{"testchain": {RPCUrl: "http://node:26657"}},
+			Auth:   AuthConfig{Keys: []APIKeyConfig{{Name: "client", Key: Secret{Value: "key"}, RateLimit: negative}}},
+		},
+	} {
+		if err := config.validate(); err == nil {
+			t.Errorf("%s: expected a negative rateLimit to be rejected", name)
+		}
+	}
+
+	config := Config{Chains: map[string]ChainConfig{
+		"testchain": {RPCUrl: "http://node:26657", ConnectionConfig: ConnectionConfig{RateLimit: &RateLimitConfig{RequestsPerSecond: 5, Burst: 10, MaxInFlight: 4}}},
+	}}
+	if err := config.validate(); err != nil {
+		t.Errorf("expected a valid rateLimit, got %s", err)
+	}
+}
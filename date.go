@@ -1,12 +1,153 @@
 package main
 
 import (
+	"context"
 	"github.com/pkg/errors"
 	log "github.com/xlab/suplog"
 	"time"
 )
 
-func calculateTargetTimeAndHeight(chain string, targetTime, latestBlockTime time.Time, blockInterval time.Duration, latestBlockHeight int64) (int64, error) {
+// latestBlock returns the latest height of chain, its block time and the
+// interval to the block before, which seeds the height estimates.
+func latestBlock(ctx context.Context, chain string) (int64, time.Time, time.Duration, error) {
+	latestHeight, err := GetLatestHeight(ctx, chain)
+	if err != nil {
+		return 0, time.Time{}, 0, errors.Wrap(err, "failed to get latestHeight")
+	}
+
+	latestBlockTime, err := GetBlockTime(ctx, chain, latestHeight)
+	if err != nil {
+		return 0, time.Time{}, 0, errors.Wrap(err, "failed to get block time")
+	}
+
+	secondBlockTime, err := GetBlockTime(ctx, chain, latestHeight-1)
+	if err != nil {
+		return 0, time.Time{}, 0, errors.Wrap(err, "failed to get block time")
+	}
+
+	return latestHeight, *latestBlockTime, latestBlockTime.Sub(*secondBlockTime), nil
+}
+
+// earliestBlock returns the earliest height the node of chain keeps, below
+// which blocks and state are pruned, and its block time.
+func earliestBlock(ctx context.Context, chain string) (int64, time.Time, error) {
+	earliestHeight, earliestBlockTime, err := getEarliestBlock(ctx, chain)
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "failed to get the earliest block")
+	}
+	return earliestHeight, earliestBlockTime, nil
+}
+
+// resolveHeight returns the height of the last block of chain at or before
+// targetTime.
+func resolveHeight(ctx context.Context, chain string, targetTime time.Time) (height int64, err error) {
+	defer func() { heightResolutions.WithLabelValues(chain, "single", resultLabel(err)).Inc() }()
+
+	latestHeight, latestBlockTime, expectedBlockInterval, err := latestBlock(ctx, chain)
+	if err != nil {
+		return 0, err
+	}
+
+	if targetTime.After(latestBlockTime) {
+		return 0, invalidDateRange("%s is after the latest block at %s", targetTime.Format(time.RFC3339), latestBlockTime.Format(time.RFC3339))
+	}
+
+	height, err = calculateTargetTimeAndHeight(ctx, chain, targetTime, latestBlockTime, expectedBlockInterval, latestHeight)
+	if err != nil {
+		return 0, err
+	}
+	return lastBlockAtOrBefore(ctx, chain, height, latestHeight, targetTime)
+}
+
+// lastBlockAtOrBefore moves height, an estimate, to the last block of chain
+// whose time is not after at. It steps away from height, doubling the step,
+// until the block time crosses at, then bisects the last step.
+func lastBlockAtOrBefore(ctx context.Context, chain string, height, latestHeight int64, at time.Time) (int64, error) {
+	height = min(max(height, 1), latestHeight)
+	blockTime, err := GetBlockTime(ctx, chain, height)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get block time")
+	}
+
+	// The block at low is at or before at, the one at high after it.
+	var low, high int64
+	if blockTime.After(at) {
+		high = height
+		for step := int64(1); ; step *= 2 {
+			if high == 1 {
+				return 0, invalidDateRange("%s is before the first block", at.Format(time.RFC3339))
+			}
+			low = max(high-step, 1)
+			blockTime, err := GetBlockTime(ctx, chain, low)
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to get block time")
+			}
+			if !blockTime.After(at) {
+				break
+			}
+			high = low
+		}
+	} else {
+		low = height
+		for step := int64(1); ; step *= 2 {
+			if low == latestHeight {
+				return low, nil
+			}
+			high = min(low+step, latestHeight)
+			blockTime, err := GetBlockTime(ctx, chain, high)
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to get block time")
+			}
+			if blockTime.After(at) {
+				break
+			}
+			low = high
+		}
+	}
+
+	for high-low > 1 {
+		middle := low + (high-low)/2
+		blockTime, err := GetBlockTime(ctx, chain, middle)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to get block time")
+		}
+		if blockTime.After(at) {
+			high = middle
+		} else {
+			low = middle
+		}
+	}
+	return low, nil
+}
+
+// resolveDailyHeights estimates the height of chain at the start of every day
+// from startedAt to endedAt.
+func resolveDailyHeights(ctx context.Context, chain string, startedAt, endedAt time.Time) (heights map[time.Time]int64, err error) {
+	defer func() { heightResolutions.WithLabelValues(chain, "daily", resultLabel(err)).Inc() }()
+
+	latestHeight, latestBlockTime, expectedBlockInterval, err := latestBlock(ctx, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	if endedAt.Truncate(24 * time.Hour).After(latestBlockTime) {
+		return nil, invalidDateRange("%s is after the latest block at %s", endedAt.Format(time.DateOnly), latestBlockTime.Format(time.RFC3339))
+	}
+
+	endedAtHeight, err := calculateTargetTimeAndHeight(ctx, chain, endedAt.Truncate(24*time.Hour), latestBlockTime, expectedBlockInterval, latestHeight)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get block time")
+	}
+
+	r, err := calculateDailyHeights(ctx, chain, startedAt.Truncate(24*time.Hour), endedAt.Truncate(24*time.Hour), expectedBlockInterval, endedAtHeight)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to calculate daily heights")
+	}
+
+	return r, nil
+}
+
+func calculateTargetTimeAndHeight(ctx context.Context, chain string, targetTime, latestBlockTime time.Time, blockInterval time.Duration, latestBlockHeight int64) (int64, error) {
 	expectedBlockInterval := blockInterval
 
 	elapsed := latestBlockTime.Sub(targetTime)
@@ -14,13 +155,13 @@ func calculateTargetTimeAndHeight(chain string, targetTime, latestBlockTime time
 	blocksPassed := int64(elapsed / expectedBlockInterval)
 	height := latestBlockHeight - blocksPassed
 
-	blockTimestamp, err := GetBlockTime(chain, height)
+	blockTimestamp, err := GetBlockTime(ctx, chain, height)
 	if err != nil {
 		return 0, errors.Wrap(err, "Error while fetching block time")
 	}
 
 	timeDifference := blockTimestamp.Sub(targetTime)
-	if timeDifference > 10*time.Second || timeDifference < -10*time.Second {
+	if blocksPassed != 0 && (timeDifference > 10*time.Second || timeDifference < -10*time.Second) {
 		adjustment := timeDifference / time.Duration(float64(blocksPassed))
 		if timeDifference > 0 {
 			expectedBlockInterval -= adjustment
@@ -35,16 +176,23 @@ func calculateTargetTimeAndHeight(chain string, targetTime, latestBlockTime time
 	return height, nil
 }
 
-func calculateDailyHeights(chain string, startedAt, endedAt time.Time, blockInterval time.Duration, latestBlockHeight int64) map[time.Time]int64 {
+func calculateDailyHeights(ctx context.Context, chain string, startedAt, endedAt time.Time, blockInterval time.Duration, latestBlockHeight int64) (map[time.Time]int64, error) {
 	dailyHeights := make(map[time.Time]int64)
 	loopDate := endedAt.Truncate(24 * time.Hour) // Truncate to the start of the day
 	expectedBlockInterval := blockInterval
-	var failedCnt = 0
+	var (
+		failedCnt = 0
+		lastErr   error
+	)
 
 	for !loopDate.Before(startedAt.Truncate(24 * time.Hour)) { // Truncate startedAt to day as well
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "daily height calculation aborted")
+		}
+
 		if failedCnt > 10 {
 			log.Println("Too many consecutive failures, returning nil.")
-			return nil
+			return nil, errors.Wrap(lastErr, "too many consecutive failures")
 		}
 
 		elapsed := endedAt.Sub(loopDate)
@@ -53,9 +201,10 @@ func calculateDailyHeights(chain string, startedAt, endedAt time.Time, blockInte
 		height := latestBlockHeight - blocksPassed
 
 		log.Infoln("origin height: ", height)
-		blockTimestamp, err := GetBlockTime(chain, height)
+		blockTimestamp, err := GetBlockTime(ctx, chain, height)
 		if err != nil {
 			log.Println("Error fetching block time:", err)
+			lastErr = err
 			failedCnt++
 			continue
 		}
@@ -90,5 +239,5 @@ func calculateDailyHeights(chain string, startedAt, endedAt time.Time, blockInte
 		loopDate = loopDate.Add(-24 * time.Hour)
 	}
 
-	return dailyHeights
+	return dailyHeights, nil
 }
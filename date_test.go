@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCalculateDailyHeights(t *testing.T) {
+	// expected holds the heights the resolver picks from the synthetic chain
+	// state of the fixtures; they have to be updated for recorded ones.
+	for _, tc := range []struct {
+		chain     string
+		startedAt string
+		endedAt   string
+		expected  map[string]int64
+	}{
+		{"osmosis", "2024-10-25", "2024-10-31", map[string]int64{
+			"2024-10-25": 27058047,
+			"2024-10-26": 27094185,
+			"2024-10-27": 27130335,
+			"2024-10-28": 27167245,
+			"2024-10-29": 27202210,
+			"2024-10-30": 27247684,
+			"2024-10-31": 27293158,
+		}},
+		{"injective", "2024-10-29", "2024-10-31", map[string]int64{
+			"2024-10-29": 90798895,
+			"2024-10-30": 90918789,
+			"2024-10-31": 91037561,
+		}},
+		{"celestia", "2024-10-20", "2024-10-31", map[string]int64{
+			"2024-10-20": 2711059,
+			"2024-10-21": 2718319,
+			"2024-10-22": 2725580,
+			"2024-10-23": 2732841,
+			"2024-10-24": 2740102,
+			"2024-10-25": 2747362,
+			"2024-10-26": 2754624,
+			"2024-10-27": 2761885,
+			"2024-10-28": 2769149,
+			"2024-10-29": 2776416,
+			"2024-10-30": 2783625,
+			"2024-10-31": 2790456,
+		}},
+	} {
+		t.Run(tc.chain, func(t *testing.T) {
+			useFixture(t, tc.chain, "")
+			ctx := context.Background()
+
+			startedAt, _ := time.Parse(time.DateOnly, tc.startedAt)
+			endedAt, _ := time.Parse(time.DateOnly, tc.endedAt)
+
+			latestHeight, err := GetLatestHeight(ctx, tc.chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			latestBlockTime, err := GetBlockTime(ctx, tc.chain, latestHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secondBlockTime, err := GetBlockTime(ctx, tc.chain, latestHeight-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			interval := latestBlockTime.Sub(*secondBlockTime)
+
+			endedAtHeight, err := calculateTargetTimeAndHeight(ctx, tc.chain, endedAt, *latestBlockTime, interval, latestHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			heights, err := calculateDailyHeights(ctx, tc.chain, startedAt, endedAt, interval, endedAtHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(heights) != len(tc.expected) {
+				t.Fatalf("expected %d days, got %d", len(tc.expected), len(heights))
+			}
+
+			for day, height := range heights {
+				date := day.Format(time.DateOnly)
+				if *record {
+					t.Logf("%s: %d", date, height)
+				} else if height != tc.expected[date] {
+					t.Errorf("%s: expected height %d, got %d", date, tc.expected[date], height)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateDailyHeightsCanceled(t *testing.T) {
+	useFixture(t, "osmosis", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	startedAt, _ := time.Parse(time.DateOnly, "2024-10-25")
+	endedAt, _ := time.Parse(time.DateOnly, "2024-10-31")
+
+	if _, err := calculateDailyHeights(ctx, "osmosis", startedAt, endedAt, 2*time.Second, 27000000); err == nil {
+		t.Fatal("expected an error for a canceled context")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cosmossdk.io/math"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DenomDiff is how the amount of one denom moved between two points.
+type DenomDiff struct {
+	Opening math.Int `json:"opening"`
+	Closing math.Int `json:"closing"`
+	Delta   math.Int `json:"delta"`
+}
+
+type DiffPoint struct {
+	Height    int64     `json:"height"`
+	BlockTime time.Time `json:"blockTime"`
+}
+
+// BalanceDiff compares the balances of an address at two points, per source
+// and denom, and in total across sources, so that movements between liquid,
+// staked, unbonding and reward balances stand out.
+type BalanceDiff struct {
+	Address string                                 `json:"address"`
+	From    DiffPoint                              `json:"from"`
+	To      DiffPoint                              `json:"to"`
+	Sources map[BalanceSource]map[string]DenomDiff `json:"sources"`
+	Total   map[string]DenomDiff                   `json:"total"`
+}
+
+// parsePoint reads value as a height, or as a date (YYYY-MM-DD) or RFC 3339
+// time whose height is still to be resolved.
+func parsePoint(value string) (int64, time.Time, error) {
+	if height, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if height <= 0 {
+			return 0, time.Time{}, errors.New("height must be positive")
+		}
+		return height, time.Time{}, nil
+	}
+
+	t, err := parseTime(value)
+	if err != nil {
+		return 0, time.Time{}, errors.Errorf("%s is neither a height, a date nor an RFC 3339 time", value)
+	}
+	return 0, t, nil
+}
+
+func diffBalances(opening, closing Snapshot) BalanceDiff {
+	var (
+		sources = make(map[BalanceSource]map[string]DenomDiff)
+		total   = make(map[string]DenomDiff)
+	)
+	add := func(m map[string]DenomDiff, denom string, opening, closing math.Int) {
+		d, ok := m[denom]
+		if !ok {
+			d = DenomDiff{Opening: math.ZeroInt(), Closing: math.ZeroInt()}
+		}
+		d.Opening = d.Opening.Add(opening)
+		d.Closing = d.Closing.Add(closing)
+		d.Delta = d.Closing.Sub(d.Opening)
+		m[denom] = d
+	}
+
+	for _, balances := range []map[BalanceSource]types.Coins{opening.Balances, closing.Balances} {
+		for source := range balances {
+			sources[source] = make(map[string]DenomDiff)
+		}
+	}
+
+	for source := range sources {
+		for _, coin := range opening.Balances[source].Add(closing.Balances[source]...) {
+			denom := coin.Denom
+			o, c := opening.Balances[source].AmountOf(denom), closing.Balances[source].AmountOf(denom)
+			add(sources[source], denom, o, c)
+			add(total, denom, o, c)
+		}
+	}
+
+	return BalanceDiff{
+		Address: closing.Address,
+		From:    DiffPoint{Height: opening.Height, BlockTime: opening.BlockTime},
+		To:      DiffPoint{Height: closing.Height, BlockTime: closing.BlockTime},
+		Sources: sources,
+		Total:   total,
+	}
+}
+
+// getDiff compares the balances of an address between the "from" and "to"
+// query parameters, each a height, a date or an RFC 3339 time.
+func getDiff(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	from := c.Query("from")
+	to := c.Query("to")
+	if from == "" || to == "" {
+		respondError(c, c.Request.Context(), invalidParameter(errors.New("from and to must be set")))
+		return
+	}
+
+	ctx, cancel, err := requestContext(c)
+	if err != nil {
+		respondError(c, c.Request.Context(), invalidParameter(errors.Wrap(err, "failed to parse timeout")))
+		return
+	}
+	defer cancel()
+
+	var snapshots []Snapshot
+	for _, value := range []string{from, to} {
+		height, t, err := parsePoint(value)
+		if err != nil {
+			respondError(c, ctx, invalidParameter(err))
+			return
+		}
+		if height == 0 {
+			height, err = resolveHeight(ctx, chainParam, t)
+			if err != nil {
+				respondError(c, ctx, errors.Wrapf(err, "failed to resolve the height of %s", value))
+				return
+			}
+		}
+
+		snapshot, err := collectCompleteSnapshot(ctx, chainParam, addressParam, height)
+		if err != nil {
+			respondError(c, ctx, err)
+			return
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	respond(c, http.StatusOK, diffBalances(snapshots[0], snapshots[1]))
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"context"
+	"cosmos-balance-collector/fakenode"
+	"cosmossdk.io/math"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	e2eGenesis = time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	e2eNow     = time.Date(2024, 11, 1, 6, 0, 0, 0, time.UTC)
+)
+
+func e2eAddress(t *testing.T, prefix string, seed byte) string {
+	t.Helper()
+
+	var b = make([]byte, 20)
+	for i := range b {
+		b[i] = seed
+	}
+	address, err := bech32.ConvertAndEncode(prefix, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+// startFakeNode serves chain from an in-process node and configures the
+// collector to use it as "testchain".
+func startFakeNode(t *testing.T, chain *fakenode.Chain) {
+	t.Helper()
+
+	server := httptest.NewServer(fakenode.New(chain))
+	t.Cleanup(server.Close)
+
+	client, err := NewHTTPClient(server.URL, DEFAULT_TIMEOUT, ConnectionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Chains["testchain"] = ChainConfig{
+		StakingTokenDenom: "utest",
+		RPCUrl:            server.URL,
+		Client:            client,
+	}
+	t.Cleanup(func() {
+		// Shared calls outlive the requests that started them and read the
+		// configuration until they finish.
+		waitCoalesced()
+		delete(cfg.Chains, "testchain")
+	})
+}
+
+// dailyBalanceChain changes the address's bank balance at noon of every day,
+// to 1000utest plus 100utest for each day of October.
+func dailyBalanceChain(t *testing.T, address string) *fakenode.Chain {
+	chain := fakenode.NewChain("testchain-1", e2eGenesis, 6*time.Second)
+	chain.SetLatestHeight(chain.HeightAt(e2eNow))
+
+	for day := e2eGenesis; day.Before(e2eNow); day = day.Add(24 * time.Hour) {
+		noon := day.Add(12 * time.Hour)
+		chain.SetBalance(address, chain.HeightAt(noon), types.NewCoins(types.NewInt64Coin("utest", int64(1000+100*day.Day()))))
+	}
+
+	validator := e2eAddress(t, "cosmosvaloper", 9)
+	chain.SetDelegation(address, validator, 1, types.NewInt64Coin("utest", 5000))
+	chain.SetUnbonding(address, validator, 1, stakingtypes.UnbondingDelegationEntry{
+		CreationHeight: 1,
+		CompletionTime: e2eNow.Add(21 * 24 * time.Hour),
+		InitialBalance: math.NewInt(700),
+		Balance:        math.NewInt(700),
+	})
+	chain.SetRewards(address, validator, 1, types.NewDecCoins(types.NewDecCoinFromDec("utest", math.LegacyMustNewDecFromStr("12.75"))))
+
+	return chain
+}
+
+func doRequest(t *testing.T, target string) (int, Message) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	newRouter().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+
+	var message Message
+	if err := json.Unmarshal(recorder.Body.Bytes(), &message); err != nil {
+		t.Fatalf("failed to decode %s: %s", recorder.Body.String(), err)
+	}
+	return recorder.Code, message
+}
+
+// decodeContent converts a decoded Message content into v.
+func decodeContent(t *testing.T, content interface{}, v interface{}) {
+	t.Helper()
+
+	b, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetBalancesE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	status, message := doRequest(t, "/balances/testchain/"+address)
+	if status != http.StatusOK || !message.IsSuccess {
+		t.Fatalf("expected a successful status 200, got %d: %s", status, message.Error)
+	}
+
+	var balance Balance
+	decodeContent(t, message.Content, &balance)
+
+	for source, expected := range map[BalanceSource]string{
+		COSMOSSDK_BANK_BALANCE:        "4100utest",
+		COSMOSSDK_STAKING_DELEGATION:  "5000utest",
+		COSMOSSDK_STAKING_UNBONDING:   "700utest",
+		COSMOSSDK_DISTRIBUTION_REWARD: "12utest",
+	} {
+		if balance.Balances[source].String() != expected {
+			t.Errorf("source %d: expected %q, got %q", source, expected, balance.Balances[source].String())
+		}
+	}
+}
+
+func TestGetBalancesPeriodE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	status, message := doRequest(t, "/balances/testchain/"+address+"?startedAt=2024-10-25&endedAt=2024-10-31")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, message.Error)
+	}
+
+	var period map[string]map[BalanceSource]types.Coins
+	decodeContent(t, message.Content, &period)
+
+	if len(period) != 7 {
+		t.Fatalf("expected 7 days, got %d", len(period))
+	}
+
+	// At midnight the balance is still the one set at noon the day before.
+	for day := 25; day <= 31; day++ {
+		key := time.Date(2024, 10, day, 0, 0, 0, 0, time.UTC).String()
+		expected := types.NewCoins(types.NewInt64Coin("utest", int64(1000+100*(day-1))))
+		if !period[key][COSMOSSDK_BANK_BALANCE].Equal(expected) {
+			t.Errorf("%s: expected %s, got %s", key, expected, period[key][COSMOSSDK_BANK_BALANCE])
+		}
+	}
+}
+
+func TestGetBalancesPrunedE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	chain := dailyBalanceChain(t, address)
+	chain.Prune(chain.HeightAt(time.Date(2024, 10, 28, 0, 0, 0, 0, time.UTC)))
+	startFakeNode(t, chain)
+
+	status, message := doRequest(t, "/balances/testchain/"+address+"?startedAt=2024-10-20&endedAt=2024-10-31")
+	if status != http.StatusGone || message.Code != ERROR_HEIGHT_PRUNED {
+		t.Fatalf("expected height_pruned for a range reaching into pruned heights, got %d %s: %s", status, message.Code, message.Error)
+	}
+}
+
+func TestGetBalancesFailedSourceE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	chain := dailyBalanceChain(t, address)
+	chain.FailQuery("/cosmos.staking.v1beta1.Query/DelegatorDelegations", "delegations are unavailable")
+	startFakeNode(t, chain)
+
+	for _, target := range []string{
+		"/balances/testchain/" + address,
+		"/balances/testchain/" + address + "?startedAt=2024-10-25&endedAt=2024-10-31",
+	} {
+		status, message := doRequest(t, target)
+		if status != http.StatusBadGateway || message.Code != ERROR_UPSTREAM_ERROR || message.IsSuccess {
+			t.Errorf("%s: expected upstream_error for a failed source, got %d %s: %s", target, status, message.Code, message.Error)
+		}
+	}
+}
+
+func TestGetBalancesTimeoutE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	status, message := doRequest(t, "/balances/testchain/"+address+"?startedAt=2024-10-25&endedAt=2024-10-31&timeout=1ns")
+	if status != http.StatusGatewayTimeout || message.Code != ERROR_UPSTREAM_TIMEOUT {
+		t.Fatalf("expected status 504 upstream_timeout, got %d %s", status, message.Code)
+	}
+}
+
+func TestAPIErrorsE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	c := cfg.Chains["testchain"]
+	c.Bech32Prefix = "cosmos"
+	cfg.Chains["testchain"] = c
+
+	for _, tc := range []struct {
+		target string
+		status int
+		code   string
+	}{
+		{"/balances/nochain/" + address, http.StatusNotFound, ERROR_UNKNOWN_CHAIN},
+		{"/balances/testchain/cosmos1invalid", http.StatusBadRequest, ERROR_INVALID_ADDRESS},
+		{"/balances/testchain/" + e2eAddress(t, "osmo", 1), http.StatusBadRequest, ERROR_INVALID_ADDRESS},
+		{"/balances/testchain/" + address + "?startedAt=2024-10-31&endedAt=2024-10-25", http.StatusBadRequest, ERROR_INVALID_DATE_RANGE},
+		{"/balances/testchain/" + address + "?startedAt=2024-10-31&endedAt=2024-13-01", http.StatusBadRequest, ERROR_INVALID_PARAMETER},
+		{"/balances/testchain/" + address + "?startedAt=2024-10-25&endedAt=2099-01-01", http.StatusBadRequest, ERROR_INVALID_DATE_RANGE},
+		{"/balances/testchain/" + address + "?startedAt=2024-10-25&endedAt=2024-11-05", http.StatusBadRequest, ERROR_INVALID_DATE_RANGE},
+		{"/diff/testchain/" + address + "?from=2024-10-25&to=2099-01-01", http.StatusBadRequest, ERROR_INVALID_DATE_RANGE},
+		{"/activity/nochain/" + address, http.StatusNotFound, ERROR_UNKNOWN_CHAIN},
+	} {
+		status, message := doRequest(t, tc.target)
+		if status != tc.status || message.Code != tc.code || message.IsSuccess {
+			t.Errorf("%s: expected %d %s, got %d %s: %s", tc.target, tc.status, tc.code, status, message.Code, message.Error)
+		}
+	}
+}
+
+func TestDateResolverE2E(t *testing.T) {
+	// The resolver extrapolates from the latest block interval, so on chains
+	// whose block time changed it only lands near the start of the day.
+	for _, tc := range []struct {
+		name      string
+		script    func(chain *fakenode.Chain)
+		tolerance time.Duration
+	}{
+		{
+			name:      "constant block time",
+			script:    func(chain *fakenode.Chain) {},
+			tolerance: 10 * time.Second,
+		},
+		{
+			name: "faster blocks after an upgrade",
+			script: func(chain *fakenode.Chain) {
+				chain.SetBlockTime(chain.HeightAt(time.Date(2024, 10, 29, 15, 0, 0, 0, time.UTC)), 5*time.Second)
+			},
+			tolerance: 30 * time.Minute,
+		},
+		{
+			name: "halt",
+			script: func(chain *fakenode.Chain) {
+				chain.Halt(chain.HeightAt(time.Date(2024, 10, 30, 9, 0, 0, 0, time.UTC)), 3*time.Hour)
+			},
+			tolerance: 30 * time.Minute,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chain := fakenode.NewChain("testchain-1", e2eGenesis, 6*time.Second)
+			tc.script(chain)
+			chain.SetLatestHeight(chain.HeightAt(e2eNow))
+			startFakeNode(t, chain)
+
+			ctx := context.Background()
+			latestHeight, err := GetLatestHeight(ctx, "testchain")
+			if err != nil {
+				t.Fatal(err)
+			}
+			latestBlockTime, err := GetBlockTime(ctx, "testchain", latestHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secondBlockTime, err := GetBlockTime(ctx, "testchain", latestHeight-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			interval := latestBlockTime.Sub(*secondBlockTime)
+
+			startedAt := time.Date(2024, 10, 27, 0, 0, 0, 0, time.UTC)
+			endedAt := time.Date(2024, 10, 31, 0, 0, 0, 0, time.UTC)
+
+			endedAtHeight, err := calculateTargetTimeAndHeight(ctx, "testchain", endedAt, *latestBlockTime, interval, latestHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+			heights, err := calculateDailyHeights(ctx, "testchain", startedAt, endedAt, interval, endedAtHeight)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(heights) != 5 {
+				t.Fatalf("expected 5 days, got %d", len(heights))
+			}
+			for day, height := range heights {
+				if diff := chain.BlockTime(height).Sub(day); diff > tc.tolerance || diff < -tc.tolerance {
+					t.Errorf("%s: height %d is %s away from the start of the day", day.Format(time.DateOnly), height, diff)
+				}
+			}
+
+			// A single height is the last block at or before the time.
+			for day := range heights {
+				height, err := resolveHeight(ctx, "testchain", day)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if chain.BlockTime(height).After(day) || !chain.BlockTime(height+1).After(day) {
+					t.Errorf("%s: height %d at %s is not the last block before the start of the day", day.Format(time.DateOnly), height, chain.BlockTime(height))
+				}
+			}
+		})
+	}
+}
+
+func TestGetBalancesCSVE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	newRouter().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/balances/testchain/"+address+"?startedAt=2024-10-30&endedAt=2024-10-31&format=csv", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	records, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		t.Fatalf("unexpected header %v", records[0])
+	}
+	// Two days of bank, delegation, unbonding and reward rows.
+	if len(records) != 1+2*4 {
+		t.Fatalf("expected 8 rows, got %d", len(records)-1)
+	}
+	if row := records[1]; row[2] != "2024-10-30" || row[5] != "bank" || row[7] != "3900" {
+		t.Errorf("unexpected first row %v", row)
+	}
+}
+
+func TestGetBalancesExportFailedSourceE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	chain := dailyBalanceChain(t, address)
+	chain.FailQuery("/cosmos.staking.v1beta1.Query/DelegatorDelegations", "delegations are unavailable")
+	startFakeNode(t, chain)
+
+	for _, target := range []string{
+		"/balances/testchain/" + address + "?format=jsonl",
+		"/balances/testchain/" + address + "?startedAt=2024-10-30&endedAt=2024-10-31&format=csv",
+		// A missing source would be asserted as a zero balance.
+		"/balances/testchain/" + address + "?startedAt=2024-10-30&endedAt=2024-10-31&format=beancount",
+	} {
+		status, message := doRequest(t, target)
+		if status != http.StatusBadGateway || message.Code != ERROR_UPSTREAM_ERROR {
+			t.Errorf("%s: expected upstream_error rather than a partial export, got %d %s: %s", target, status, message.Code, message.Error)
+		}
+	}
+}
+
+func TestGetDiffE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	status, message := doRequest(t, "/diff/testchain/"+address+"?from=2024-10-25&to=2024-10-31")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, message.Error)
+	}
+
+	var diff BalanceDiff
+	decodeContent(t, message.Content, &diff)
+
+	bank := diff.Sources[COSMOSSDK_BANK_BALANCE]["utest"]
+	if bank.Opening.Int64() != 3400 || bank.Closing.Int64() != 4000 || bank.Delta.Int64() != 600 {
+		t.Errorf("unexpected bank diff %+v", bank)
+	}
+	if delegation := diff.Sources[COSMOSSDK_STAKING_DELEGATION]["utest"]; !delegation.Delta.IsZero() {
+		t.Errorf("unexpected delegation diff %+v", delegation)
+	}
+	total := diff.Total["utest"]
+	if total.Opening.Int64() != 3400+5000+700+12 || total.Delta.Int64() != 600 {
+		t.Errorf("unexpected total diff %+v", total)
+	}
+
+	status, _ = doRequest(t, "/diff/testchain/"+address+"?from=yesterday&to=2024-10-31")
+	if status != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unparsable point, got %d", status)
+	}
+}
+
+func TestGetDiffMultiValidatorE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	chain := fakenode.NewChain("testchain-1", e2eGenesis, 6*time.Second)
+	chain.SetLatestHeight(chain.HeightAt(e2eNow))
+	startFakeNode(t, chain)
+
+	rewards := func(utest, uatom string) types.DecCoins {
+		return types.NewDecCoins(
+			types.NewDecCoinFromDec("utest", math.LegacyMustNewDecFromStr(utest)),
+			types.NewDecCoinFromDec("uatom", math.LegacyMustNewDecFromStr(uatom)),
+		)
+	}
+	change := chain.HeightAt(time.Date(2024, 10, 28, 12, 0, 0, 0, time.UTC))
+	first, second := e2eAddress(t, "cosmosvaloper", 8), e2eAddress(t, "cosmosvaloper", 9)
+	chain.SetDelegation(address, first, 1, types.NewInt64Coin("utest", 100))
+	chain.SetDelegation(address, second, 1, types.NewInt64Coin("utest", 25))
+	chain.SetDelegation(address, second, change, types.NewInt64Coin("utest", 50))
+	chain.SetRewards(address, first, 1, rewards("5.5", "2"))
+	chain.SetRewards(address, second, 1, rewards("7", "1.25"))
+	chain.SetRewards(address, first, change, rewards("9", "3"))
+	chain.SetRewards(address, second, change, rewards("11.5", "4"))
+
+	status, message := doRequest(t, "/diff/testchain/"+address+"?from=2024-10-25&to=2024-10-31")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, message.Error)
+	}
+
+	var diff BalanceDiff
+	decodeContent(t, message.Content, &diff)
+
+	for _, tc := range []struct {
+		source           BalanceSource
+		denom            string
+		opening, closing int64
+	}{
+		{COSMOSSDK_STAKING_DELEGATION, "utest", 125, 150},
+		{COSMOSSDK_DISTRIBUTION_REWARD, "utest", 12, 20},
+		{COSMOSSDK_DISTRIBUTION_REWARD, "uatom", 3, 7},
+	} {
+		d := diff.Sources[tc.source][tc.denom]
+		if d.Opening.Int64() != tc.opening || d.Closing.Int64() != tc.closing || d.Delta.Int64() != tc.closing-tc.opening {
+			t.Errorf("%s %s: unexpected diff %+v", tc.source, tc.denom, d)
+		}
+	}
+	if total := diff.Total["utest"]; total.Opening.Int64() != 125+12 || total.Closing.Int64() != 150+20 {
+		t.Errorf("unexpected total diff %+v", total)
+	}
+}
+
+func TestGetDiffFailedSourceE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	chain := dailyBalanceChain(t, address)
+	chain.FailQuery("/cosmos.staking.v1beta1.Query/DelegatorDelegations", "delegations are unavailable")
+	startFakeNode(t, chain)
+
+	// An empty delegation would be reported as a drop to zero.
+	status, message := doRequest(t, "/diff/testchain/"+address+"?from=2024-10-25&to=2024-10-31")
+	if status != http.StatusBadGateway || message.Code != ERROR_UPSTREAM_ERROR {
+		t.Fatalf("expected upstream_error for a failed source, got %d %s: %s", status, message.Code, message.Error)
+	}
+}
+
+func TestGetActivityE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	other := e2eAddress(t, "cosmos", 2)
+	validator := e2eAddress(t, "cosmosvaloper", 9)
+	feeCollector, err := bech32.ConvertAndEncode("cosmos", authtypes.NewModuleAddress(authtypes.FeeCollectorName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := dailyBalanceChain(t, address)
+	attributes := func(kv ...string) []fakenode.Attribute {
+		var attributes []fakenode.Attribute
+		for i := 0; i < len(kv); i += 2 {
+			attributes = append(attributes, fakenode.Attribute{Key: kv[i], Value: kv[i+1]})
+		}
+		return attributes
+	}
+	at := func(day, hour int) int64 {
+		return chain.HeightAt(time.Date(2024, 10, day, hour, 0, 0, 0, time.UTC))
+	}
+
+	// A send in the Cosmos SDK v0.50 layout, whose fee is paid by the ante
+	// handler without msg_index.
+	chain.AddTx(at(26, 10), 0,
+		fakenode.Event{Type: "transfer", Attributes: attributes("recipient", feeCollector, "sender", address, "amount", "500utest")},
+		fakenode.Event{Type: "message", Attributes: attributes("action", "/cosmos.bank.v1beta1.MsgSend", "sender", address, "module", "bank", "msg_index", "0")},
+		fakenode.Event{Type: "transfer", Attributes: attributes("recipient", other, "sender", address, "amount", "250utest", "msg_index", "0")},
+	)
+	// A delegation that claims the pending rewards.
+	chain.AddTx(at(27, 10), 0,
+		fakenode.Event{Type: "message", Attributes: attributes("action", "/cosmos.staking.v1beta1.MsgDelegate", "sender", address, "module", "staking", "msg_index", "0")},
+		fakenode.Event{Type: "withdraw_rewards", Attributes: attributes("amount", "3utest", "validator", validator, "delegator", address, "msg_index", "0")},
+		fakenode.Event{Type: "delegate", Attributes: attributes("validator", validator, "delegator", address, "amount", "1000utest", "new_shares", "1000.000000000000000000", "msg_index", "0")},
+	)
+	// A withdrawal in the Cosmos SDK v0.47 layout, without msg_index.
+	chain.AddTx(at(28, 10), 0,
+		fakenode.Event{Type: "transfer", Attributes: attributes("recipient", feeCollector, "sender", address, "amount", "500utest")},
+		fakenode.Event{Type: "message", Attributes: attributes("action", "/cosmos.distribution.v1beta1.MsgWithdrawDelegatorReward", "sender", address, "module", "distribution")},
+		fakenode.Event{Type: "withdraw_rewards", Attributes: attributes("amount", "7utest", "validator", validator)},
+	)
+	// An incoming send, and a failed one that must be ignored.
+	chain.AddTx(at(29, 10), 0,
+		fakenode.Event{Type: "message", Attributes: attributes("action", "/cosmos.bank.v1beta1.MsgSend", "sender", other, "module", "bank", "msg_index", "0")},
+		fakenode.Event{Type: "transfer", Attributes: attributes("recipient", address, "sender", other, "amount", "40utest", "msg_index", "0")},
+	)
+	chain.AddTx(at(29, 11), 5,
+		fakenode.Event{Type: "message", Attributes: attributes("action", "/cosmos.bank.v1beta1.MsgSend", "sender", address, "module", "bank", "msg_index", "0")},
+		fakenode.Event{Type: "transfer", Attributes: attributes("recipient", other, "sender", address, "amount", "99utest", "msg_index", "0")},
+	)
+	startFakeNode(t, chain)
+
+	status, message := doRequest(t, "/activity/testchain/"+address+"?from=2024-10-26&to=2024-10-31")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, message.Error)
+	}
+
+	var activities []Activity
+	decodeContent(t, message.Content, &activities)
+
+	expected := []struct {
+		activityType ActivityType
+		counterparty string
+		amount       string
+		autoClaimed  bool
+	}{
+		{ACTIVITY_SEND, other, "250utest", false},
+		{ACTIVITY_REWARD_WITHDRAWAL, validator, "3utest", true},
+		{ACTIVITY_DELEGATE, validator, "1000utest", false},
+		{ACTIVITY_REWARD_WITHDRAWAL, validator, "7utest", false},
+		{ACTIVITY_RECEIVE, other, "40utest", false},
+	}
+	if len(activities) != len(expected) {
+		t.Fatalf("expected %d activities, got %+v", len(expected), activities)
+	}
+	for i, e := range expected {
+		a := activities[i]
+		if a.Type != e.activityType || a.Counterparty != e.counterparty || a.Amount.String() != e.amount || a.AutoClaimed != e.autoClaimed {
+			t.Errorf("activity %d: expected %+v, got %+v", i, e, a)
+		}
+		if a.Time.IsZero() {
+			t.Errorf("activity %d: missing time", i)
+		}
+	}
+
+	status, _ = doRequest(t, "/activity/testchain/not-an-address")
+	if status == http.StatusOK {
+		t.Error("expected an error for an invalid address")
+	}
+
+	// Searches matching more transactions than are paged through fail until
+	// the range is narrowed.
+	previous := TX_SEARCH_MAX_RESULTS
+	TX_SEARCH_MAX_RESULTS = 2
+	defer func() { TX_SEARCH_MAX_RESULTS = previous }()
+	status, message = doRequest(t, "/activity/testchain/"+address+"?from=2024-10-26&to=2024-10-31")
+	if status != http.StatusBadRequest || message.Code != ERROR_TOO_MANY_RESULTS {
+		t.Errorf("expected 400 %s, got %d %s: %s", ERROR_TOO_MANY_RESULTS, status, message.Code, message.Error)
+	}
+	status, message = doRequest(t, "/activity/testchain/"+address+"?from=2024-10-26&to=2024-10-26")
+	if status != http.StatusOK {
+		t.Errorf("expected status 200 for one day, got %d: %s", status, message.Error)
+	}
+}
+
+func TestGetIncomeE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	validator := e2eAddress(t, "cosmosvaloper", 8)
+
+	chain := dailyBalanceChain(t, address)
+	at := func(day, hour int) int64 {
+		return chain.HeightAt(time.Date(2024, 10, day, hour, 0, 0, 0, time.UTC))
+	}
+	rewards := func(amount string) types.DecCoins {
+		return types.NewDecCoins(types.NewDecCoinFromDec("utest", math.LegacyMustNewDecFromStr(amount)))
+	}
+	chain.SetRewards(address, validator, at(24, 12), rewards("2"))
+	chain.SetRewards(address, validator, at(25, 12), rewards("10"))
+	chain.SetRewards(address, validator, at(26, 12), rewards("20.5"))
+	// 25utest are withdrawn on the 27th, after which rewards accrue again.
+	chain.AddTx(at(27, 10), 0,
+		fakenode.Event{Type: "message", Attributes: []fakenode.Attribute{
+			{Key: "action", Value: "/cosmos.distribution.v1beta1.MsgWithdrawDelegatorReward"},
+			{Key: "sender", Value: address},
+			{Key: "msg_index", Value: "0"},
+		}},
+		fakenode.Event{Type: "withdraw_rewards", Attributes: []fakenode.Attribute{
+			{Key: "amount", Value: "25utest"},
+			{Key: "validator", Value: validator},
+			{Key: "delegator", Value: address},
+			{Key: "msg_index", Value: "0"},
+		}},
+	)
+	chain.SetRewards(address, validator, at(27, 10), rewards("0.5"))
+	chain.SetRewards(address, validator, at(27, 12), rewards("5.5"))
+	startFakeNode(t, chain)
+
+	status, message := doRequest(t, "/income/testchain/"+address+"?startedAt=2024-10-25&endedAt=2024-10-27")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, message.Error)
+	}
+
+	var report IncomeReport
+	decodeContent(t, message.Content, &report)
+
+	expected := []struct {
+		date    string
+		accrued string
+		claimed int64
+	}{
+		{"2024-10-25", "8", 0},
+		{"2024-10-26", "10.5", 0},
+		{"2024-10-27", "10", 25},
+	}
+	if len(report.Entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), report.Entries)
+	}
+	for i, e := range expected {
+		entry := report.Entries[i]
+		if entry.Date != e.date || entry.Validator != validator || !entry.Accrued.Equal(math.LegacyMustNewDecFromStr(e.accrued)) || entry.Claimed.Int64() != e.claimed {
+			t.Errorf("entry %d: expected %+v, got %+v", i, e, entry)
+		}
+	}
+	if total := report.Total["utest"]; !total.Accrued.Equal(math.LegacyMustNewDecFromStr("28.5")) || total.Claimed.Int64() != 25 {
+		t.Errorf("unexpected total %+v", total)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	FORMAT_JSON  = "json"
+	FORMAT_CSV   = "csv"
+	FORMAT_JSONL = "jsonl"
+	// FORMAT_BEANCOUNT and FORMAT_LEDGER are plain-text accounting journals,
+	// see LedgerWriter.
+	FORMAT_BEANCOUNT = "beancount"
+	FORMAT_LEDGER    = "ledger"
+)
+
+var csvHeader = []string{"chain", "address", "date", "height", "block_time", "source", "denom", "amount"}
+
+// Snapshot is every balance of an address at one height.
+type Snapshot struct {
+	Chain     string                        `json:"chain"`
+	Address   string                        `json:"address"`
+	Date      time.Time                     `json:"date"`
+	Height    int64                         `json:"height"`
+	BlockTime time.Time                     `json:"blockTime"`
+	Balances  map[BalanceSource]types.Coins `json:"balances"`
+	// Daily marks a snapshot taken at the start of Date, as opposed to one
+	// taken at any other time of the day.
+	Daily bool `json:"daily,omitempty"`
+	// CollectedAt is when the balances were queried.
+	CollectedAt time.Time `json:"collectedAt"`
+}
+
+// ExportRow is one denom of one balance source in a snapshot, the unit of the
+// CSV and JSON Lines exports.
+type ExportRow struct {
+	Chain     string    `json:"chain"`
+	Address   string    `json:"address"`
+	Date      string    `json:"date"`
+	Height    int64     `json:"height"`
+	BlockTime time.Time `json:"blockTime"`
+	Source    string    `json:"source"`
+	Denom     string    `json:"denom"`
+	Amount    string    `json:"amount"`
+}
+
+func (s Snapshot) rows() []ExportRow {
+	var rows []ExportRow
+	for _, source := range sortedSources(s.Balances) {
+		for _, coin := range s.Balances[source] {
+			rows = append(rows, ExportRow{
+				Chain:     s.Chain,
+				Address:   s.Address,
+				Date:      s.Date.Format(time.DateOnly),
+				Height:    s.Height,
+				BlockTime: s.BlockTime,
+				Source:    source.String(),
+				Denom:     coin.Denom,
+				Amount:    coin.Amount.String(),
+			})
+		}
+	}
+	return rows
+}
+
+// collectCompleteSnapshot queries every balance of address at height, or at
+// the latest height when height is 0, failing when any balance source fails:
+// an empty source would read as a zero balance. Pinning the latest height
+// keeps the sources consistent with each other and with the reported block
+// time.
+func collectCompleteSnapshot(ctx context.Context, chain, address string, height int64) (Snapshot, error) {
+	if height == 0 {
+		latestHeight, err := GetLatestHeight(ctx, chain)
+		if err != nil {
+			return Snapshot{}, errors.Wrap(err, "failed to get latestHeight")
+		}
+		height = latestHeight
+	}
+
+	blockTime, err := GetBlockTime(ctx, chain, height)
+	if err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to get block time")
+	}
+
+	balances, err := queryCompleteBalances(ctx, chain, address, height)
+	if err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to query balances")
+	}
+
+	return Snapshot{
+		Chain:       chain,
+		Address:     address,
+		Date:        blockTime.Truncate(24 * time.Hour),
+		Height:      height,
+		BlockTime:   *blockTime,
+		Balances:    balances,
+		CollectedAt: time.Now().UTC(),
+	}, nil
+}
+
+// collectDailySnapshots takes a snapshot of every address at each of heights,
+// which must belong to chain, ordered by date and then by address.
+func collectDailySnapshots(ctx context.Context, chain string, addresses []string, heights map[time.Time]int64) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	for _, day := range sortedDays(heights) {
+		for _, address := range addresses {
+			if snapshot, ok := storedDailySnapshot(ctx, chain, address, day); ok {
+				snapshots = append(snapshots, snapshot)
+				continue
+			}
+
+			snapshot, err := collectCompleteSnapshot(ctx, chain, address, heights[day])
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s %s on %s", chain, address, day.Format(time.DateOnly))
+			}
+			snapshot.Date = day
+			snapshot.Daily = true
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// SnapshotWriter writes snapshots as CSV or JSON Lines, one row per date,
+// source and denom, or as a Beancount or Ledger journal.
+type SnapshotWriter struct {
+	format string
+	csv    *csv.Writer
+	json   *json.Encoder
+	ledger *LedgerWriter
+	header bool
+}
+
+func NewSnapshotWriter(w io.Writer, format string) (*SnapshotWriter, error) {
+	switch format {
+	case FORMAT_CSV:
+		return &SnapshotWriter{format: format, csv: csv.NewWriter(w)}, nil
+	case FORMAT_JSONL:
+		return &SnapshotWriter{format: format, json: json.NewEncoder(w)}, nil
+	case FORMAT_BEANCOUNT, FORMAT_LEDGER:
+		return &SnapshotWriter{format: format, ledger: NewLedgerWriter(w, format)}, nil
+	}
+	return nil, errors.Errorf("unknown export format %s", format)
+}
+
+func (w *SnapshotWriter) Write(snapshot Snapshot) error {
+	if w.ledger != nil {
+		return w.ledger.Write(snapshot)
+	}
+	if w.format == FORMAT_JSONL {
+		for _, row := range snapshot.rows() {
+			if err := w.json.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !w.header {
+		if err := w.csv.Write(csvHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	for _, row := range snapshot.rows() {
+		err := w.csv.Write([]string{
+			row.Chain,
+			row.Address,
+			row.Date,
+			strconv.FormatInt(row.Height, 10),
+			row.BlockTime.Format(time.RFC3339Nano),
+			row.Source,
+			row.Denom,
+			row.Amount,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the CSV header even when there were no snapshots, and any
+// buffered rows.
+func (w *SnapshotWriter) Flush() error {
+	if w.ledger != nil {
+		return w.ledger.Flush()
+	}
+	if w.format != FORMAT_CSV {
+		return nil
+	}
+	if !w.header {
+		if err := w.csv.Write(csvHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// writeSnapshots writes snapshots of addresses on chain, ordered by date. A
+// journal also gets the transactions known from the activity of the addresses
+// in between, so that only unexplained changes are padded.
+func writeSnapshots(ctx context.Context, w *SnapshotWriter, chain string, addresses []string, snapshots []Snapshot) error {
+	var transactions []LedgerTransaction
+	if w.ledger != nil && len(snapshots) > 1 {
+		// The first snapshot already holds what happened at its height.
+		fromHeight, toHeight := snapshots[0].Height+1, snapshots[len(snapshots)-1].Height
+		for _, address := range addresses {
+			activities, err := queryActivity(ctx, chain, address, fromHeight, toHeight)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// Nodes without a transaction index cannot tell; the pad
+				// account absorbs the changes instead.
+				log.WithError(err).Warningf("no transactions for %s on %s", address, chain)
+				continue
+			}
+			for _, activity := range activities {
+				if tx, ok := ledgerTransaction(activity); ok {
+					transactions = append(transactions, tx)
+				}
+			}
+		}
+		sort.SliceStable(transactions, func(i, j int) bool { return transactions[i].Date.Before(transactions[j].Date) })
+	}
+
+	for _, snapshot := range snapshots {
+		for len(transactions) > 0 && transactions[0].Date.Before(snapshot.Date) {
+			if err := w.ledger.WriteTransaction(transactions[0]); err != nil {
+				return err
+			}
+			transactions = transactions[1:]
+		}
+		if err := w.Write(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contentType(format string) string {
+	switch format {
+	case FORMAT_CSV:
+		return "text/csv; charset=utf-8"
+	case FORMAT_BEANCOUNT, FORMAT_LEDGER:
+		return "text/plain; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+func validExportFormat(format string) bool {
+	switch format {
+	case FORMAT_CSV, FORMAT_JSONL, FORMAT_BEANCOUNT, FORMAT_LEDGER:
+		return true
+	}
+	return false
+}
+
+// exportBalances answers getBalances in one of the export formats.
+func exportBalances(c *gin.Context, ctx context.Context, format, chain, address string, heights map[time.Time]int64) {
+	var (
+		snapshots []Snapshot
+		err       error
+	)
+	if heights == nil {
+		var snapshot Snapshot
+		snapshot, err = collectCompleteSnapshot(ctx, chain, address, 0)
+		snapshots = []Snapshot{snapshot}
+	} else {
+		snapshots, err = collectDailySnapshots(ctx, chain, []string{address}, heights)
+	}
+	if err != nil {
+		respondError(c, ctx, err)
+		return
+	}
+
+	c.Header("Content-Type", contentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.%s", chain, address, format)))
+	c.Status(http.StatusOK)
+
+	w, _ := NewSnapshotWriter(c.Writer, format)
+	if err := writeSnapshots(ctx, w, chain, []string{address}, snapshots); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if err := w.Flush(); err != nil {
+		_ = c.Error(err)
+	}
+}
@@ -0,0 +1,459 @@
+// Package fakenode is an in-process stand-in for a CometBFT RPC node serving
+// the Cosmos SDK queries the collector makes, backed by a scripted chain.
+//
+//	chain := fakenode.NewChain("testchain-1", genesis, 6*time.Second)
+//	chain.SetBalance(address, 1, sdk.NewCoins(sdk.NewInt64Coin("utest", 100)))
+//	chain.SetLatestHeight(chain.HeightAt(now))
+//	server := httptest.NewServer(fakenode.New(chain))
+package fakenode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	distributiontypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segment is a run of blocks produced at a constant interval.
+type segment struct {
+	fromHeight int64
+	blockTime  time.Duration
+}
+
+// halt delays the block after height by duration.
+type halt struct {
+	afterHeight int64
+	duration    time.Duration
+}
+
+// timeline holds a value that changes at given heights.
+type timeline[T any] struct {
+	heights []int64
+	values  []T
+}
+
+func (t *timeline[T]) set(height int64, value T) {
+	i := sort.Search(len(t.heights), func(i int) bool { return t.heights[i] >= height })
+	if i < len(t.heights) && t.heights[i] == height {
+		t.values[i] = value
+		return
+	}
+
+	t.heights = append(t.heights, 0)
+	t.values = append(t.values, value)
+	copy(t.heights[i+1:], t.heights[i:])
+	copy(t.values[i+1:], t.values[i:])
+	t.heights[i] = height
+	t.values[i] = value
+}
+
+func (t *timeline[T]) at(height int64) (T, bool) {
+	i := sort.Search(len(t.heights), func(i int) bool { return t.heights[i] > height })
+	if i == 0 {
+		var zero T
+		return zero, false
+	}
+	return t.values[i-1], true
+}
+
+// Chain is the scripted state of a chain: how blocks are produced and how
+// account state changes with height. All methods are safe for concurrent use,
+// so tests can move the chain forward while the node is serving.
+type Chain struct {
+	mtx sync.RWMutex
+
+	chainID        string
+	genesis        time.Time
+	segments       []segment
+	halts          []halt
+	latestHeight   int64
+	earliestHeight int64
+	catchingUp     bool
+	version        string
+	failing        map[string]string
+
+	balances    map[string]*timeline[sdk.Coins]
+	delegations map[string]map[string]*timeline[sdk.Coin]
+	unbondings  map[string]map[string]*timeline[[]stakingtypes.UnbondingDelegationEntry]
+	rewards     map[string]map[string]*timeline[sdk.DecCoins]
+	commissions map[string]*timeline[sdk.DecCoins]
+	accounts    map[string]*timeline[sdk.AccountI]
+
+	txs []tx
+}
+
+// Event is an event of a transaction result, as served by tx_search.
+type Event struct {
+	Type       string
+	Attributes []Attribute
+}
+
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+type tx struct {
+	hash   string
+	height int64
+	index  int
+	code   uint32
+	events []Event
+}
+
+// NewChain returns a chain whose first block is produced at genesis and
+// following blocks every blockTime. Its latest height is 1 until changed.
+func NewChain(chainID string, genesis time.Time, blockTime time.Duration) *Chain {
+	return &Chain{
+		chainID:        chainID,
+		genesis:        genesis,
+		segments:       []segment{{fromHeight: 1, blockTime: blockTime}},
+		latestHeight:   1,
+		earliestHeight: 1,
+		version:        "0.38.12",
+		balances:       make(map[string]*timeline[sdk.Coins]),
+		delegations:    make(map[string]map[string]*timeline[sdk.Coin]),
+		unbondings:     make(map[string]map[string]*timeline[[]stakingtypes.UnbondingDelegationEntry]),
+		rewards:        make(map[string]map[string]*timeline[sdk.DecCoins]),
+		commissions:    make(map[string]*timeline[sdk.DecCoins]),
+		accounts:       make(map[string]*timeline[sdk.AccountI]),
+		failing:        make(map[string]string),
+	}
+}
+
+func (c *Chain) ChainID() string {
+	return c.chainID
+}
+
+// SetBlockTime changes the interval between blocks from height on.
+func (c *Chain) SetBlockTime(fromHeight int64, blockTime time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.segments = append(c.segments, segment{fromHeight: fromHeight, blockTime: blockTime})
+	sort.SliceStable(c.segments, func(i, j int) bool { return c.segments[i].fromHeight < c.segments[j].fromHeight })
+}
+
+// Halt stops the chain after height for duration before the next block.
+func (c *Chain) Halt(afterHeight int64, duration time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.halts = append(c.halts, halt{afterHeight: afterHeight, duration: duration})
+}
+
+// SetLatestHeight moves the head of the chain.
+func (c *Chain) SetLatestHeight(height int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.latestHeight = height
+}
+
+// Prune makes blocks and state below height unavailable.
+func (c *Chain) Prune(height int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.earliestHeight = height
+}
+
+// FailQuery makes ABCI queries of path answer with an internal JSON-RPC
+// error carrying message, as a node does when its application is unhealthy.
+// An empty message lets the queries succeed again.
+func (c *Chain) FailQuery(path, message string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if message == "" {
+		delete(c.failing, path)
+		return
+	}
+	c.failing[path] = message
+}
+
+func (c *Chain) SetCatchingUp(catchingUp bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.catchingUp = catchingUp
+}
+
+func (c *Chain) SetVersion(version string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.version = version
+}
+
+func (c *Chain) LatestHeight() int64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.latestHeight
+}
+
+// BlockTime returns the time of the block at height.
+func (c *Chain) BlockTime(height int64) time.Time {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.blockTime(height)
+}
+
+func (c *Chain) blockTime(height int64) time.Time {
+	var elapsed time.Duration
+	for i, s := range c.segments {
+		if s.fromHeight >= height {
+			break
+		}
+		end := height
+		if i+1 < len(c.segments) && c.segments[i+1].fromHeight < height {
+			end = c.segments[i+1].fromHeight
+		}
+		elapsed += time.Duration(end-s.fromHeight) * s.blockTime
+	}
+
+	for _, h := range c.halts {
+		if h.afterHeight < height {
+			elapsed += h.duration
+		}
+	}
+
+	return c.genesis.Add(elapsed)
+}
+
+// HeightAt returns the height of the last block at or before t, whether or
+// not the chain has reached it yet.
+func (c *Chain) HeightAt(t time.Time) int64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if t.Before(c.genesis) {
+		return 0
+	}
+
+	// Block times are monotonic, so search for the first block after t.
+	lo, hi := int64(1), int64(2)
+	for !c.blockTime(hi).After(t) {
+		lo, hi = hi, hi*2
+	}
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		if c.blockTime(mid).After(t) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return lo
+}
+
+// SetBalance sets the bank balances of address from height on.
+func (c *Chain) SetBalance(address string, height int64, coins sdk.Coins) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	t, ok := c.balances[address]
+	if !ok {
+		t = &timeline[sdk.Coins]{}
+		c.balances[address] = t
+	}
+	t.set(height, coins)
+}
+
+// SetDelegation sets the amount address has delegated to validator from
+// height on. A zero amount removes the delegation.
+func (c *Chain) SetDelegation(address, validator string, height int64, amount sdk.Coin) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	nested(c.delegations, address, validator).set(height, amount)
+}
+
+// SetUnbonding sets the unbonding entries of address from validator from
+// height on. No entries remove the unbonding delegation.
+func (c *Chain) SetUnbonding(address, validator string, height int64, entries ...stakingtypes.UnbondingDelegationEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	nested(c.unbondings, address, validator).set(height, entries)
+}
+
+// SetRewards sets the outstanding rewards of address from validator from
+// height on.
+func (c *Chain) SetRewards(address, validator string, height int64, rewards sdk.DecCoins) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	nested(c.rewards, address, validator).set(height, rewards)
+}
+
+// SetCommission sets the accumulated commission of validator from height on.
+func (c *Chain) SetCommission(validator string, height int64, commission sdk.DecCoins) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	t, ok := c.commissions[validator]
+	if !ok {
+		t = &timeline[sdk.DecCoins]{}
+		c.commissions[validator] = t
+	}
+	t.set(height, commission)
+}
+
+// SetAccount sets the auth account stored at address from height on, e.g. a
+// vesting account.
+func (c *Chain) SetAccount(address string, height int64, account sdk.AccountI) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	t, ok := c.accounts[address]
+	if !ok {
+		t = &timeline[sdk.AccountI]{}
+		c.accounts[address] = t
+	}
+	t.set(height, account)
+}
+
+// AddTx includes a transaction with result code and events in the block at
+// height and returns its hash.
+func (c *Chain) AddTx(height int64, code uint32, events ...Event) string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var index int
+	for _, t := range c.txs {
+		if t.height == height {
+			index++
+		}
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/tx/%d/%d", c.chainID, height, index)))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	c.txs = append(c.txs, tx{hash: hash, height: height, index: index, code: code, events: events})
+	sort.SliceStable(c.txs, func(i, j int) bool { return c.txs[i].height < c.txs[j].height })
+	return hash
+}
+
+func nested[T any](m map[string]map[string]*timeline[T], address, validator string) *timeline[T] {
+	byValidator, ok := m[address]
+	if !ok {
+		byValidator = make(map[string]*timeline[T])
+		m[address] = byValidator
+	}
+	t, ok := byValidator[validator]
+	if !ok {
+		t = &timeline[T]{}
+		byValidator[validator] = t
+	}
+	return t
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *Chain) balancesAt(address string, height int64) sdk.Coins {
+	if t, ok := c.balances[address]; ok {
+		coins, _ := t.at(height)
+		return coins
+	}
+	return nil
+}
+
+func (c *Chain) delegationsAt(address string, height int64) []stakingtypes.DelegationResponse {
+	var result []stakingtypes.DelegationResponse
+	for _, validator := range sortedKeys(c.delegations[address]) {
+		amount, ok := c.delegations[address][validator].at(height)
+		if !ok || amount.IsZero() {
+			continue
+		}
+		result = append(result, stakingtypes.DelegationResponse{
+			Delegation: stakingtypes.Delegation{
+				DelegatorAddress: address,
+				ValidatorAddress: validator,
+				Shares:           amount.Amount.ToLegacyDec(),
+			},
+			Balance: amount,
+		})
+	}
+	return result
+}
+
+func (c *Chain) unbondingsAt(address string, height int64) []stakingtypes.UnbondingDelegation {
+	var result []stakingtypes.UnbondingDelegation
+	for _, validator := range sortedKeys(c.unbondings[address]) {
+		entries, ok := c.unbondings[address][validator].at(height)
+		if !ok || len(entries) == 0 {
+			continue
+		}
+		result = append(result, stakingtypes.UnbondingDelegation{
+			DelegatorAddress: address,
+			ValidatorAddress: validator,
+			Entries:          entries,
+		})
+	}
+	return result
+}
+
+func (c *Chain) rewardsAt(address string, height int64) ([]distributiontypes.DelegationDelegatorReward, sdk.DecCoins) {
+	var (
+		result []distributiontypes.DelegationDelegatorReward
+		total  sdk.DecCoins
+	)
+	for _, validator := range sortedKeys(c.rewards[address]) {
+		rewards, ok := c.rewards[address][validator].at(height)
+		if !ok {
+			continue
+		}
+		result = append(result, distributiontypes.DelegationDelegatorReward{
+			ValidatorAddress: validator,
+			Reward:           rewards,
+		})
+		total = total.Add(rewards...)
+	}
+	return result, total
+}
+
+func (c *Chain) commissionAt(validator string, height int64) sdk.DecCoins {
+	if t, ok := c.commissions[validator]; ok {
+		commission, _ := t.at(height)
+		return commission
+	}
+	return nil
+}
+
+func (c *Chain) accountAt(address string, height int64) sdk.AccountI {
+	if t, ok := c.accounts[address]; ok {
+		if account, ok := t.at(height); ok {
+			return account
+		}
+	}
+	return nil
+}
+
+// baseAccount returns the BaseAccount embedded in account, as reported by the
+// AccountInfo query.
+func baseAccount(account sdk.AccountI) *authtypes.BaseAccount {
+	if base, ok := account.(*authtypes.BaseAccount); ok {
+		return base
+	}
+	if withBase, ok := account.(interface{ GetBaseAccount() *authtypes.BaseAccount }); ok {
+		return withBase.GetBaseAccount()
+	}
+	return authtypes.NewBaseAccount(account.GetAddress(), nil, account.GetAccountNumber(), account.GetSequence())
+}
@@ -0,0 +1,493 @@
+package fakenode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	codectypes "github.com/cosmos/cosmos-sdk/codec/types"
+	"github.com/cosmos/cosmos-sdk/types/query"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	distributiontypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	gogoproto "github.com/cosmos/gogoproto/proto"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// codeInvalidHeight is the Cosmos SDK error code for queries at heights
+	// that are pruned or not reached yet.
+	codeInvalidHeight = 26
+	// codeNotFound is the Cosmos SDK error code for unknown accounts.
+	codeNotFound = 22
+	// codeUnknownRequest is the Cosmos SDK error code for unsupported paths.
+	codeUnknownRequest = 6
+)
+
+// Node serves a Chain over the CometBFT JSON-RPC URI interface.
+type Node struct {
+	chain *Chain
+	mux   *http.ServeMux
+}
+
+func New(chain *Chain) *Node {
+	n := &Node{
+		chain: chain,
+		mux:   http.NewServeMux(),
+	}
+	n.mux.HandleFunc("/status", n.status)
+	n.mux.HandleFunc("/block", n.block)
+	n.mux.HandleFunc("/abci_query", n.abciQuery)
+	n.mux.HandleFunc("/tx_search", n.txSearch)
+	return n
+}
+
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mux.ServeHTTP(w, r)
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+type rpcResponse struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *rpcError   `json:"error,omitempty"`
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rpcResponse{Jsonrpc: "2.0", ID: -1, Result: result})
+}
+
+func writeError(w http.ResponseWriter, status int, data string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(rpcResponse{
+		Jsonrpc: "2.0",
+		ID:      -1,
+		Error: &rpcError{
+			Code:    -32603,
+			Message: "Internal error",
+			Data:    data,
+		},
+	})
+}
+
+// heightParam parses the optional height parameter, where 0 means latest.
+func heightParam(r *http.Request) (int64, error) {
+	raw := strings.Trim(r.URL.Query().Get("height"), "\"")
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+func (n *Node) hash(kind string, height int64) string {
+	return strings.ToUpper(hex.EncodeToString([]byte(fmt.Sprintf("%s/%s/%d", n.chain.chainID, kind, height))))
+}
+
+func (n *Node) status(w http.ResponseWriter, r *http.Request) {
+	n.chain.mtx.RLock()
+	defer n.chain.mtx.RUnlock()
+
+	c := n.chain
+	writeResult(w, map[string]interface{}{
+		"node_info": map[string]interface{}{
+			"protocol_version": map[string]string{"p2p": "8", "block": "11", "app": "0"},
+			"id":               "fakenode",
+			"listen_addr":      "tcp://0.0.0.0:26656",
+			"network":          c.chainID,
+			"version":          c.version,
+			"channels":         "40202122233038606100",
+			"moniker":          "fakenode",
+			"other":            map[string]string{"tx_index": "on", "rpc_address": "tcp://0.0.0.0:26657"},
+		},
+		"sync_info": map[string]interface{}{
+			"latest_block_hash":     n.hash("block", c.latestHeight),
+			"latest_app_hash":       n.hash("app", c.latestHeight),
+			"latest_block_height":   strconv.FormatInt(c.latestHeight, 10),
+			"latest_block_time":     c.blockTime(c.latestHeight).Format(time.RFC3339Nano),
+			"earliest_block_hash":   n.hash("block", c.earliestHeight),
+			"earliest_app_hash":     n.hash("app", c.earliestHeight),
+			"earliest_block_height": strconv.FormatInt(c.earliestHeight, 10),
+			"earliest_block_time":   c.blockTime(c.earliestHeight).Format(time.RFC3339Nano),
+			"catching_up":           c.catchingUp,
+		},
+		"validator_info": map[string]interface{}{
+			"address":      "",
+			"pub_key":      map[string]string{"type": "tendermint/PubKeyEd25519", "value": ""},
+			"voting_power": "0",
+		},
+	})
+}
+
+func (n *Node) block(w http.ResponseWriter, r *http.Request) {
+	height, err := heightParam(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	n.chain.mtx.RLock()
+	defer n.chain.mtx.RUnlock()
+
+	c := n.chain
+	if height == 0 {
+		height = c.latestHeight
+	}
+	if height > c.latestHeight {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("height %d must be less than or equal to the current blockchain height %d", height, c.latestHeight))
+		return
+	}
+	if height < c.earliestHeight {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("height %d is not available, lowest height is %d", height, c.earliestHeight))
+		return
+	}
+
+	writeResult(w, map[string]interface{}{
+		"block_id": map[string]interface{}{
+			"hash":  n.hash("block", height),
+			"parts": map[string]interface{}{"total": 1, "hash": n.hash("parts", height)},
+		},
+		"block": map[string]interface{}{
+			"header": map[string]interface{}{
+				"version":  map[string]string{"block": "11"},
+				"chain_id": c.chainID,
+				"height":   strconv.FormatInt(height, 10),
+				"time":     c.blockTime(height).Format(time.RFC3339Nano),
+				"last_block_id": map[string]interface{}{
+					"hash":  n.hash("block", height-1),
+					"parts": map[string]interface{}{"total": 1, "hash": n.hash("parts", height-1)},
+				},
+				"app_hash":         n.hash("app", height),
+				"proposer_address": "",
+			},
+			"data":     map[string]interface{}{"txs": []string{}},
+			"evidence": map[string]interface{}{"evidence": []string{}},
+		},
+	})
+}
+
+type abciResponse struct {
+	Code      uint32      `json:"code"`
+	Log       string      `json:"log"`
+	Info      string      `json:"info"`
+	Index     string      `json:"index"`
+	Key       []byte      `json:"key"`
+	Value     []byte      `json:"value"`
+	ProofOps  interface{} `json:"proofOps"`
+	Height    string      `json:"height"`
+	Codespace string      `json:"codespace"`
+}
+
+func (n *Node) abciQuery(w http.ResponseWriter, r *http.Request) {
+	height, err := heightParam(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	path := strings.Trim(r.URL.Query().Get("path"), "\"")
+	data, err := hex.DecodeString(strings.TrimPrefix(r.URL.Query().Get("data"), "0x"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	n.chain.mtx.RLock()
+	defer n.chain.mtx.RUnlock()
+
+	c := n.chain
+	if height == 0 {
+		height = c.latestHeight
+	}
+
+	if message, ok := c.failing[path]; ok {
+		writeError(w, http.StatusInternalServerError, message)
+		return
+	}
+
+	var resp = abciResponse{Index: "0", Height: strconv.FormatInt(height, 10)}
+	if height > c.latestHeight || height < c.earliestHeight {
+		resp.Code = codeInvalidHeight
+		resp.Codespace = "sdk"
+		resp.Height = "0"
+		resp.Log = fmt.Sprintf("failed to load state at height %d; version does not exist (latest height: %d): invalid height", height, c.latestHeight)
+		writeResult(w, map[string]interface{}{"response": resp})
+		return
+	}
+
+	msg, code, err := c.query(path, data, height)
+	if err != nil {
+		resp.Code = code
+		resp.Codespace = "sdk"
+		resp.Log = err.Error()
+		writeResult(w, map[string]interface{}{"response": resp})
+		return
+	}
+
+	resp.Value, err = gogoproto.Marshal(msg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResult(w, map[string]interface{}{"response": resp})
+}
+
+// query answers a gRPC query path with the chain state at height. The caller
+// holds the read lock.
+func (c *Chain) query(path string, data []byte, height int64) (gogoproto.Message, uint32, error) {
+	switch path {
+	case "/cosmos.bank.v1beta1.Query/AllBalances":
+		var req banktypes.QueryAllBalancesRequest
+		if err := req.Unmarshal(data); err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		balances := c.balancesAt(req.Address, height)
+		start, end, page := paginate(req.Pagination, len(balances))
+		return &banktypes.QueryAllBalancesResponse{Balances: balances[start:end], Pagination: page}, 0, nil
+
+	case "/cosmos.staking.v1beta1.Query/DelegatorDelegations":
+		var req stakingtypes.QueryDelegatorDelegationsRequest
+		if err := req.Unmarshal(data); err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		delegations := c.delegationsAt(req.DelegatorAddr, height)
+		start, end, page := paginate(req.Pagination, len(delegations))
+		return &stakingtypes.QueryDelegatorDelegationsResponse{DelegationResponses: delegations[start:end], Pagination: page}, 0, nil
+
+	case "/cosmos.staking.v1beta1.Query/DelegatorUnbondingDelegations":
+		var req stakingtypes.QueryDelegatorUnbondingDelegationsRequest
+		if err := req.Unmarshal(data); err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		unbondings := c.unbondingsAt(req.DelegatorAddr, height)
+		start, end, page := paginate(req.Pagination, len(unbondings))
+		return &stakingtypes.QueryDelegatorUnbondingDelegationsResponse{UnbondingResponses: unbondings[start:end], Pagination: page}, 0, nil
+
+	case "/cosmos.distribution.v1beta1.Query/DelegationTotalRewards":
+		var req distributiontypes.QueryDelegationTotalRewardsRequest
+		if err := req.Unmarshal(data); err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		rewards, total := c.rewardsAt(req.DelegatorAddress, height)
+		return &distributiontypes.QueryDelegationTotalRewardsResponse{Rewards: rewards, Total: total}, 0, nil
+
+	case "/cosmos.distribution.v1beta1.Query/ValidatorCommission":
+		var req distributiontypes.QueryValidatorCommissionRequest
+		if err := req.Unmarshal(data); err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		return &distributiontypes.QueryValidatorCommissionResponse{
+			Commission: distributiontypes.ValidatorAccumulatedCommission{Commission: c.commissionAt(req.ValidatorAddress, height)},
+		}, 0, nil
+
+	case "/cosmos.auth.v1beta1.Query/Account":
+		var req authtypes.QueryAccountRequest
+		if err := req.Unmarshal(data); err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		account := c.accountAt(req.Address, height)
+		if account == nil {
+			return nil, codeNotFound, fmt.Errorf("account %s not found: key not found", req.Address)
+		}
+		any, err := codectypes.NewAnyWithValue(account)
+		if err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		return &authtypes.QueryAccountResponse{Account: any}, 0, nil
+
+	case "/cosmos.auth.v1beta1.Query/AccountInfo":
+		var req authtypes.QueryAccountInfoRequest
+		if err := req.Unmarshal(data); err != nil {
+			return nil, codeUnknownRequest, err
+		}
+		account := c.accountAt(req.Address, height)
+		if account == nil {
+			return nil, codeNotFound, fmt.Errorf("account %s not found: key not found", req.Address)
+		}
+		return &authtypes.QueryAccountInfoResponse{Info: baseAccount(account)}, 0, nil
+	}
+
+	return nil, codeUnknownRequest, fmt.Errorf("unknown query path %s: unknown request", path)
+}
+
+// paginate applies offset based pagination the way the SDK does, which is
+// what the collector uses.
+func paginate(req *query.PageRequest, total int) (int, int, *query.PageResponse) {
+	var offset, limit = 0, total
+	if req != nil {
+		offset = int(req.Offset)
+		if req.Limit > 0 {
+			limit = int(req.Limit)
+		}
+	}
+
+	start := min(offset, total)
+	end := min(start+limit, total)
+
+	page := &query.PageResponse{}
+	if req != nil && req.CountTotal {
+		page.Total = uint64(total)
+	}
+	if end < total {
+		page.NextKey = []byte(strconv.Itoa(end))
+	}
+	return start, end, page
+}
+
+// condition is one clause of a tx_search query, e.g. transfer.recipient='addr'
+// or tx.height>=100.
+type condition struct {
+	key   string
+	op    string
+	value string
+}
+
+func parseQuery(raw string) ([]condition, error) {
+	var conditions []condition
+	for _, clause := range strings.Split(raw, " AND ") {
+		clause = strings.TrimSpace(clause)
+		var parsed bool
+		for _, op := range []string{">=", "<=", "=", ">", "<"} {
+			if key, value, ok := strings.Cut(clause, op); ok {
+				conditions = append(conditions, condition{
+					key:   strings.TrimSpace(key),
+					op:    op,
+					value: strings.Trim(strings.TrimSpace(value), "'"),
+				})
+				parsed = true
+				break
+			}
+		}
+		if !parsed {
+			return nil, fmt.Errorf("failed to parse query clause %q", clause)
+		}
+	}
+	return conditions, nil
+}
+
+func (t tx) matches(conditions []condition) bool {
+	for _, c := range conditions {
+		if c.key == "tx.height" {
+			value, err := strconv.ParseInt(c.value, 10, 64)
+			if err != nil {
+				return false
+			}
+			var ok bool
+			switch c.op {
+			case "=":
+				ok = t.height == value
+			case ">=":
+				ok = t.height >= value
+			case "<=":
+				ok = t.height <= value
+			case ">":
+				ok = t.height > value
+			case "<":
+				ok = t.height < value
+			}
+			if !ok {
+				return false
+			}
+			continue
+		}
+
+		eventType, attribute, _ := strings.Cut(c.key, ".")
+		var found bool
+		for _, e := range t.events {
+			for _, a := range e.Attributes {
+				if e.Type == eventType && a.Key == attribute && a.Value == c.value {
+					found = true
+				}
+			}
+		}
+		if c.op != "=" || !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *Node) txSearch(w http.ResponseWriter, r *http.Request) {
+	conditions, err := parseQuery(strings.Trim(r.URL.Query().Get("query"), "\""))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var page, perPage = 1, 30
+	if raw := r.URL.Query().Get("page"); raw != "" {
+		if page, err = strconv.Atoi(strings.Trim(raw, "\"")); err != nil || page < 1 {
+			writeError(w, http.StatusInternalServerError, "invalid page")
+			return
+		}
+	}
+	if raw := r.URL.Query().Get("per_page"); raw != "" {
+		if perPage, err = strconv.Atoi(strings.Trim(raw, "\"")); err != nil || perPage < 1 {
+			writeError(w, http.StatusInternalServerError, "invalid per_page")
+			return
+		}
+		perPage = min(perPage, 100)
+	}
+
+	n.chain.mtx.RLock()
+	defer n.chain.mtx.RUnlock()
+
+	var matched []tx
+	for _, t := range n.chain.txs {
+		if t.height <= n.chain.latestHeight && t.matches(conditions) {
+			matched = append(matched, t)
+		}
+	}
+	if strings.Trim(r.URL.Query().Get("order_by"), "\"") == "desc" {
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	}
+
+	start := min((page-1)*perPage, len(matched))
+	end := min(start+perPage, len(matched))
+
+	var txs = make([]interface{}, 0, end-start)
+	for _, t := range matched[start:end] {
+		var events = make([]interface{}, 0, len(t.events))
+		for _, e := range t.events {
+			var attributes = make([]interface{}, 0, len(e.Attributes))
+			for _, a := range e.Attributes {
+				attributes = append(attributes, map[string]interface{}{"key": a.Key, "value": a.Value, "index": true})
+			}
+			events = append(events, map[string]interface{}{"type": e.Type, "attributes": attributes})
+		}
+
+		txs = append(txs, map[string]interface{}{
+			"hash":   t.hash,
+			"height": strconv.FormatInt(t.height, 10),
+			"index":  t.index,
+			"tx_result": map[string]interface{}{
+				"code":       t.code,
+				"data":       "",
+				"log":        "",
+				"info":       "",
+				"gas_wanted": "200000",
+				"gas_used":   "100000",
+				"events":     events,
+				"codespace":  "",
+			},
+			"tx": "",
+		})
+	}
+
+	writeResult(w, map[string]interface{}{
+		"txs":         txs,
+		"total_count": strconv.Itoa(len(matched)),
+	})
+}
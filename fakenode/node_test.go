@@ -0,0 +1,204 @@
+package fakenode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var genesis = time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
+func TestChainBlockTimes(t *testing.T) {
+	c := NewChain("testchain-1", genesis, 6*time.Second)
+	c.SetBlockTime(100, 2*time.Second)
+	c.Halt(50, time.Hour)
+
+	for _, tc := range []struct {
+		height   int64
+		expected time.Time
+	}{
+		{1, genesis},
+		{50, genesis.Add(49 * 6 * time.Second)},
+		{51, genesis.Add(50*6*time.Second + time.Hour)},
+		{101, genesis.Add(99*6*time.Second + 2*time.Second + time.Hour)},
+	} {
+		if got := c.BlockTime(tc.height); !got.Equal(tc.expected) {
+			t.Errorf("height %d: expected %s, got %s", tc.height, tc.expected, got)
+		}
+	}
+
+	// HeightAt is the last block at or before a time, also inside the halt.
+	for _, height := range []int64{1, 50, 51, 101} {
+		if got := c.HeightAt(c.BlockTime(height)); got != height {
+			t.Errorf("expected height %d at its block time, got %d", height, got)
+		}
+	}
+	if got := c.HeightAt(c.BlockTime(50).Add(30 * time.Minute)); got != 50 {
+		t.Errorf("expected height 50 during the halt, got %d", got)
+	}
+	if got := c.HeightAt(genesis.Add(-time.Second)); got != 0 {
+		t.Errorf("expected no height before genesis, got %d", got)
+	}
+}
+
+// get calls the node and decodes the result of its JSON-RPC answer into v.
+func get(t *testing.T, server *httptest.Server, path string, params url.Values, v interface{}) (int, *rpcError) {
+	t.Helper()
+
+	res, err := http.Get(server.URL + path + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var answer struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&answer); err != nil {
+		t.Fatal(err)
+	}
+	if answer.Error == nil && v != nil {
+		if err := json.Unmarshal(answer.Result, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode, answer.Error
+}
+
+func TestNodeBlock(t *testing.T) {
+	c := NewChain("testchain-1", genesis, 6*time.Second)
+	c.SetLatestHeight(1000)
+	c.Prune(100)
+	server := httptest.NewServer(New(c))
+	t.Cleanup(server.Close)
+
+	var block struct {
+		Block struct {
+			Header struct {
+				Height string    `json:"height"`
+				Time   time.Time `json:"time"`
+			} `json:"header"`
+		} `json:"block"`
+	}
+	if status, rpcErr := get(t, server, "/block", url.Values{"height": {"500"}}, &block); status != http.StatusOK || rpcErr != nil {
+		t.Fatalf("expected block 500, got %d %+v", status, rpcErr)
+	}
+	if block.Block.Header.Height != "500" || !block.Block.Header.Time.Equal(c.BlockTime(500)) {
+		t.Errorf("unexpected header %+v", block.Block.Header)
+	}
+
+	for _, height := range []string{"50", "1001"} {
+		if status, rpcErr := get(t, server, "/block", url.Values{"height": {height}}, nil); status != http.StatusInternalServerError || rpcErr == nil {
+			t.Errorf("height %s: expected an error, got %d", height, status)
+		}
+	}
+}
+
+func TestNodeABCIQuery(t *testing.T) {
+	const address = "cosmos1fakenode"
+
+	c := NewChain("testchain-1", genesis, 6*time.Second)
+	c.SetLatestHeight(1000)
+	c.Prune(100)
+	c.SetBalance(address, 1, sdk.NewCoins(sdk.NewInt64Coin("utest", 10)))
+	c.SetBalance(address, 500, sdk.NewCoins(sdk.NewInt64Coin("utest", 20)))
+	server := httptest.NewServer(New(c))
+	t.Cleanup(server.Close)
+
+	data, err := (&banktypes.QueryAllBalancesRequest{Address: address}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(height string) (abciResponse, *rpcError) {
+		var result struct {
+			Response abciResponse `json:"response"`
+		}
+		_, rpcErr := get(t, server, "/abci_query", url.Values{
+			"path":   {`"/cosmos.bank.v1beta1.Query/AllBalances"`},
+			"data":   {"0x" + hex.EncodeToString(data)},
+			"height": {height},
+		}, &result)
+		return result.Response, rpcErr
+	}
+
+	for height, expected := range map[string]string{"499": "10utest", "500": "20utest", "0": "20utest"} {
+		resp, rpcErr := query(height)
+		if rpcErr != nil || resp.Code != 0 {
+			t.Fatalf("height %s: unexpected answer %+v %+v", height, resp, rpcErr)
+		}
+		var balances banktypes.QueryAllBalancesResponse
+		if err := balances.Unmarshal(resp.Value); err != nil {
+			t.Fatal(err)
+		}
+		if got := balances.Balances.String(); got != expected {
+			t.Errorf("height %s: expected %s, got %s", height, expected, got)
+		}
+	}
+
+	if resp, _ := query("50"); resp.Code != codeInvalidHeight {
+		t.Errorf("expected code %d for a pruned height, got %+v", codeInvalidHeight, resp)
+	}
+
+	c.FailQuery("/cosmos.bank.v1beta1.Query/AllBalances", "unavailable")
+	if _, rpcErr := query("500"); rpcErr == nil || rpcErr.Data != "unavailable" {
+		t.Errorf("expected the query to fail, got %+v", rpcErr)
+	}
+	c.FailQuery("/cosmos.bank.v1beta1.Query/AllBalances", "")
+	if _, rpcErr := query("500"); rpcErr != nil {
+		t.Errorf("expected the query to succeed again, got %+v", rpcErr)
+	}
+}
+
+func TestNodeTxSearch(t *testing.T) {
+	c := NewChain("testchain-1", genesis, 6*time.Second)
+	c.SetLatestHeight(100)
+	transfer := func(recipient string) Event {
+		return Event{Type: "transfer", Attributes: []Attribute{{Key: "recipient", Value: recipient}}}
+	}
+	first := c.AddTx(10, 0, transfer("cosmos1a"))
+	c.AddTx(20, 0, transfer("cosmos1b"))
+	third := c.AddTx(30, 0, transfer("cosmos1a"))
+	// Not reached by the chain yet.
+	c.AddTx(200, 0, transfer("cosmos1a"))
+	server := httptest.NewServer(New(c))
+	t.Cleanup(server.Close)
+
+	search := func(params url.Values) ([]string, string) {
+		var result struct {
+			Txs []struct {
+				Hash string `json:"hash"`
+			} `json:"txs"`
+			TotalCount string `json:"total_count"`
+		}
+		if _, rpcErr := get(t, server, "/tx_search", params, &result); rpcErr != nil {
+			t.Fatal(rpcErr)
+		}
+		var hashes []string
+		for _, tx := range result.Txs {
+			hashes = append(hashes, tx.Hash)
+		}
+		return hashes, result.TotalCount
+	}
+
+	hashes, total := search(url.Values{"query": {`"transfer.recipient='cosmos1a'"`}, "order_by": {`"desc"`}})
+	if total != "2" || len(hashes) != 2 || hashes[0] != third || hashes[1] != first {
+		t.Errorf("unexpected search result %v of %s", hashes, total)
+	}
+
+	hashes, total = search(url.Values{"query": {`"transfer.recipient='cosmos1a' AND tx.height>15"`}})
+	if total != "1" || len(hashes) != 1 || hashes[0] != third {
+		t.Errorf("unexpected search result %v of %s", hashes, total)
+	}
+
+	hashes, total = search(url.Values{"query": {`"tx.height>0"`}, "per_page": {"2"}, "page": {"2"}})
+	if total != "3" || len(hashes) != 1 {
+		t.Errorf("expected the last of 3 transactions on page 2, got %v of %s", hashes, total)
+	}
+}
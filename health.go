@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DEFAULT_HEALTH_INTERVAL = 30 * time.Second
+
+var DEFAULT_STALE_AFTER = 5 * time.Minute
+
+// HEALTH_CHECK_TIMEOUT bounds the check of one endpoint, retries included.
+var HEALTH_CHECK_TIMEOUT = 10 * time.Second
+
+// healthChecker backs /readyz. It is nil until serve starts it.
+var healthChecker *HealthChecker
+
+type EndpointHealth struct {
+	ChainEndpoint
+	Healthy         bool      `json:"healthy"`
+	LatestHeight    int64     `json:"latestHeight,omitempty"`
+	LatestBlockTime time.Time `json:"latestBlockTime"`
+	CatchingUp      bool      `json:"catchingUp"`
+	Error           string    `json:"error,omitempty"`
+	CheckedAt       time.Time `json:"checkedAt"`
+}
+
+type ChainHealth struct {
+	Required bool `json:"required"`
+	// Healthy is true when at least one endpoint is.
+	Healthy   bool             `json:"healthy"`
+	Endpoints []EndpointHealth `json:"endpoints"`
+}
+
+// HealthChecker checks the endpoints of every chain periodically. An
+// endpoint is healthy when it answers, is not catching up, and its latest
+// block is more recent than staleAfter.
+type HealthChecker struct {
+	interval   time.Duration
+	staleAfter time.Duration
+	now        func() time.Time
+
+	mtx    sync.Mutex
+	chains map[string]ChainHealth
+}
+
+func NewHealthChecker(config HealthConfig) *HealthChecker {
+	h := &HealthChecker{
+		interval:   config.Interval,
+		staleAfter: config.StaleAfter,
+		now:        time.Now,
+	}
+	if h.interval == 0 {
+		h.interval = DEFAULT_HEALTH_INTERVAL
+	}
+	if h.staleAfter == 0 {
+		h.staleAfter = DEFAULT_STALE_AFTER
+	}
+	return h
+}
+
+// Run checks every chain right away, then every interval until ctx is done.
+func (h *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *HealthChecker) check(ctx context.Context) {
+	var (
+		wg     sync.WaitGroup
+		mtx    sync.Mutex
+		chains = make(map[string]ChainHealth)
+	)
+	for name, chain := range cfg.Chains {
+		wg.Add(1)
+		go func(name string, chain ChainConfig) {
+			defer wg.Done()
+
+			health := ChainHealth{Required: !chain.Optional}
+			health.Endpoints = append(health.Endpoints, h.checkEndpoint(ctx, name, ChainEndpoint{"rpc", redactURL(chain.RPCUrl)}))
+			if chain.Archive != nil {
+				health.Endpoints = append(health.Endpoints, h.checkEndpoint(withArchive(ctx), name, ChainEndpoint{"archive", redactURL(chain.Archive.RPCUrl)}))
+			}
+			for _, endpoint := range health.Endpoints {
+				health.Healthy = health.Healthy || endpoint.Healthy
+			}
+
+			mtx.Lock()
+			chains[name] = health
+			mtx.Unlock()
+		}(name, chain)
+	}
+	wg.Wait()
+
+	h.mtx.Lock()
+	h.chains = chains
+	h.mtx.Unlock()
+}
+
+// checkEndpoint checks the endpoint of chain that ctx routes queries to.
+func (h *HealthChecker) checkEndpoint(ctx context.Context, chain string, endpoint ChainEndpoint) EndpointHealth {
+	ctx, cancel := context.WithTimeout(ctx, HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	result := EndpointHealth{ChainEndpoint: endpoint, CheckedAt: h.now().UTC()}
+	err := func() error {
+		status, err := getNodeStatus(ctx, chain)
+		if err != nil {
+			return err
+		}
+
+		result.CatchingUp = status.SyncInfo.CatchingUp
+		if result.LatestHeight, err = strconv.ParseInt(status.SyncInfo.LatestBlockHeight, 0, 64); err != nil {
+			return errors.Wrap(err, "failed to parse latest_block_height")
+		}
+		if result.LatestBlockTime, err = time.Parse(time.RFC3339Nano, status.SyncInfo.LatestBlockTime); err != nil {
+			return errors.Wrap(err, "failed to parse latest_block_time")
+		}
+
+		if result.CatchingUp {
+			return errors.New("node is catching up")
+		}
+		if age := h.now().Sub(result.LatestBlockTime); age > h.staleAfter {
+			return errors.Errorf("latest block is %s old", age.Truncate(time.Second))
+		}
+		return nil
+	}()
+
+	if err != nil {
+		log.WithError(err).Debugf("%s endpoint of %s is unhealthy", endpoint.Kind, chain)
+		result.Error = err.Error()
+	}
+	result.Healthy = err == nil
+	endpointHealthy.WithLabelValues(chain, endpoint.Kind).Set(boolFloat(result.Healthy))
+	return result
+}
+
+// Ready reports whether every required chain has a healthy endpoint, with the
+// health of every chain.
+func (h *HealthChecker) Ready() (bool, map[string]ChainHealth) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.chains == nil {
+		return false, nil
+	}
+
+	var ready = true
+	for _, health := range h.chains {
+		if health.Required && !health.Healthy {
+			ready = false
+		}
+	}
+	return ready, h.chains
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// getHealthz answers as long as the process serves requests.
+func getHealthz(c *gin.Context) {
+	respond(c, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// getReadyz answers whether the collector can serve its chains, with the
+// health of each.
+func getReadyz(c *gin.Context) {
+	if healthChecker == nil {
+		respondError(c, c.Request.Context(), newAPIError(http.StatusServiceUnavailable, ERROR_NOT_READY, "health checks are not running"))
+		return
+	}
+
+	ready, chains := healthChecker.Ready()
+	content := struct {
+		Ready  bool                   `json:"ready"`
+		Chains map[string]ChainHealth `json:"chains"`
+	}{ready, chains}
+
+	if !ready {
+		var unhealthy []string
+		for name, health := range chains {
+			if health.Required && !health.Healthy {
+				unhealthy = append(unhealthy, name)
+			}
+		}
+		sort.Strings(unhealthy)
+
+		message := "no health check has completed yet"
+		if chains != nil {
+			message = "required chains are unhealthy: " + strings.Join(unhealthy, ", ")
+		}
+		c.IndentedJSON(http.StatusServiceUnavailable, Message{
+			Error:     message,
+			Code:      ERROR_NOT_READY,
+			IsSuccess: false,
+			Content:   content,
+		})
+		return
+	}
+
+	respond(c, http.StatusOK, content)
+}
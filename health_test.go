@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	// An optional chain whose only endpoint is down.
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(down.Close)
+	client, err := NewHTTPClient(down.URL, DEFAULT_TIMEOUT, ConnectionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Chains["downchain"] = ChainConfig{RPCUrl: down.URL, Client: client, Optional: true}
+	t.Cleanup(func() { delete(cfg.Chains, "downchain") })
+
+	h := NewHealthChecker(HealthConfig{StaleAfter: time.Minute})
+	healthChecker = h
+	t.Cleanup(func() { healthChecker = nil })
+
+	if status, message := doRequest(t, "/readyz"); status != http.StatusServiceUnavailable || message.Code != ERROR_NOT_READY {
+		t.Errorf("expected not_ready before the first check, got %d %s", status, message.Code)
+	}
+
+	h.now = func() time.Time { return e2eNow.Add(30 * time.Second) }
+	h.check(context.Background())
+
+	status, message := doRequest(t, "/readyz")
+	if status != http.StatusOK || !message.IsSuccess {
+		t.Fatalf("expected ready with a healthy required chain, got %d: %s", status, message.Error)
+	}
+	_, chains := h.Ready()
+	if !chains["testchain"].Healthy || chains["downchain"].Healthy || chains["downchain"].Required {
+		t.Errorf("unexpected health %+v", chains)
+	}
+
+	// The latest block of the node is now too old.
+	h.now = func() time.Time { return e2eNow.Add(2 * time.Minute) }
+	h.check(context.Background())
+
+	status, message = doRequest(t, "/readyz")
+	if status != http.StatusServiceUnavailable || message.Code != ERROR_NOT_READY {
+		t.Errorf("expected not_ready with a stale required chain, got %d %s", status, message.Code)
+	}
+
+	if status, _ := doRequest(t, "/healthz"); status != http.StatusOK {
+		t.Errorf("expected /healthz to answer 200, got %d", status)
+	}
+}
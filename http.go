@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"github.com/pkg/errors"
 	log "github.com/xlab/suplog"
+	"golang.org/x/time/rate"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"reflect"
 	"runtime"
 	"strconv"
@@ -18,47 +23,162 @@ const (
 	ABCI_QUERY_PATH = "/abci_query"
 	BLOCK_PATH      = "/block"
 	STATUS_PATH     = "/status"
+	TX_SEARCH_PATH  = "/tx_search"
 )
 
 type HTTPClient struct {
 	*http.Client
-	url     string
-	timeout time.Duration
+	// chain labels the metrics of the requests.
+	chain       string
+	endpoint    *url.URL
+	timeout     time.Duration
+	headers     http.Header
+	queryParams url.Values
+	basicAuth   *url.Userinfo
+	limiter     *rate.Limiter
+	inFlight    chan struct{}
 }
 
-func NewHTTPClient(url string, timeout int) (*HTTPClient, error) {
-	return &HTTPClient{
-		Client:  &http.Client{},
-		url:     url,
-		timeout: time.Duration(timeout) * time.Second,
-	}, nil
+func NewHTTPClient(rawURL string, timeout int, conn ConnectionConfig) (*HTTPClient, error) {
+	endpoint, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse rpcURL")
+	}
+
+	transport, err := newTransport(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &HTTPClient{
+		Client:      &http.Client{Transport: transport},
+		endpoint:    endpoint,
+		timeout:     time.Duration(timeout) * time.Second,
+		headers:     http.Header{},
+		queryParams: url.Values{},
+	}
+
+	for k, v := range conn.Headers {
+		value, err := v.Resolve()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve header %s", k)
+		}
+		c.headers.Set(k, value)
+	}
+
+	for k, v := range conn.QueryParams {
+		value, err := v.Resolve()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve query parameter %s", k)
+		}
+		c.queryParams.Set(k, value)
+	}
+
+	if conn.BasicAuth != nil {
+		password, err := conn.BasicAuth.Password.Resolve()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve basic auth password")
+		}
+		c.basicAuth = url.UserPassword(conn.BasicAuth.Username, password)
+	}
+
+	if conn.RateLimit != nil {
+		if conn.RateLimit.RequestsPerSecond > 0 {
+			var burst = conn.RateLimit.Burst
+			if burst <= 0 {
+				burst = 1
+			}
+			c.limiter = rate.NewLimiter(rate.Limit(conn.RateLimit.RequestsPerSecond), burst)
+		}
+		if conn.RateLimit.MaxInFlight > 0 {
+			c.inFlight = make(chan struct{}, conn.RateLimit.MaxInFlight)
+		}
+	}
+
+	return c, nil
 }
 
-func (c *HTTPClient) Query(path string, parameters map[string]string) ([]byte, error) {
+func newTransport(conn ConnectionConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
 
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
-	defer cancel()
+	if !conn.Proxy.IsZero() {
+		rawProxy, err := conn.Proxy.Resolve()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve proxy")
+		}
+		proxy, err := url.Parse(rawProxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse proxy")
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
 
-	var params string
-	if strings.Contains(c.url, "?") {
-		params = "&"
-	} else {
-		params = "?"
+	if conn.TLS != nil {
+		tlsConfig, err := newTLSConfig(conn.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
 	}
 
-	for k, v := range parameters {
-		params += "&"
-		params += fmt.Sprintf("%s=%s", k, v)
+	return transport, nil
+}
+
+func newTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		b, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read caFile")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
-	//log.Debugf(c.url + path + params)
-	req, err := requestGet(ctx, c.url+path+params)
+	return tlsConfig, nil
+}
+
+func (c *HTTPClient) Query(ctx context.Context, path string, parameters map[string]string) ([]byte, error) {
+
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "request aborted while queued")
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := requestGet(ctx, c.queryURL(path, parameters))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to request")
 	}
 
+	for k, v := range c.headers {
+		req.Header[k] = v
+	}
+	if c.basicAuth != nil {
+		password, _ := c.basicAuth.Password()
+		req.SetBasicAuth(c.basicAuth.Username(), password)
+	}
+
 	var body []byte
-	body, err = request(c.Client, req, 5)
+	body, err = request(c.Client, req, 5, c.limiter, rpcLabels{c.chain, c.endpoint.Host, path})
 	if err != nil {
 		return nil, err
 	}
@@ -66,32 +186,140 @@ func (c *HTTPClient) Query(path string, parameters map[string]string) ([]byte, e
 	return body, nil
 }
 
+// acquire waits for an in-flight slot and a rate limit token. Waiting is
+// bounded by the caller's deadline rather than the per-request timeout, so
+// queued requests give up only when nobody is waiting for them anymore.
+func (c *HTTPClient) acquire(ctx context.Context) (func(), error) {
+	var release = func() {}
+
+	if c.inFlight != nil {
+		select {
+		case c.inFlight <- struct{}{}:
+			release = func() { <-c.inFlight }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+// queryURL appends path to the endpoint's own path and merges the endpoint's
+// query string, the configured query parameters and parameters.
+func (c *HTTPClient) queryURL(path string, parameters map[string]string) string {
+	u := *c.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	u.RawPath = ""
+
+	q := u.Query()
+	for k, v := range c.queryParams {
+		q[k] = v
+	}
+	for k, v := range parameters {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
 func requestGet(ctx context.Context, url string) (*http.Request, error) {
 	return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 }
 
-func request(c *http.Client, request *http.Request, retries int) ([]byte, error) {
+// request sends request, retrying on failure. Retries beyond the first attempt
+// take their own token from limiter when one is given. Every attempt is
+// measured under labels.
+func request(c *http.Client, request *http.Request, retries int, limiter *rate.Limiter, labels rpcLabels) ([]byte, error) {
 	var errMsg string
+	ctx := request.Context()
 	for i := 0; i < retries; i++ {
+		if i > 0 {
+			rpcRetries.WithLabelValues(labels.values()...).Inc()
+		}
+		if i > 0 && limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, errors.Wrap(err, "request aborted")
+			}
+		}
+
+		started := time.Now()
 		res, err := c.Do(request)
 		if err != nil {
+			rpcErrors.WithLabelValues(labels.values()...).Inc()
+			if ctx.Err() != nil {
+				return nil, errors.Wrap(ctx.Err(), "request aborted")
+			}
 			errMsg = errors.New("err: " + err.Error() + ", " + runtime.FuncForPC(reflect.ValueOf(request).Pointer()).Name() + ".Retries " + strconv.Itoa(i) + "...").Error()
 			log.Warning(errMsg)
-			time.Sleep(1 * time.Second)
+			if err := sleepContext(ctx, 1*time.Second); err != nil {
+				return nil, errors.Wrap(err, "request aborted")
+			}
 			continue
 		}
 
 		body, err := io.ReadAll(res.Body)
+		rpcRequestDuration.WithLabelValues(labels.values()...).Observe(time.Since(started).Seconds())
+		res.Body.Close()
+		if err != nil || res.StatusCode >= http.StatusBadRequest {
+			rpcErrors.WithLabelValues(labels.values()...).Inc()
+		}
 		if err != nil {
 			errMsg = errors.New("err: " + err.Error() + ", " + runtime.FuncForPC(reflect.ValueOf(request).Pointer()).Name() + ".Retries " + strconv.Itoa(i) + "...").Error()
 			log.Warning(errMsg)
-			time.Sleep(1 * time.Second)
+			if err := sleepContext(ctx, 1*time.Second); err != nil {
+				return nil, errors.Wrap(err, "request aborted")
+			}
 			continue
 		}
-		defer res.Body.Close()
+		if res.StatusCode >= http.StatusBadRequest {
+			return nil, answerError(res, body)
+		}
 
 		return body, nil
 	}
 
-	return nil, errors.New(errMsg)
+	return nil, &UpstreamError{errors.New(errMsg)}
+}
+
+// UpstreamError is an RPC endpoint that could not be reached or did not
+// answer JSON-RPC.
+type UpstreamError struct {
+	err error
+}
+
+func (e *UpstreamError) Error() string {
+	return e.err.Error()
+}
+
+// answerError is the JSON-RPC error carried by res, an answer with an HTTP
+// error status, or an UpstreamError when it carries none.
+func answerError(res *http.Response, body []byte) error {
+	var r struct {
+		Error *RPCError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &r); err == nil && r.Error != nil {
+		return r.Error
+	}
+	return &UpstreamError{errors.Errorf("%s answered %s", res.Request.URL.Host, res.Status)}
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }
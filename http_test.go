@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueryURL(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint    string
+		queryParams map[string]Secret
+		path        string
+		parameters  map[string]string
+		expected    string
+	}{
+		{
+			endpoint: "http://node:26657",
+			path:     STATUS_PATH,
+			expected: "http://node:26657/status",
+		},
+		{
+			// The endpoint's path is a prefix, with or without a trailing slash.
+			endpoint: "https://gateway.example.com/cosmoshub/rpc/",
+			path:     BLOCK_PATH,
+			parameters: map[string]string{
+				"height": "100",
+			},
+			expected: "https://gateway.example.com/cosmoshub/rpc/block?height=100",
+		},
+		{
+			endpoint: "https://gateway.example.com/cosmoshub/rpc",
+			path:     BLOCK_PATH,
+			expected: "https://gateway.example.com/cosmoshub/rpc/block",
+		},
+		{
+			// The endpoint's query string and the configured parameters are
+			// kept, and parameters are escaped.
+			endpoint:    "https://gateway.example.com/rpc?network=mainnet",
+			queryParams: map[string]Secret{"apikey": {Value: "k/y+1"}},
+			path:        ABCI_QUERY_PATH,
+			parameters: map[string]string{
+				"path": `"/cosmos.bank.v1beta1.Query/AllBalances"`,
+				"data": "0x0a2d",
+			},
+			expected: "https://gateway.example.com/rpc/abci_query?apikey=k%2Fy%2B1&data=0x0a2d&network=mainnet&path=%22%2Fcosmos.bank.v1beta1.Query%2FAllBalances%22",
+		},
+		{
+			endpoint: "http://node:26657/",
+			path:     TX_SEARCH_PATH,
+			parameters: map[string]string{
+				"query": "message.sender='cosmos1' AND tx.height>=5",
+			},
+			expected: "http://node:26657/tx_search?query=message.sender%3D%27cosmos1%27+AND+tx.height%3E%3D5",
+		},
+	} {
+		client, err := NewHTTPClient(tc.endpoint, DEFAULT_TIMEOUT, ConnectionConfig{QueryParams: tc.queryParams})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := client.queryURL(tc.path, tc.parameters); got != tc.expected {
+			t.Errorf("%s%s: expected %s, got %s", tc.endpoint, tc.path, tc.expected, got)
+		}
+	}
+}
+
+func TestHTTPClientCredentials(t *testing.T) {
+	t.Setenv("TEST_RPC_API_KEY", "secret-key")
+
+	for _, tc := range []struct {
+		name     string
+		conn     ConnectionConfig
+		expected func(r *http.Request) string
+	}{
+		{
+			name: "header",
+			conn: ConnectionConfig{Headers: map[string]Secret{"x-api-key": {Env: "TEST_RPC_API_KEY"}}},
+			expected: func(r *http.Request) string {
+				if got := r.Header.Get("X-Api-Key"); got != "secret-key" {
+					return "expected the x-api-key header, got " + got
+				}
+				return ""
+			},
+		},
+		{
+			name: "basic auth",
+			conn: ConnectionConfig{BasicAuth: &BasicAuthConfig{Username: "collector", Password: Secret{Value: "p@ss:word"}}},
+			expected: func(r *http.Request) string {
+				if username, password, ok := r.BasicAuth(); !ok || username != "collector" || password != "p@ss:word" {
+					return "expected basic auth, got " + r.Header.Get("Authorization")
+				}
+				return ""
+			},
+		},
+		{
+			name: "query parameter",
+			conn: ConnectionConfig{QueryParams: map[string]Secret{"apikey": {Env: "TEST_RPC_API_KEY"}}},
+			expected: func(r *http.Request) string {
+				if got := r.URL.Query().Get("apikey"); got != "secret-key" {
+					return "expected the apikey parameter, got " + got
+				}
+				return ""
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			problems := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				problems <- tc.expected(r)
+				w.Write([]byte(`{"result":{}}`))
+			}))
+			defer server.Close()
+
+			client, err := NewHTTPClient(server.URL, DEFAULT_TIMEOUT, tc.conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Query(context.Background(), STATUS_PATH, nil); err != nil {
+				t.Fatal(err)
+			}
+			if problem := <-problems; problem != "" {
+				t.Error(problem)
+			}
+		})
+	}
+
+	// A secret that can't be resolved fails the client rather than sending
+	// requests without credentials.
+	_, err := NewHTTPClient("http://node:26657", DEFAULT_TIMEOUT, ConnectionConfig{Headers: map[string]Secret{"x-api-key": {Env: "TEST_RPC_UNSET"}}})
+	if err == nil {
+		t.Error("expected an unset header secret to fail")
+	}
+}
+
+func TestHTTPClientRateLimit(t *testing.T) {
+	client, err := NewHTTPClient("http://node:26657", DEFAULT_TIMEOUT, ConnectionConfig{RateLimit: &RateLimitConfig{RequestsPerSecond: 20, Burst: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The burst is spent at once, every other request waits 50ms.
+	started := time.Now()
+	for i := 0; i < 4; i++ {
+		release, err := client.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if elapsed := time.Since(started); elapsed < 140*time.Millisecond {
+		t.Errorf("expected 4 requests at 20/s to take at least 150ms, took %s", elapsed)
+	}
+}
+
+func TestHTTPClientMaxInFlight(t *testing.T) {
+	var (
+		mtx               sync.Mutex
+		inFlight, maximum int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		inFlight++
+		maximum = max(maximum, inFlight)
+		mtx.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mtx.Lock()
+		inFlight--
+		mtx.Unlock()
+		w.Write([]byte(`{"result":{}}`))
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(server.URL, DEFAULT_TIMEOUT, ConnectionConfig{RateLimit: &RateLimitConfig{MaxInFlight: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Query(context.Background(), STATUS_PATH, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maximum != 2 {
+		t.Errorf("expected at most and at best 2 requests in flight, got %d", maximum)
+	}
+}
+
+func TestHTTPClientAcquireCanceled(t *testing.T) {
+	client, err := NewHTTPClient("http://node:26657", DEFAULT_TIMEOUT, ConnectionConfig{RateLimit: &RateLimitConfig{RequestsPerSecond: 0.001, Burst: 1, MaxInFlight: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := client.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting for the slot ends with the caller.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.acquire(ctx); err == nil {
+		t.Fatal("expected waiting for a slot to end with the context")
+	}
+	release()
+
+	// The slot is taken, but the token isn't coming before the deadline:
+	// the slot is given back.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.acquire(ctx); err == nil {
+		t.Fatal("expected waiting for a token to end with the context")
+	}
+	if len(client.inFlight) != 0 {
+		t.Errorf("expected the slot to be released, %d in flight", len(client.inFlight))
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"cosmossdk.io/math"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// IncomeEntry is the staking income from one validator in one denom on one
+// day. Depending on the jurisdiction, income is recognized as it accrues or
+// when it is claimed, so both are reported.
+type IncomeEntry struct {
+	Date      string `json:"date"`
+	Validator string `json:"validator"`
+	Denom     string `json:"denom"`
+	// Accrued is the growth of the outstanding rewards plus what was claimed.
+	Accrued math.LegacyDec `json:"accrued"`
+	// Claimed is what was withdrawn, explicitly or by a delegation change.
+	Claimed math.Int `json:"claimed"`
+	// AutoClaimed is the part of Claimed withdrawn by a delegation change.
+	AutoClaimed math.Int `json:"autoClaimed"`
+}
+
+type IncomeTotal struct {
+	Accrued math.LegacyDec `json:"accrued"`
+	Claimed math.Int       `json:"claimed"`
+}
+
+type IncomeReport struct {
+	Address string                 `json:"address"`
+	Entries []IncomeEntry          `json:"entries"`
+	Total   map[string]IncomeTotal `json:"total"`
+}
+
+// collectIncome reports the staking income of address on every day from
+// startedAt to endedAt, each day running from the start of the day to the
+// start of the next.
+func collectIncome(ctx context.Context, chain, address string, startedAt, endedAt time.Time) (IncomeReport, error) {
+	heights, err := resolveDailyHeights(ctx, chain, startedAt, endedAt)
+	if err != nil {
+		return IncomeReport{}, err
+	}
+	days := sortedDays(heights)
+	if len(days) == 0 {
+		return IncomeReport{}, errors.New("endedAt must not be before startedAt")
+	}
+
+	// The last day closes at the start of the next one, or now.
+	latestHeight, latestBlockTime, _, err := latestBlock(ctx, chain)
+	if err != nil {
+		return IncomeReport{}, err
+	}
+	var closingHeight = latestHeight
+	if closing := days[len(days)-1].AddDate(0, 0, 1); closing.Before(latestBlockTime) {
+		closingHeight, err = resolveHeight(ctx, chain, closing)
+		if err != nil {
+			return IncomeReport{}, err
+		}
+	}
+
+	var points = make([]int64, 0, len(days)+1)
+	for _, day := range days {
+		points = append(points, heights[day])
+	}
+	points = append(points, closingHeight)
+
+	var rewards = make([]map[string]types.DecCoins, len(points))
+	for i, height := range points {
+		response, err := queryDistributionDelegationTotalRewards(ctx, chain, address, height)
+		if err != nil {
+			return IncomeReport{}, errors.Wrapf(err, "failed to query rewards at height %d", height)
+		}
+		rewards[i] = make(map[string]types.DecCoins)
+		for _, reward := range response.Rewards {
+			rewards[i][reward.ValidatorAddress] = reward.Reward
+		}
+	}
+
+	activities, err := queryActivity(ctx, chain, address, points[0]+1, points[len(points)-1])
+	if err != nil {
+		return IncomeReport{}, errors.Wrap(err, "failed to query withdrawals")
+	}
+
+	// Withdrawals by day, then validator.
+	var (
+		claimed     = make([]map[string]types.Coins, len(days))
+		autoClaimed = make([]map[string]types.Coins, len(days))
+	)
+	for i := range days {
+		claimed[i] = make(map[string]types.Coins)
+		autoClaimed[i] = make(map[string]types.Coins)
+	}
+	for _, activity := range activities {
+		if activity.Type != ACTIVITY_REWARD_WITHDRAWAL {
+			continue
+		}
+		i := sort.Search(len(days), func(i int) bool { return activity.Height <= points[i+1] })
+		if i == len(days) {
+			continue
+		}
+		claimed[i][activity.Counterparty] = claimed[i][activity.Counterparty].Add(activity.Amount...)
+		if activity.AutoClaimed {
+			autoClaimed[i][activity.Counterparty] = autoClaimed[i][activity.Counterparty].Add(activity.Amount...)
+		}
+	}
+
+	var report = IncomeReport{Address: address, Total: make(map[string]IncomeTotal)}
+	for i, day := range days {
+		var validators = make(map[string]bool)
+		for _, m := range []map[string]types.DecCoins{rewards[i], rewards[i+1]} {
+			for validator := range m {
+				validators[validator] = true
+			}
+		}
+		for validator := range claimed[i] {
+			validators[validator] = true
+		}
+
+		for validator := range validators {
+			var denoms = make(map[string]bool)
+			for _, coin := range rewards[i][validator].Add(rewards[i+1][validator]...) {
+				denoms[coin.Denom] = true
+			}
+			for _, coin := range claimed[i][validator] {
+				denoms[coin.Denom] = true
+			}
+
+			for denom := range denoms {
+				entry := IncomeEntry{
+					Date:        day.Format(time.DateOnly),
+					Validator:   validator,
+					Denom:       denom,
+					Claimed:     claimed[i][validator].AmountOf(denom),
+					AutoClaimed: autoClaimed[i][validator].AmountOf(denom),
+				}
+				entry.Accrued = rewards[i+1][validator].AmountOf(denom).
+					Sub(rewards[i][validator].AmountOf(denom)).
+					Add(math.LegacyNewDecFromInt(entry.Claimed))
+				if entry.Accrued.IsZero() && entry.Claimed.IsZero() {
+					continue
+				}
+				report.Entries = append(report.Entries, entry)
+
+				total, ok := report.Total[denom]
+				if !ok {
+					total = IncomeTotal{Accrued: math.LegacyZeroDec(), Claimed: math.ZeroInt()}
+				}
+				total.Accrued = total.Accrued.Add(entry.Accrued)
+				total.Claimed = total.Claimed.Add(entry.Claimed)
+				report.Total[denom] = total
+			}
+		}
+	}
+
+	sort.Slice(report.Entries, func(i, j int) bool {
+		a, b := report.Entries[i], report.Entries[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Validator != b.Validator {
+			return a.Validator < b.Validator
+		}
+		return a.Denom < b.Denom
+	})
+
+	return report, nil
+}
+
+// getIncome answers the staking income report of an address from startedAt
+// to endedAt.
+func getIncome(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	startedAt, endedAt, err := parseDateRange(c.Query("startedAt"), c.Query("endedAt"))
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	ctx, cancel, err := requestContext(c)
+	if err != nil {
+		respondError(c, c.Request.Context(), invalidParameter(errors.Wrap(err, "failed to parse timeout")))
+		return
+	}
+	defer cancel()
+
+	report, err := collectIncome(ctx, chainParam, addressParam, startedAt, endedAt)
+	if err != nil {
+		respondError(c, ctx, err)
+		return
+	}
+
+	respond(c, http.StatusOK, report)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"cosmossdk.io/math"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/cosmos/cosmos-sdk/types"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var DEFAULT_PAD_ACCOUNT = "Equity:Unreconciled"
+
+var DEFAULT_TRANSFER_ACCOUNT = "Equity:Transfers"
+
+// LedgerTransaction is a known movement between accounts, e.g. a transfer or a
+// reward withdrawal. A posting without an amount balances the others.
+type LedgerTransaction struct {
+	Date      time.Time
+	Narration string
+	Postings  []LedgerPosting
+}
+
+type LedgerPosting struct {
+	Account string
+	Amount  types.Coin
+}
+
+// ledgerAccountState is what the writer knows about an account: the amounts
+// it last asserted, moved by the transactions written since.
+type ledgerAccountState struct {
+	lastDate time.Time
+	balances map[string]math.Int
+}
+
+// LedgerWriter writes snapshots as plain-text accounting journals: balance
+// assertions in Beancount, balance assignments in Ledger. Changes that no
+// written transaction explains are booked against the pad account.
+//
+// Snapshots and transactions must be written in date order.
+type LedgerWriter struct {
+	w          *bufio.Writer
+	format     string
+	padAccount string
+	accounts   map[string]*ledgerAccountState
+	owned      map[string]map[string]bool
+}
+
+func NewLedgerWriter(w io.Writer, format string) *LedgerWriter {
+	var padAccount = cfg.Ledger.PadAccount
+	if padAccount == "" {
+		padAccount = DEFAULT_PAD_ACCOUNT
+	}
+
+	return &LedgerWriter{
+		w:          bufio.NewWriter(w),
+		format:     format,
+		padAccount: padAccount,
+		accounts:   make(map[string]*ledgerAccountState),
+		owned:      make(map[string]map[string]bool),
+	}
+}
+
+func (w *LedgerWriter) Write(snapshot Snapshot) error {
+	// Sources mapped to the same account are summed.
+	var balances = make(map[string]types.Coins)
+	for _, source := range sortedSources(snapshot.Balances) {
+		account := ledgerAccount(snapshot.Chain, snapshot.Address, source)
+		balances[account] = balances[account].Add(snapshot.Balances[source]...)
+	}
+
+	// Accounts that held something before and are empty now assert zero.
+	owner := snapshot.Chain + "/" + snapshot.Address
+	if w.owned[owner] == nil {
+		w.owned[owner] = make(map[string]bool)
+	}
+	for account := range w.owned[owner] {
+		if _, ok := balances[account]; !ok {
+			balances[account] = types.Coins{}
+		}
+	}
+
+	var accounts = make([]string, 0, len(balances))
+	for account := range balances {
+		accounts = append(accounts, account)
+		w.owned[owner][account] = true
+	}
+	sort.Strings(accounts)
+	if len(accounts) == 0 {
+		return nil
+	}
+
+	if w.format == FORMAT_LEDGER {
+		return w.writeLedgerAssignments(snapshot, accounts, balances)
+	}
+	return w.writeBeancountAssertions(snapshot, accounts, balances)
+}
+
+func (w *LedgerWriter) writeBeancountAssertions(snapshot Snapshot, accounts []string, balances map[string]types.Coins) error {
+	// A balance assertion holds at the start of its day, so opening and
+	// padding happen the day before.
+	day := snapshot.Date.Format(time.DateOnly)
+	dayBefore := snapshot.Date.AddDate(0, 0, -1)
+
+	w.open(w.padAccount, dayBefore)
+
+	for _, account := range accounts {
+		state := w.open(account, dayBefore)
+
+		denoms := state.denoms(balances[account])
+		if state.lastDate.Before(snapshot.Date) && !state.matches(balances[account]) {
+			fmt.Fprintf(w.w, "%s pad %s %s\n", state.lastDate.Format(time.DateOnly), account, w.padAccount)
+		}
+		for _, denom := range denoms {
+			amount := balances[account].AmountOf(denom)
+			fmt.Fprintf(w.w, "%s balance %s %s %s\n", day, account, amount, beancountCommodity(denom))
+			state.balances[denom] = amount
+		}
+		state.lastDate = snapshot.Date
+	}
+
+	_, err := fmt.Fprintln(w.w)
+	return err
+}
+
+func (w *LedgerWriter) writeLedgerAssignments(snapshot Snapshot, accounts []string, balances map[string]types.Coins) error {
+	fmt.Fprintf(w.w, "%s Balance of %s on %s\n", snapshot.Date.Format("2006/01/02"), snapshot.Address, snapshot.Chain)
+	for _, account := range accounts {
+		state, ok := w.accounts[account]
+		if !ok {
+			state = &ledgerAccountState{balances: make(map[string]math.Int)}
+			w.accounts[account] = state
+		}
+
+		for _, denom := range state.denoms(balances[account]) {
+			amount := balances[account].AmountOf(denom)
+			fmt.Fprintf(w.w, "    %s  = %s %s\n", account, amount, ledgerCommodity(denom))
+			state.balances[denom] = amount
+		}
+		state.lastDate = snapshot.Date
+	}
+	fmt.Fprintf(w.w, "    %s\n", w.padAccount)
+
+	_, err := fmt.Fprintln(w.w)
+	return err
+}
+
+// WriteTransaction books a known movement, so that the following balance
+// assertions need no padding for it.
+func (w *LedgerWriter) WriteTransaction(tx LedgerTransaction) error {
+	if w.format == FORMAT_BEANCOUNT {
+		for _, posting := range tx.Postings {
+			w.open(posting.Account, tx.Date)
+		}
+	}
+
+	if w.format == FORMAT_LEDGER {
+		fmt.Fprintf(w.w, "%s * %s\n", tx.Date.Format("2006/01/02"), tx.Narration)
+	} else {
+		fmt.Fprintf(w.w, "%s * %q\n", tx.Date.Format(time.DateOnly), tx.Narration)
+	}
+
+	for _, posting := range tx.Postings {
+		if posting.Amount.Denom == "" {
+			fmt.Fprintf(w.w, "    %s\n", posting.Account)
+			continue
+		}
+
+		var commodity = beancountCommodity(posting.Amount.Denom)
+		if w.format == FORMAT_LEDGER {
+			commodity = ledgerCommodity(posting.Amount.Denom)
+		}
+		fmt.Fprintf(w.w, "    %s  %s %s\n", posting.Account, posting.Amount.Amount, commodity)
+
+		if state, ok := w.accounts[posting.Account]; ok {
+			balance, ok := state.balances[posting.Amount.Denom]
+			if !ok {
+				balance = math.ZeroInt()
+			}
+			state.balances[posting.Amount.Denom] = balance.Add(posting.Amount.Amount)
+		}
+	}
+
+	_, err := fmt.Fprintln(w.w)
+	return err
+}
+
+// open opens account in Beancount on date, unless it is open already.
+func (w *LedgerWriter) open(account string, date time.Time) *ledgerAccountState {
+	state, ok := w.accounts[account]
+	if !ok {
+		state = &ledgerAccountState{lastDate: date, balances: make(map[string]math.Int)}
+		w.accounts[account] = state
+		fmt.Fprintf(w.w, "%s open %s\n", date.Format(time.DateOnly), account)
+	}
+	return state
+}
+
+func (w *LedgerWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// denoms returns every denom held now or asserted before, so that spent denoms
+// are asserted to be zero.
+func (s *ledgerAccountState) denoms(coins types.Coins) []string {
+	var set = make(map[string]bool)
+	for denom := range s.balances {
+		set[denom] = true
+	}
+	for _, coin := range coins {
+		set[coin.Denom] = true
+	}
+
+	var denoms = make([]string, 0, len(set))
+	for denom := range set {
+		denoms = append(denoms, denom)
+	}
+	sort.Strings(denoms)
+	return denoms
+}
+
+// matches reports whether coins is what the account is expected to hold.
+func (s *ledgerAccountState) matches(coins types.Coins) bool {
+	for _, denom := range s.denoms(coins) {
+		expected, ok := s.balances[denom]
+		if !ok {
+			expected = math.ZeroInt()
+		}
+		if !expected.Equal(coins.AmountOf(denom)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ledgerTransaction books activity between the accounts of its address, or
+// against the transfer account for funds leaving or entering them. It reports
+// false for activities that move nothing between accounts, e.g. redelegations.
+func ledgerTransaction(activity Activity) (LedgerTransaction, bool) {
+	var transferAccount = cfg.Ledger.TransferAccount
+	if transferAccount == "" {
+		transferAccount = DEFAULT_TRANSFER_ACCOUNT
+	}
+
+	account := func(source BalanceSource) string {
+		return ledgerAccount(activity.Chain, activity.Address, source)
+	}
+	move := func(from, to string) []LedgerPosting {
+		var postings []LedgerPosting
+		for _, coin := range activity.Amount {
+			if from != "" {
+				postings = append(postings, LedgerPosting{Account: from, Amount: types.Coin{Denom: coin.Denom, Amount: coin.Amount.Neg()}})
+			}
+			if to != "" {
+				postings = append(postings, LedgerPosting{Account: to, Amount: coin})
+			}
+		}
+		if from == "" || to == "" {
+			postings = append(postings, LedgerPosting{Account: transferAccount})
+		}
+		return postings
+	}
+
+	var postings []LedgerPosting
+	switch activity.Type {
+	case ACTIVITY_SEND, ACTIVITY_IBC_TRANSFER_OUT:
+		postings = move(account(COSMOSSDK_BANK_BALANCE), "")
+	case ACTIVITY_RECEIVE, ACTIVITY_IBC_TRANSFER_IN:
+		postings = move("", account(COSMOSSDK_BANK_BALANCE))
+	case ACTIVITY_DELEGATE:
+		postings = move(account(COSMOSSDK_BANK_BALANCE), account(COSMOSSDK_STAKING_DELEGATION))
+	case ACTIVITY_UNDELEGATE:
+		postings = move(account(COSMOSSDK_STAKING_DELEGATION), account(COSMOSSDK_STAKING_UNBONDING))
+	case ACTIVITY_REWARD_WITHDRAWAL:
+		postings = move(account(COSMOSSDK_DISTRIBUTION_REWARD), account(COSMOSSDK_BANK_BALANCE))
+	default:
+		return LedgerTransaction{}, false
+	}
+
+	var narration = activity.Type.String()
+	if activity.Counterparty != "" {
+		narration += " " + activity.Counterparty
+	}
+
+	return LedgerTransaction{
+		Date:      activity.Time.UTC().Truncate(24 * time.Hour),
+		Narration: fmt.Sprintf("%s (tx %s)", narration, activity.TxHash),
+		Postings:  postings,
+	}, true
+}
+
+// ledgerAccount names the account holding source of address on chain, as
+// configured in cfg.Ledger.Accounts.
+func ledgerAccount(chain, address string, source BalanceSource) string {
+	for _, key := range []string{address, "*"} {
+		sources := cfg.Ledger.Accounts[chain][key]
+		if account, ok := sources[source.String()]; ok {
+			return account
+		}
+		if account, ok := sources["*"]; ok {
+			return account + ":" + ledgerComponent(source.String())
+		}
+	}
+
+	return strings.Join([]string{
+		"Assets",
+		"Crypto",
+		ledgerComponent(chain),
+		ledgerComponent(address),
+		ledgerComponent(source.String()),
+	}, ":")
+}
+
+// ledgerComponent makes s a valid Beancount account name component, which
+// starts with a capital letter or digit and holds letters, digits and dashes.
+func ledgerComponent(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case i == 0:
+			b.WriteRune(unicode.ToUpper(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// beancountCommodity names denom as configured in cfg.Ledger.Commodities, or
+// as a valid Beancount currency otherwise: at most 24 capital letters, digits
+// and ' . _ - characters. Longer names keep a prefix and end with a hash of
+// the whole denom so that e.g. the factory tokens of one creator stay apart:
+// IBC.27394FB092D.2190D4BD.
+func beancountCommodity(denom string) string {
+	if commodity, ok := cfg.Ledger.Commodities[denom]; ok {
+		return commodity
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToUpper(denom) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("'._-", r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('.')
+		}
+	}
+
+	commodity := b.String()
+	if len(commodity) > 24 {
+		sum := sha256.Sum256([]byte(denom))
+		commodity = strings.TrimRight(commodity[:15], "'._-") + "." + strings.ToUpper(hex.EncodeToString(sum[:4]))
+	}
+	return strings.TrimRight(commodity, "'._-")
+}
+
+// ledgerCommodity quotes the commodity unless it is only letters, as Ledger
+// requires.
+func ledgerCommodity(denom string) string {
+	commodity := beancountCommodity(denom)
+	for _, r := range commodity {
+		if !unicode.IsLetter(r) {
+			return fmt.Sprintf("%q", commodity)
+		}
+	}
+	return commodity
+}
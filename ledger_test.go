@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cosmos/cosmos-sdk/types"
+	"testing"
+	"time"
+)
+
+func TestLedgerWriterBeancount(t *testing.T) {
+	previous := cfg.Ledger
+	cfg.Ledger = LedgerConfig{
+		Accounts: map[string]map[string]map[string]string{
+			"osmosis": {"*": {"delegation": "Assets:Crypto:Osmosis:Staked"}},
+		},
+		Commodities: map[string]string{"uosmo": "UOSMO"},
+	}
+	t.Cleanup(func() { cfg.Ledger = previous })
+
+	var buf bytes.Buffer
+	w := NewLedgerWriter(&buf, FORMAT_BEANCOUNT)
+
+	for _, snapshot := range []Snapshot{
+		{
+			Chain:   "osmosis",
+			Address: "osmo1abc",
+			Date:    time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+			Balances: map[BalanceSource]types.Coins{
+				COSMOSSDK_BANK_BALANCE:       types.NewCoins(types.NewInt64Coin("uosmo", 100), types.NewInt64Coin("ibc/27394FB092D2ECCD56123C74F36E4C1F926001CEADA9CA97EA622B25F41E5EB2", 7)),
+				COSMOSSDK_STAKING_DELEGATION: types.NewCoins(types.NewInt64Coin("uosmo", 500)),
+			},
+		},
+		{
+			Chain:   "osmosis",
+			Address: "osmo1abc",
+			Date:    time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC),
+			Balances: map[BalanceSource]types.Coins{
+				COSMOSSDK_BANK_BALANCE:       types.NewCoins(types.NewInt64Coin("uosmo", 80)),
+				COSMOSSDK_STAKING_DELEGATION: types.NewCoins(types.NewInt64Coin("uosmo", 500)),
+			},
+		},
+	} {
+		if err := w.Write(snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `2024-09-30 open Equity:Unreconciled
+2024-09-30 open Assets:Crypto:Osmosis:Osmo1abc:Bank
+2024-09-30 pad Assets:Crypto:Osmosis:Osmo1abc:Bank Equity:Unreconciled
+2024-10-01 balance Assets:Crypto:Osmosis:Osmo1abc:Bank 7 IBC.27394FB092D.2190D4BD
+2024-10-01 balance Assets:Crypto:Osmosis:Osmo1abc:Bank 100 UOSMO
+2024-09-30 open Assets:Crypto:Osmosis:Staked
+2024-09-30 pad Assets:Crypto:Osmosis:Staked Equity:Unreconciled
+2024-10-01 balance Assets:Crypto:Osmosis:Staked 500 UOSMO
+
+2024-10-01 pad Assets:Crypto:Osmosis:Osmo1abc:Bank Equity:Unreconciled
+2024-10-02 balance Assets:Crypto:Osmosis:Osmo1abc:Bank 0 IBC.27394FB092D.2190D4BD
+2024-10-02 balance Assets:Crypto:Osmosis:Osmo1abc:Bank 80 UOSMO
+2024-10-02 balance Assets:Crypto:Osmosis:Staked 500 UOSMO
+
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected journal:\n%s", buf.String())
+	}
+}
+
+func TestBeancountCommodity(t *testing.T) {
+	first := beancountCommodity("factory/osmo1q77cw0mmlluxu0wr29fcdd0tdnh78gzhkvhe4n6ulal9qvrtu43qtd0nh8/alpha")
+	second := beancountCommodity("factory/osmo1q77cw0mmlluxu0wr29fcdd0tdnh78gzhkvhe4n6ulal9qvrtu43qtd0nh8/beta")
+	if first == second {
+		t.Errorf("expected distinct commodities for two factory tokens, got %s", first)
+	}
+	if len(first) > 24 {
+		t.Errorf("expected at most 24 characters, got %s", first)
+	}
+	if got := beancountCommodity("uosmo"); got != "UOSMO" {
+		t.Errorf("expected UOSMO, got %s", got)
+	}
+}
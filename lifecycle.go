@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// backgroundJobs runs the work that outlives a request: the scheduler, the
+// health checks, and the backfills and repairs started through the API.
+var backgroundJobs = NewJobGroup()
+
+// JobGroup runs jobs under a context that is canceled when the group stops.
+type JobGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mtx     sync.Mutex
+	stopped bool
+}
+
+func NewJobGroup() *JobGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobGroup{ctx: ctx, cancel: cancel}
+}
+
+// Go runs job in the background, unless the group stopped already, in which
+// case it returns false.
+func (g *JobGroup) Go(job func(ctx context.Context)) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.stopped {
+		return false
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		job(g.ctx)
+	}()
+	return true
+}
+
+// Stop cancels the jobs and waits for them to return, or for ctx to be done.
+func (g *JobGroup) Stop(ctx context.Context) error {
+	g.mtx.Lock()
+	g.stopped = true
+	g.mtx.Unlock()
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "background jobs did not stop")
+	}
+}
+
+// run serves with listen until it fails or ctx is done, then shuts down:
+// in-flight requests are drained within timeout, then background jobs are
+// stopped within another timeout, after which the store and audit log are
+// closed unless something may still be using them.
+func run(ctx context.Context, server *http.Server, listen func() error, timeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() { served <- listen() }()
+
+	var err error
+	select {
+	case err = <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+	case <-ctx.Done():
+		log.Infoln("shutting down")
+	}
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), timeout)
+	defer cancelDrain()
+
+	var drained = true
+	if shutdownErr := server.Shutdown(drainCtx); shutdownErr != nil {
+		log.WithError(shutdownErr).Warningln("in-flight requests did not complete, closing their connections")
+		server.Close()
+		drained = false
+	}
+
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), timeout)
+	defer cancelStop()
+
+	var stopped = true
+	if stopErr := backgroundJobs.Stop(stopCtx); stopErr != nil {
+		log.WithError(stopErr).Warningln("failed to stop the background jobs")
+		stopped = false
+	}
+	closeResources(drained, stopped)
+
+	return err
+}
+
+// closeResources closes the snapshot store once neither requests nor
+// background jobs may use it, and the audit log once requests are drained.
+// Cache entries are written to disk as they are added, so the cache has
+// nothing to flush.
+func closeResources(drained, stopped bool) {
+	if snapshotStore != nil {
+		if !drained || !stopped {
+			log.Warningln("leaving the store open, requests or background jobs may still use it")
+		} else if err := snapshotStore.Close(); err != nil {
+			log.WithError(err).Warningln("failed to close the store")
+		}
+	}
+	if authenticator != nil {
+		if !drained {
+			log.Warningln("leaving the audit log open, requests may still write to it")
+		} else if err := authenticator.Close(); err != nil {
+			log.WithError(err).Warningln("failed to close the audit log")
+		}
+	}
+}
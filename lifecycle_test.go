@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// closeRecordingStore records whether the shutdown closed the store.
+type closeRecordingStore struct {
+	SnapshotStore
+	closed chan struct{}
+}
+
+func (s *closeRecordingStore) Close() error {
+	close(s.closed)
+	return nil
+}
+
+// startRun serves handler with run until the returned cancel is called, and
+// returns the URL of the server and the error run returns.
+func startRun(t *testing.T, handler http.Handler, timeout time.Duration) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+
+	previous := backgroundJobs
+	backgroundJobs = NewJobGroup()
+	t.Cleanup(func() { backgroundJobs = previous })
+
+	previousStore := snapshotStore
+	store := &closeRecordingStore{SnapshotStore: NewMemoryStore(), closed: make(chan struct{})}
+	snapshotStore = store
+	t.Cleanup(func() { snapshotStore = previousStore })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, server, func() error { return server.Serve(listener) }, timeout)
+	}()
+	return "http://" + listener.Addr().String(), cancel, done
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	entered := make(chan struct{})
+	url, cancel, done := startRun(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "drained")
+	}), 5*time.Second)
+
+	jobStopped := make(chan struct{})
+	backgroundJobs.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(jobStopped)
+	})
+
+	type result struct {
+		body string
+		err  error
+	}
+	responded := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			responded <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		responded <- result{string(b), err}
+	}()
+
+	<-entered
+	cancel()
+
+	if r := <-responded; r.err != nil || r.body != "drained" {
+		t.Errorf("expected the in-flight request to complete, got %q, %v", r.body, r.err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return")
+	}
+	select {
+	case <-jobStopped:
+	default:
+		t.Error("expected the background job to be stopped")
+	}
+
+	select {
+	case <-snapshotStore.(*closeRecordingStore).closed:
+	default:
+		t.Error("expected the store to be closed")
+	}
+
+	if backgroundJobs.Go(func(context.Context) {}) {
+		t.Error("expected no job to start after the shutdown")
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected the server to stop listening")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	url, cancel, done := startRun(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}), 100*time.Millisecond)
+
+	// A job that ignores cancellation must not hold the shutdown past the
+	// timeout either.
+	backgroundJobs.Go(func(context.Context) { <-release })
+
+	go http.Get(url)
+	<-entered
+
+	started := time.Now()
+	cancel()
+	select {
+	case <-done:
+		if elapsed := time.Since(started); elapsed > 2*time.Second {
+			t.Errorf("expected the shutdown to give up after its timeout, took %s", elapsed)
+		}
+		// The job may still use the store.
+		select {
+		case <-snapshotStore.(*closeRecordingStore).closed:
+			t.Error("expected the store to be left open")
+		default:
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after its timeout")
+	}
+}
@@ -1,75 +1,260 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"github.com/cosmos/cosmos-sdk/types"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/xlab/suplog"
-	"gopkg.in/yaml.v2"
-	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 )
 
 var cfg = Config{}
 
-func init() {
-	f, err := os.Open("config.yaml")
-	if err != nil {
-		panic(err)
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
 	}
+}
 
-	b, err := io.ReadAll(f)
-	if err != nil {
-		log.Fatalln(err)
+// setup loads the configuration at path into cfg and prepares the RPC clients,
+// cache, store and alerts it describes.
+func setup(path string) error {
+	if err := setupClients(path); err != nil {
+		return err
 	}
 
-	err = yaml.Unmarshal(b, &cfg)
-	if err != nil {
-		log.Fatalln(err)
+	if err := setupStore(); err != nil {
+		return err
 	}
 
+	return setupAlerts()
 }
 
-func main() {
+// setupClients loads the configuration at path into cfg and prepares the RPC
+// clients and cache it describes, for commands that don't use the store.
+func setupClients(path string) error {
+	config, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	cfg = config
+
+	if err := setupChains(); err != nil {
+		return err
+	}
 
+	return setupCache()
+}
+
+func setupChains() error {
 	for k, chain := range cfg.Chains {
 		var timeout = chain.Timeout
 		if timeout == 0 {
 			timeout = DEFAULT_TIMEOUT
 		}
 
-		if chain.RPCUrl == "" {
-			log.Fatalln("each chain must have rpcURL")
-		} else if len(chain.RPCUrl) < 4 || chain.RPCUrl[:4] != "http" {
-			log.Fatalln("rpcURL must be formatted as http.")
-		}
-		client, err := NewHTTPClient(chain.RPCUrl, timeout)
+		httpClient, err := NewHTTPClient(chain.RPCUrl, timeout, chain.ConnectionConfig)
 		if err != nil {
-			log.Fatalln(err)
+			return errors.Wrapf(err, "chain %s", k)
 		}
-		if client == nil {
-			log.Fatalln("client shouldn't be <nil>")
+		httpClient.chain = k
+
+		var client Client = httpClient
+		if archive := chain.Archive; archive != nil {
+			var archiveTimeout = archive.Timeout
+			if archiveTimeout == 0 {
+				archiveTimeout = timeout
+			}
+			archiveClient, err := NewHTTPClient(archive.RPCUrl, archiveTimeout, archive.ConnectionConfig)
+			if err != nil {
+				return errors.Wrapf(err, "chain %s: archive", k)
+			}
+			archiveClient.chain = k
+			client = NewArchiveClient(client, archiveClient)
 		}
 		c := cfg.Chains[k]
 		c.Client = client
 		cfg.Chains[k] = c
 	}
 
-	router := gin.Default()
-	router.GET("/balances/:chain/:address", getBalances)
+	return nil
+}
+
+func setupCache() error {
+	if cfg.Cache.Disabled {
+		return nil
+	}
+
+	var size = cfg.Cache.Size
+	if size == 0 {
+		size = DEFAULT_CACHE_SIZE
+	}
+
+	var diskSize = cfg.Cache.DiskSize
+	if diskSize == 0 {
+		diskSize = DEFAULT_CACHE_DISK_SIZE
+	}
+
+	cache, err := NewBalanceCache(size, cfg.Cache.Dir, diskSize)
+	if err != nil {
+		return err
+	}
+	balanceCache = cache
+
+	return nil
+}
+
+func setupStore() error {
+	if cfg.Store.Driver == "" && len(cfg.Watch.Addresses) == 0 {
+		return nil
+	}
+	if cfg.Store.Driver == "" || cfg.Store.Driver == STORE_MEMORY {
+		snapshotStore = NewMemoryStore()
+		return nil
+	}
 
-	err := router.Run(fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port))
+	dsn, err := cfg.Store.DSN.Resolve()
 	if err != nil {
-		panic(err)
+		return errors.Wrap(err, "store")
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var store SnapshotStore
+	switch cfg.Store.Driver {
+	case STORE_SQLITE:
+		store, err = NewSQLiteStore(ctx, dsn)
+	case STORE_POSTGRES:
+		store, err = NewPostgresStore(ctx, dsn)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to open the %s store", cfg.Store.Driver)
+	}
+	snapshotStore = store
+	return nil
+}
+
+func setupAlerts() error {
+	if len(cfg.Alerts.Rules) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	engine, err := NewAlertEngine(ctx, cfg.Alerts, snapshotStore)
+	if err != nil {
+		return errors.Wrap(err, "alerts")
+	}
+	alertEngine = engine
+	return nil
+}
+
+func setupAuth() error {
+	if len(cfg.Auth.Keys) == 0 {
+		return nil
+	}
+
+	a, err := NewAuthenticator(cfg.Auth)
+	if err != nil {
+		return errors.Wrap(err, "auth")
+	}
+	authenticator = a
+	return nil
+}
+
+func serve() error {
+	if len(cfg.Watch.Addresses) > 0 {
+		scheduler, err := NewScheduler(cfg.Watch, snapshotStore)
+		if err != nil {
+			return err
+		}
+		backgroundJobs.Go(func(ctx context.Context) {
+			if err := scheduler.Run(ctx); err != nil {
+				log.WithError(err).Errorln("scheduler stopped")
+			}
+		})
+	}
+
+	if err := setupAuth(); err != nil {
+		return err
+	}
+
+	healthChecker = NewHealthChecker(cfg.Health)
+	backgroundJobs.Go(healthChecker.Run)
+
+	router := newRouter()
+
+	server := &http.Server{
+		Addr:    listenAddress(cfg.Server.Host, cfg.Server.Port),
+		Handler: router,
+	}
+	listen := server.ListenAndServe
+	if cfg.Server.TLS != nil {
+		tlsConfig, err := newServerTLSConfig(cfg.Server.TLS)
+		if err != nil {
+			return errors.Wrap(err, "server")
+		}
+		server.TLSConfig = tlsConfig
+		listen = func() error { return server.ListenAndServeTLS("", "") }
+	}
+
+	var timeout = cfg.Server.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return run(ctx, server, listen, timeout)
+}
+
+// listenAddress is the address the server listens on, host being empty for
+// every interface.
+func listenAddress(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func newRouter() *gin.Engine {
+	router := gin.Default()
+	if authenticator != nil {
+		router.Use(authenticator.Middleware)
+	}
+	router.GET("/healthz", getHealthz)
+	router.GET("/readyz", getReadyz)
+	router.GET("/chains", getChains)
+	router.GET("/chains/:chain/status", getChainStatus)
+	router.GET("/balances/:chain/:address", getBalances)
+	router.GET("/diff/:chain/:address", getDiff)
+	router.GET("/activity/:chain/:address", getActivity)
+	router.GET("/income/:chain/:address", getIncome)
+	router.GET("/snapshots/:chain/:address", getSnapshots)
+	router.GET("/snapshots/:chain/:address/latest", getLatestSnapshot)
+	router.GET("/admin/cache", getCache)
+	router.DELETE("/admin/cache", deleteCache)
+	router.GET("/admin/backfill/:chain/:address", getBackfill)
+	router.POST("/admin/backfill/:chain/:address", postBackfill)
+	router.GET("/admin/check/:chain/:address", getCheck)
+	router.GET("/admin/repair/:chain/:address", getRepair)
+	router.POST("/admin/repair/:chain/:address", postRepair)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	return router
 }
 
 type Message struct {
-	Error     string      `json:"error"`
+	Error string `json:"error"`
+	// Code classifies Error, see apierror.go.
+	Code      string      `json:"code,omitempty"`
 	IsSuccess bool        `json:"isSuccess"`
 	Content   interface{} `json:"content"`
 }
@@ -87,111 +272,115 @@ func getBalances(c *gin.Context) {
 	startedAt := c.Query("startedAt")
 	endedAt := c.Query("endedAt")
 
-	var (
-		coins map[BalanceSource]types.Coins
-		err   error
-	)
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	format := c.DefaultQuery("format", FORMAT_JSON)
+	if format != FORMAT_JSON && !validExportFormat(format) {
+		respondError(c, c.Request.Context(), invalidParameter(errors.Errorf("unknown format %s", format)))
+		return
+	}
+
+	ctx, cancel, err := requestContext(c)
+	if err != nil {
+		respondError(c, c.Request.Context(), invalidParameter(errors.Wrap(err, "failed to parse timeout")))
+		return
+	}
+	defer cancel()
+
+	var coins map[BalanceSource]types.Coins
 	if startedAt == "" || endedAt == "" {
-		coins, err = queryEveryBalances(chainParam, addressParam, 0)
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, Message{
-				errors.Wrap(err, "failed to query balances").Error(),
-				false,
-				struct{}{},
-			})
+		if format != FORMAT_JSON {
+			exportBalances(c, ctx, format, chainParam, addressParam, nil)
 			return
 		}
-	} else {
-		parsedStartedAt, err := time.Parse(time.DateOnly, startedAt)
+
+		coins, err = queryCompleteBalances(ctx, chainParam, addressParam, 0)
 		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, Message{
-				errors.Wrap(err, "failed to parse time").Error(),
-				false,
-				struct{}{},
-			})
+			respondError(c, ctx, errors.Wrap(err, "failed to query balances"))
 			return
 		}
-		parsedEndedAt, err := time.Parse(time.DateOnly, endedAt)
+	} else {
+		parsedStartedAt, parsedEndedAt, err := parseDateRange(startedAt, endedAt)
 		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, Message{
-				errors.Wrap(err, "failed to parse time").Error(),
-				false,
-				struct{}{},
-			})
+			respondError(c, ctx, err)
 			return
 		}
 
-		latestHeight, err := GetLatestHeight(chainParam)
+		r, err := resolveDailyHeights(ctx, chainParam, parsedStartedAt, parsedEndedAt)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, Message{
-				errors.Wrap(err, "failed to get latestHeight").Error(),
-				false,
-				struct{}{},
-			})
+			respondError(c, ctx, err)
 			return
 		}
 
-		// calculate block time
-		latestBlockTime, err := GetBlockTime(chainParam, latestHeight)
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, Message{
-				errors.Wrap(err, "failed to get block time").Error(),
-				false,
-				struct{}{},
-			})
+		if format != FORMAT_JSON {
+			exportBalances(c, ctx, format, chainParam, addressParam, r)
 			return
 		}
 
-		secondBlockTime, err := GetBlockTime(chainParam, latestHeight-1)
+		daily, err := queryDailyBalances(ctx, chainParam, addressParam, r)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, Message{
-				errors.Wrap(err, "failed to get block time").Error(),
-				false,
-				struct{}{},
-			})
+			respondError(c, ctx, errors.Wrap(err, "failed to query balances"))
 			return
 		}
 
-		expectedBlockInterval := latestBlockTime.Sub(*secondBlockTime)
-
-		endedAtHeight, err := calculateTargetTimeAndHeight(chainParam, parsedEndedAt.Truncate(24*time.Hour), *latestBlockTime, expectedBlockInterval, latestHeight)
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, Message{
-				errors.Wrap(err, "failed to get block time").Error(),
-				false,
-				struct{}{},
-			})
-			return
+		var periodCoins = make(map[string]map[BalanceSource]types.Coins)
+		for k, v := range daily {
+			periodCoins[k.String()] = v
 		}
 
-		r := calculateDailyHeights(chainParam, parsedStartedAt.Truncate(24*time.Hour), parsedEndedAt.Truncate(24*time.Hour), expectedBlockInterval, endedAtHeight)
+		respond(c, http.StatusOK, periodCoins)
+		return
+	}
+
+	respond(c, http.StatusOK, Balance{
+		Balances: coins,
+		Address:  addressParam,
+	})
+}
 
-		var periodCoins = make(map[string]map[BalanceSource]types.Coins)
-		for k, v := range r {
-			coins, err = queryEveryBalances(chainParam, addressParam, v)
-			periodCoins[k.String()] = coins
+// parseDateRange parses the dates of a range that ends on or after it
+// starts, and no later than today.
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	var dates [2]time.Time
+	for i, value := range []string{from, to} {
+		parsed, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return time.Time{}, time.Time{}, invalidParameter(errors.Wrap(err, "failed to parse time"))
 		}
+		dates[i] = parsed
+	}
+	if dates[1].Before(dates[0]) {
+		return time.Time{}, time.Time{}, invalidDateRange("%s is before %s", to, from)
+	}
+	if dates[1].After(time.Now().UTC()) {
+		return time.Time{}, time.Time{}, invalidDateRange("%s is in the future", to)
+	}
+	return dates[0], dates[1], nil
+}
 
-		c.IndentedJSON(http.StatusOK,
-			Message{
-				"",
-				false,
-				periodCoins,
-			})
-		return
+// requestContext derives the context used for upstream calls from the incoming
+// request, so a disconnecting client cancels outstanding RPC work. An overall
+// deadline can be set with the "timeout" query parameter (e.g. "30s").
+func requestContext(c *gin.Context) (context.Context, context.CancelFunc, error) {
+	ctx := c.Request.Context()
+
+	timeout := c.Query("timeout")
+	if timeout == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
 	}
 
+	d, err := time.ParseDuration(timeout)
 	if err != nil {
-		log.Error(err.Error())
+		return nil, nil, err
+	}
+	if d <= 0 {
+		return nil, nil, errors.New("timeout must be positive")
 	}
 
-	c.IndentedJSON(http.StatusOK,
-		Message{
-			"",
-			false,
-			Balance{
-				Balances: coins,
-				Address:  addressParam,
-			},
-		})
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, nil
 }
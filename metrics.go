@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync"
+)
+
+const METRICS_NAMESPACE = "balance_collector"
+
+var (
+	coalescedCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "coalesced_calls_total",
+		Help:      "Upstream calls served by an identical call already in flight.",
+	}, []string{"chain", "call"})
+
+	balanceAmount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "balance",
+		Help:      "Latest snapshotted balance of a watched address, in base units of the denom.",
+	}, []string{"chain", "address", "source", "denom"})
+
+	balanceBlockTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "balance_block_time_seconds",
+		Help:      "Block time of the latest snapshot of a watched address.",
+	}, []string{"chain", "address"})
+
+	rpcRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "rpc_request_duration_seconds",
+		Help:      "Duration of each attempt of an upstream RPC request.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"chain", "endpoint", "path"})
+
+	rpcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "rpc_errors_total",
+		Help:      "Upstream RPC attempts that failed or answered with an HTTP error status.",
+	}, []string{"chain", "endpoint", "path"})
+
+	rpcRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "rpc_retries_total",
+		Help:      "Upstream RPC requests retried after a failed attempt.",
+	}, []string{"chain", "endpoint", "path"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "cache_lookups_total",
+		Help:      "Balance cache lookups by result: hit, disk_hit or miss.",
+	}, []string{"chain", "result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "cache_hit_ratio",
+		Help:      "Share of balance cache lookups served from memory or disk since startup.",
+	}, func() float64 {
+		if balanceCache == nil {
+			return 0
+		}
+		return balanceCache.HitRatio()
+	})
+
+	endpointHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "endpoint_healthy",
+		Help:      "Whether the last health check of an endpoint of a chain succeeded.",
+	}, []string{"chain", "endpoint"})
+
+	alertNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "alert_notifications_total",
+		Help:      "Alert notifications sent, by notifier and result.",
+	}, []string{"notifier", "result"})
+
+	heightResolutions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "height_resolutions_total",
+		Help:      "Resolutions of the height at a time (single) or of every day of a range (daily), by result.",
+	}, []string{"chain", "kind", "result"})
+)
+
+// rpcLabels identify the upstream requests of an HTTPClient in metrics.
+type rpcLabels struct {
+	chain    string
+	endpoint string
+	path     string
+}
+
+func (l rpcLabels) values() []string {
+	return []string{l.chain, l.endpoint, l.path}
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observedBalances remembers the height and denoms last exported for each
+// watched address, so that older snapshots don't overwrite newer ones and
+// denoms that vanish are removed.
+var observedBalances = struct {
+	sync.Mutex
+	heights map[string]int64
+	labels  map[string][][]string
+}{heights: make(map[string]int64), labels: make(map[string][][]string)}
+
+// observeSnapshot exports the balances of snapshot unless a newer snapshot of
+// the address is exported already, and reports whether it did.
+func observeSnapshot(snapshot Snapshot) bool {
+	key := memoryStoreKey(snapshot.Chain, snapshot.Address)
+
+	observedBalances.Lock()
+	defer observedBalances.Unlock()
+
+	if height, ok := observedBalances.heights[key]; ok && height > snapshot.Height {
+		return false
+	}
+
+	var (
+		exported [][]string
+		current  = make(map[[2]string]bool)
+	)
+	for source, coins := range snapshot.Balances {
+		for _, coin := range coins {
+			labels := []string{snapshot.Chain, snapshot.Address, source.String(), coin.Denom}
+			balanceAmount.WithLabelValues(labels...).Set(coinFloat(coin))
+			exported = append(exported, labels)
+			current[[2]string{source.String(), coin.Denom}] = true
+		}
+	}
+	for _, labels := range observedBalances.labels[key] {
+		if !current[[2]string{labels[2], labels[3]}] {
+			balanceAmount.DeleteLabelValues(labels...)
+		}
+	}
+	balanceBlockTime.WithLabelValues(snapshot.Chain, snapshot.Address).Set(float64(snapshot.BlockTime.Unix()))
+
+	observedBalances.heights[key] = snapshot.Height
+	observedBalances.labels[key] = exported
+	return true
+}
+
+// coinFloat approximates the amount of coin, which may exceed what a float64
+// holds exactly.
+func coinFloat(coin types.Coin) float64 {
+	f, _ := coin.Amount.ToLegacyDec().Float64()
+	return f
+}
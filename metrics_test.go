@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestObserveSnapshot(t *testing.T) {
+	snapshot := func(height int64, balances types.Coins) Snapshot {
+		return Snapshot{
+			Chain:     "metricchain",
+			Address:   "cosmos1metrics",
+			Height:    height,
+			BlockTime: time.Unix(1730000000+height, 0),
+			Balances:  map[BalanceSource]types.Coins{COSMOSSDK_BANK_BALANCE: balances},
+		}
+	}
+	gauge := func(denom string) float64 {
+		return testutil.ToFloat64(balanceAmount.WithLabelValues("metricchain", "cosmos1metrics", "bank", denom))
+	}
+
+	observeSnapshot(snapshot(10, types.NewCoins(types.NewInt64Coin("uatom", 5), types.NewInt64Coin("uother", 7))))
+	observeSnapshot(snapshot(20, types.NewCoins(types.NewInt64Coin("uatom", 8))))
+	// An older snapshot, e.g. a repaired one, must not win.
+	observeSnapshot(snapshot(15, types.NewCoins(types.NewInt64Coin("uatom", 6))))
+
+	if got := gauge("uatom"); got != 8 {
+		t.Errorf("expected 8uatom, got %v", got)
+	}
+	if got := testutil.ToFloat64(balanceBlockTime.WithLabelValues("metricchain", "cosmos1metrics")); got != 1730000020 {
+		t.Errorf("expected the block time of height 20, got %v", got)
+	}
+
+	// uother vanished at height 20.
+	exported := observedBalances.labels[memoryStoreKey("metricchain", "cosmos1metrics")]
+	if len(exported) != 1 || exported[0][3] != "uatom" {
+		t.Errorf("expected only uatom to be exported, got %v", exported)
+	}
+	if balanceAmount.DeleteLabelValues("metricchain", "cosmos1metrics", "bank", "uother") {
+		t.Error("expected the uother series to be removed")
+	}
+}
+
+func TestHeightResolutionMetricsE2E(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	daily := heightResolutions.WithLabelValues("testchain", "daily", "ok")
+	before := testutil.ToFloat64(daily)
+
+	status, _ := doRequest(t, "/balances/testchain/"+address+"?startedAt=2024-10-25&endedAt=2024-10-31")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+	if got := testutil.ToFloat64(daily) - before; got != 1 {
+		t.Errorf("expected one daily height resolution, got %v", got)
+	}
+
+	if _, err := resolveHeight(context.Background(), "testchain", e2eNow.Add(time.Hour)); err == nil {
+		t.Fatal("expected an error for a time after the latest block")
+	}
+	if got := testutil.ToFloat64(heightResolutions.WithLabelValues("testchain", "single", "error")); got < 1 {
+		t.Errorf("expected a failed single height resolution, got %v", got)
+	}
+}
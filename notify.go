@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var NOTIFY_TIMEOUT = 10 * time.Second
+
+var DEFAULT_SMTP_PORT = 587
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	switch {
+	case config.Webhook != nil:
+		return newWebhookNotifier(*config.Webhook, func(alert Alert) interface{} { return alert })
+	case config.Slack != nil:
+		return newWebhookNotifier(*config.Slack, func(alert Alert) interface{} {
+			return map[string]string{"text": alert.Message}
+		})
+	case config.SMTP != nil:
+		return newSMTPNotifier(*config.SMTP)
+	}
+	return nil, errors.New("notifier has no webhook, slack or smtp")
+}
+
+// WebhookNotifier posts a JSON document made of each alert by payload.
+type WebhookNotifier struct {
+	client  *http.Client
+	url     string
+	headers http.Header
+	payload func(alert Alert) interface{}
+}
+
+func newWebhookNotifier(config WebhookConfig, payload func(alert Alert) interface{}) (*WebhookNotifier, error) {
+	url, err := config.URL.Resolve()
+	if err != nil {
+		return nil, errors.Wrap(err, "url")
+	}
+
+	n := &WebhookNotifier{
+		client:  &http.Client{Timeout: NOTIFY_TIMEOUT},
+		url:     url,
+		headers: http.Header{},
+		payload: payload,
+	}
+	for k, v := range config.Headers {
+		value, err := v.Resolve()
+		if err != nil {
+			return nil, errors.Wrapf(err, "header %s", k)
+		}
+		n.headers.Set(k, value)
+	}
+	return n, nil
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	b, err := json.Marshal(n.payload(alert))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range n.headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier mails each alert, authenticating when a username is set.
+type SMTPNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+func newSMTPNotifier(config SMTPConfig) (*SMTPNotifier, error) {
+	var port = config.Port
+	if port == 0 {
+		port = DEFAULT_SMTP_PORT
+	}
+
+	n := &SMTPNotifier{
+		addr: net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		from: config.From,
+		to:   config.To,
+	}
+	if config.Username != "" {
+		password, err := config.Password.Resolve()
+		if err != nil {
+			return nil, errors.Wrap(err, "password")
+		}
+		n.auth = smtp.PlainAuth("", config.Username, password, config.Host)
+	}
+	return n, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, alert Alert) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", alert.Message)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nRule: %s (%s)\r\nChain: %s\r\nAddress: %s\r\nDenom: %s\r\nAmount: %s\r\nHeight: %d\r\nBlock time: %s\r\n",
+		alert.Message, alert.Rule, alert.Kind, alert.Chain, alert.Address, alert.Denom, alert.Value, alert.Height, alert.BlockTime.Format(time.RFC3339))
+
+	// net/smtp takes no context; bound the delivery by running it aside.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(n.addr, n.auth, n.from, n.to, msg.Bytes()) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(NOTIFY_TIMEOUT):
+		return errors.New("smtp delivery timed out")
+	}
+}
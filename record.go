@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Exchange is a request to a Client and the response it produced, as stored in
+// fixture files.
+type Exchange struct {
+	Path       string            `json:"path"`
+	Parameters map[string]string `json:"parameters"`
+	Response   json.RawMessage   `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func (e Exchange) key() string {
+	return exchangeKey(e.Path, e.Parameters)
+}
+
+func exchangeKey(path string, parameters map[string]string) string {
+	var values = url.Values{}
+	for k, v := range parameters {
+		values.Set(k, v)
+	}
+	return path + "?" + values.Encode()
+}
+
+// RecordingClient passes queries through to Client and keeps every exchange so
+// that it can be saved as a fixture for ReplayClient.
+type RecordingClient struct {
+	Client
+	mtx       sync.Mutex
+	exchanges map[string]Exchange
+}
+
+func NewRecordingClient(client Client) *RecordingClient {
+	return &RecordingClient{
+		Client:    client,
+		exchanges: make(map[string]Exchange),
+	}
+}
+
+func (c *RecordingClient) Query(ctx context.Context, path string, parameters map[string]string) ([]byte, error) {
+	resp, err := c.Client.Query(ctx, path, parameters)
+
+	// Cancellations say nothing about the node, so they aren't worth replaying.
+	if err != nil && ctx.Err() != nil {
+		return resp, err
+	}
+
+	var exchange = Exchange{
+		Path:       path,
+		Parameters: parameters,
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	} else if json.Valid(resp) {
+		exchange.Response = resp
+	} else {
+		return nil, errors.Errorf("%s returned a non-JSON response", path)
+	}
+
+	c.mtx.Lock()
+	c.exchanges[exchange.key()] = exchange
+	c.mtx.Unlock()
+
+	return resp, err
+}
+
+// Save writes the recorded exchanges to path, sorted so that re-recording
+// produces small diffs.
+func (c *RecordingClient) Save(path string) error {
+	c.mtx.Lock()
+	var exchanges = make([]Exchange, 0, len(c.exchanges))
+	for _, exchange := range c.exchanges {
+		exchanges = append(exchanges, exchange)
+	}
+	c.mtx.Unlock()
+
+	sort.Slice(exchanges, func(i, j int) bool {
+		return exchanges[i].key() < exchanges[j].key()
+	})
+
+	b, err := json.MarshalIndent(exchanges, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal exchanges")
+	}
+
+	return errors.Wrap(os.WriteFile(path, append(b, '\n'), 0o644), "failed to write fixture")
+}
+
+// ReplayClient answers queries from a fixture written by RecordingClient and
+// fails on any query that wasn't recorded.
+type ReplayClient struct {
+	exchanges map[string]Exchange
+}
+
+func NewReplayClient(path string) (*ReplayClient, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read fixture")
+	}
+
+	var exchanges []Exchange
+	if err := json.Unmarshal(b, &exchanges); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode fixture %s", path)
+	}
+
+	var c = &ReplayClient{exchanges: make(map[string]Exchange)}
+	for _, exchange := range exchanges {
+		c.exchanges[exchange.key()] = exchange
+	}
+	return c, nil
+}
+
+func (c *ReplayClient) Query(ctx context.Context, path string, parameters map[string]string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	exchange, ok := c.exchanges[exchangeKey(path, parameters)]
+	if !ok {
+		return nil, errors.Errorf("no recorded response for %s", exchangeKey(path, parameters))
+	}
+
+	if exchange.Error != "" {
+		return nil, errors.New(exchange.Error)
+	}
+	return exchange.Response, nil
+}
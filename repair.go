@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	log "github.com/xlab/suplog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DAILY_SNAPSHOT_TOLERANCE is how long before the start of its day the block
+// of a daily snapshot may be; an older block means its height was resolved
+// wrongly.
+var DAILY_SNAPSHOT_TOLERANCE = time.Hour
+
+// SeriesIssue is a stored daily snapshot that is wrong.
+type SeriesIssue struct {
+	Date    time.Time `json:"date"`
+	Height  int64     `json:"height"`
+	Problem string    `json:"problem"`
+}
+
+// SeriesReport lists the missing and errored daily snapshots of an address,
+// and after a repair, which of them were repaired and which remain
+// unrecoverable.
+type SeriesReport struct {
+	Chain         string        `json:"chain"`
+	Address       string        `json:"address"`
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	Checked       int           `json:"checked"`
+	Missing       []time.Time   `json:"missing"`
+	Errored       []SeriesIssue `json:"errored"`
+	Repaired      []time.Time   `json:"repaired"`
+	Unrecoverable []BackfillGap `json:"unrecoverable"`
+	CheckedAt     time.Time     `json:"checkedAt"`
+}
+
+// Broken returns the days to repair, in order.
+func (r SeriesReport) Broken() []time.Time {
+	var days = append([]time.Time(nil), r.Missing...)
+	for _, issue := range r.Errored {
+		days = append(days, issue.Date)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// checkSeries scans the stored daily snapshots of address from the start of
+// from to the start of to, up to today, for missing days and snapshots whose
+// block is not the last one before the start of their day.
+func checkSeries(ctx context.Context, store SnapshotStore, chain, address string, from, to time.Time) (SeriesReport, error) {
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	if today := time.Now().UTC().Truncate(24 * time.Hour); to.After(today) {
+		to = today
+	}
+	var report = SeriesReport{Chain: chain, Address: address, From: from, To: to, CheckedAt: time.Now().UTC()}
+
+	series, err := store.Series(ctx, chain, address, from.Add(-24*time.Hour), to.Add(24*time.Hour))
+	if err != nil {
+		return report, err
+	}
+	var daily = make(map[time.Time]Snapshot)
+	for _, snapshot := range series {
+		if snapshot.Daily {
+			daily[snapshot.Date] = snapshot
+		}
+	}
+
+	for day := from; !day.After(to); day = day.Add(24 * time.Hour) {
+		report.Checked++
+
+		snapshot, ok := daily[day]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, day)
+		case snapshot.BlockTime.After(day):
+			report.Errored = append(report.Errored, SeriesIssue{day, snapshot.Height, "block time " + snapshot.BlockTime.Format(time.RFC3339) + " is after the start of the day"})
+		case day.Sub(snapshot.BlockTime) > DAILY_SNAPSHOT_TOLERANCE:
+			report.Errored = append(report.Errored, SeriesIssue{day, snapshot.Height, "block time " + snapshot.BlockTime.Format(time.RFC3339) + " is too long before the start of the day"})
+		}
+	}
+
+	return report, nil
+}
+
+// repairSeries checks the stored daily snapshots of address and takes the
+// missing and errored ones again, through the archive endpoint of the chain
+// if it has one. Days that still fail are reported as unrecoverable.
+func repairSeries(ctx context.Context, store SnapshotStore, chain, address string, from, to time.Time) (SeriesReport, error) {
+	report, err := checkSeries(ctx, store, chain, address, from, to)
+	if err != nil {
+		return report, err
+	}
+
+	if c, ok := cfg.Chains[chain]; ok && c.Archive != nil {
+		ctx = withArchive(ctx)
+	}
+
+	for _, day := range report.Broken() {
+		err := takeDailySnapshot(ctx, store, chain, address, day)
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if err != nil {
+			log.WithError(err).Warningf("failed to repair the snapshot of %s on %s at %s", address, chain, day.Format(time.DateOnly))
+			report.Unrecoverable = append(report.Unrecoverable, BackfillGap{day, err.Error()})
+			continue
+		}
+		report.Repaired = append(report.Repaired, day)
+	}
+
+	return recheckRepaired(ctx, store, report)
+}
+
+// recheckRepaired checks the series of report again, so that the days whose
+// new snapshot is still wrong are reported as unrecoverable rather than
+// repaired.
+func recheckRepaired(ctx context.Context, store SnapshotStore, report SeriesReport) (SeriesReport, error) {
+	if len(report.Repaired) == 0 {
+		return report, nil
+	}
+
+	recheck, err := checkSeries(ctx, store, report.Chain, report.Address, report.From, report.To)
+	if err != nil {
+		return report, err
+	}
+	var problems = make(map[time.Time]string)
+	for _, day := range recheck.Missing {
+		problems[day] = "still missing after the repair"
+	}
+	for _, issue := range recheck.Errored {
+		problems[issue.Date] = issue.Problem
+	}
+
+	var repaired []time.Time
+	for _, day := range report.Repaired {
+		if problem, ok := problems[day]; ok {
+			report.Unrecoverable = append(report.Unrecoverable, BackfillGap{day, problem})
+			continue
+		}
+		repaired = append(repaired, day)
+	}
+	report.Repaired = repaired
+	sort.Slice(report.Unrecoverable, func(i, j int) bool { return report.Unrecoverable[i].Date.Before(report.Unrecoverable[j].Date) })
+	return report, nil
+}
+
+// repairJobs holds the running repairs started through the API, and the
+// report of the latest repair of each address.
+var repairJobs = struct {
+	sync.Mutex
+	running map[string]bool
+	reports map[string]SeriesReport
+}{running: make(map[string]bool), reports: make(map[string]SeriesReport)}
+
+// getCheck answers the missing and errored daily snapshots of an address
+// between the "from" and "to" dates.
+func getCheck(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if snapshotStore == nil {
+		respondError(c, c.Request.Context(), errSnapshotsNotStored)
+		return
+	}
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	report, err := checkSeries(c.Request.Context(), snapshotStore, chainParam, addressParam, from, to)
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	respond(c, http.StatusOK, report)
+}
+
+// postRepair starts the repair of the daily snapshots of an address between
+// the "from" and "to" dates in the background.
+func postRepair(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if snapshotStore == nil {
+		respondError(c, c.Request.Context(), errSnapshotsNotStored)
+		return
+	}
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	from, to, err := parseDateRange(c.Query("from"), c.Query("to"))
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	key := memoryStoreKey(chainParam, addressParam)
+	repairJobs.Lock()
+	if repairJobs.running[key] {
+		repairJobs.Unlock()
+		respondError(c, c.Request.Context(), conflict("a repair of %s is running already", addressParam))
+		return
+	}
+	repairJobs.running[key] = true
+	repairJobs.Unlock()
+
+	started := backgroundJobs.Go(func(ctx context.Context) {
+		report, err := repairSeries(ctx, snapshotStore, chainParam, addressParam, from, to)
+		if err != nil {
+			log.WithError(err).Errorf("repair of %s on %s stopped", addressParam, chainParam)
+		}
+
+		repairJobs.Lock()
+		delete(repairJobs.running, key)
+		repairJobs.reports[key] = report
+		repairJobs.Unlock()
+	})
+	if !started {
+		repairJobs.Lock()
+		delete(repairJobs.running, key)
+		repairJobs.Unlock()
+		respondError(c, c.Request.Context(), errShuttingDown)
+		return
+	}
+
+	respond(c, http.StatusAccepted, struct{}{})
+}
+
+// getRepair answers the report of the latest repair of an address.
+func getRepair(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	key := memoryStoreKey(chainParam, addressParam)
+	repairJobs.Lock()
+	report, ok := repairJobs.reports[key]
+	running := repairJobs.running[key]
+	repairJobs.Unlock()
+
+	if !ok {
+		respondError(c, c.Request.Context(), notFound("no repair of %s has finished", addressParam))
+		return
+	}
+
+	respond(c, http.StatusOK, struct {
+		SeriesReport
+		Running bool `json:"running"`
+	}{report, running})
+}
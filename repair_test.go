@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"cosmos-balance-collector/fakenode"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepairSeriesThroughArchive(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	day := func(day int) time.Time {
+		return time.Date(2024, 10, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	// The node prunes everything before the 30th, the archive before the 27th.
+	chain := dailyBalanceChain(t, address)
+	chain.Prune(chain.HeightAt(day(30)))
+	startFakeNode(t, chain)
+
+	archiveChain := dailyBalanceChain(t, address)
+	archiveChain.Prune(archiveChain.HeightAt(day(27)))
+	server := httptest.NewServer(fakenode.New(archiveChain))
+	t.Cleanup(server.Close)
+	archive, err := NewHTTPClient(server.URL, DEFAULT_TIMEOUT, ConnectionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cfg.Chains["testchain"]
+	c.Archive = &ArchiveConfig{RPCUrl: server.URL}
+	c.Client = NewArchiveClient(c.Client, archive)
+	cfg.Chains["testchain"] = c
+
+	attempts := BACKFILL_ATTEMPTS
+	BACKFILL_ATTEMPTS = 1
+	t.Cleanup(func() { BACKFILL_ATTEMPTS = attempts })
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for _, snapshot := range []Snapshot{
+		{Chain: "testchain", Address: address, Date: day(27), Height: 10, BlockTime: day(27).Add(-3 * time.Second), Daily: true},
+		{Chain: "testchain", Address: address, Date: day(28), Height: 20, BlockTime: day(28).Add(-3 * time.Second), Daily: true},
+		// Taken at the wrong height, half a day early.
+		{Chain: "testchain", Address: address, Date: day(30), Height: 30, BlockTime: day(29).Add(12 * time.Hour), Daily: true},
+	} {
+		if err := store.Put(ctx, snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := checkSeries(ctx, store, "testchain", address, day(26), day(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 5 || len(report.Missing) != 2 || len(report.Errored) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if !report.Missing[0].Equal(day(26)) || !report.Missing[1].Equal(day(29)) || !report.Errored[0].Date.Equal(day(30)) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	report, err = repairSeries(ctx, store, "testchain", address, day(26), day(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Repaired) != 2 || !report.Repaired[0].Equal(day(29)) || !report.Repaired[1].Equal(day(30)) {
+		t.Errorf("expected the 29th and 30th to be repaired, got %v", report.Repaired)
+	}
+	if len(report.Unrecoverable) != 1 || !report.Unrecoverable[0].Date.Equal(day(26)) {
+		t.Errorf("expected the 26th to be unrecoverable, got %+v", report.Unrecoverable)
+	}
+
+	for d, expected := range map[int]string{29: "3800utest", 30: "3900utest"} {
+		snapshot, ok, err := store.Daily(ctx, "testchain", address, day(d))
+		if err != nil || !ok {
+			t.Fatalf("expected a snapshot of the %dth, got %v, %v", d, ok, err)
+		}
+		if got := snapshot.Balances[COSMOSSDK_BANK_BALANCE].String(); got != expected {
+			t.Errorf("expected %s on the %dth, got %s", expected, d, got)
+		}
+	}
+
+	report, err = checkSeries(ctx, store, "testchain", address, day(27), day(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) != 0 || len(report.Errored) != 0 {
+		t.Errorf("expected a consistent series after the repair, got %+v", report)
+	}
+}
+
+func TestRepairSeriesRecheck(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	day := time.Date(2024, 10, 30, 0, 0, 0, 0, time.UTC)
+
+	// The chain halted from 22:00 the day before to 2:00, so the last block
+	// before the day is too old for a daily snapshot.
+	chain := fakenode.NewChain("testchain-1", e2eGenesis, 6*time.Second)
+	chain.Halt(chain.HeightAt(day.Add(-2*time.Hour)), 4*time.Hour)
+	chain.SetLatestHeight(chain.HeightAt(e2eNow))
+	startFakeNode(t, chain)
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	report, err := repairSeries(ctx, store, "testchain", address, day.Add(-24*time.Hour), day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Repaired) != 1 || !report.Repaired[0].Equal(day.Add(-24*time.Hour)) {
+		t.Errorf("expected the 29th to be repaired, got %v", report.Repaired)
+	}
+	if len(report.Unrecoverable) != 1 || !report.Unrecoverable[0].Date.Equal(day) {
+		t.Fatalf("expected the 30th to be unrecoverable, got %+v", report.Unrecoverable)
+	}
+	if reason := report.Unrecoverable[0].Reason; !strings.Contains(reason, "too long before") {
+		t.Errorf("expected the 30th to be too long before the start of the day, got %s", reason)
+	}
+}
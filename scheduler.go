@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	log "github.com/xlab/suplog"
+	"sort"
+	"sync"
+	"time"
+)
+
+var DEFAULT_CATCH_UP_DAYS = 7
+
+var DEFAULT_RETRY_INTERVAL = time.Minute
+
+// SLOT_SETTLE_DELAY leaves time for the first block after a slot to be
+// produced, since the height at a slot is that of the last block before it.
+var SLOT_SETTLE_DELAY = 30 * time.Second
+
+// MAX_RETRY_INTERVAL caps the backoff between retries of a failed slot.
+var MAX_RETRY_INTERVAL = time.Hour
+
+// slot is a snapshot of an address the scheduler still has to take.
+type slot struct {
+	chain   string
+	address string
+	at      time.Time
+	daily   bool
+	// repair slots are taken through the archive endpoint, and given up
+	// after BACKFILL_ATTEMPTS.
+	repair    bool
+	attempts  int
+	notBefore time.Time
+}
+
+// Scheduler takes snapshots of the watched addresses at the start of every
+// day and on the configured schedule, stores them, and retries slots it
+// missed until they succeed.
+type Scheduler struct {
+	store         SnapshotStore
+	schedule      cron.Schedule
+	addresses     []WatchedAddress
+	catchUpDays   int
+	retryInterval time.Duration
+	repairDays    int
+	now           func() time.Time
+
+	mtx     sync.Mutex
+	pending []slot
+}
+
+func NewScheduler(config WatchConfig, store SnapshotStore) (*Scheduler, error) {
+	s := &Scheduler{
+		store:         store,
+		addresses:     config.Addresses,
+		catchUpDays:   config.CatchUpDays,
+		retryInterval: config.RetryInterval,
+		repairDays:    config.RepairDays,
+		now:           time.Now,
+	}
+	if s.catchUpDays == 0 {
+		s.catchUpDays = DEFAULT_CATCH_UP_DAYS
+	}
+	if s.retryInterval == 0 {
+		s.retryInterval = DEFAULT_RETRY_INTERVAL
+	}
+
+	if config.Schedule != "" {
+		schedule, err := cron.ParseStandard(config.Schedule)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid schedule")
+		}
+		s.schedule = schedule
+	}
+
+	return s, nil
+}
+
+// Run takes snapshots until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for _, watched := range s.addresses {
+		latest, ok, err := s.store.Latest(ctx, watched.Chain, watched.Address)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the snapshots of %s on %s", watched.Address, watched.Chain)
+		}
+		if ok {
+			observeSnapshot(latest)
+		}
+	}
+
+	if err := s.catchUp(ctx); err != nil {
+		return err
+	}
+	s.repair(ctx)
+
+	now := s.now().UTC()
+	nextDay := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	var nextTick time.Time
+	if s.schedule != nil {
+		nextTick = s.schedule.Next(now)
+	}
+
+	for {
+		s.runDue(ctx)
+
+		wake := nextDay
+		if !nextTick.IsZero() && nextTick.Before(wake) {
+			wake = nextTick
+		}
+		if next, ok := s.nextRetry(); ok && next.Before(wake) {
+			wake = next
+		}
+
+		timer := time.NewTimer(wake.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		now = s.now().UTC()
+		if !nextDay.After(now) {
+			for !nextDay.After(now) {
+				s.enqueue(nextDay, true)
+				nextDay = nextDay.Add(24 * time.Hour)
+			}
+			s.repair(ctx)
+		}
+		for !nextTick.IsZero() && !nextTick.After(now) {
+			// The daily snapshot already covers the start of the day.
+			if !nextTick.Equal(nextTick.Truncate(24 * time.Hour)) {
+				s.enqueue(nextTick, false)
+			}
+			nextTick = s.schedule.Next(now)
+		}
+	}
+}
+
+// catchUp enqueues the daily snapshots of the last catchUpDays days that are
+// not stored yet.
+func (s *Scheduler) catchUp(ctx context.Context) error {
+	today := s.now().UTC().Truncate(24 * time.Hour)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, watched := range s.addresses {
+		for day := today.AddDate(0, 0, -s.catchUpDays+1); !day.After(today); day = day.Add(24 * time.Hour) {
+			_, ok, err := s.store.Daily(ctx, watched.Chain, watched.Address, day)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read the snapshots of %s on %s", watched.Address, watched.Chain)
+			}
+			if ok {
+				continue
+			}
+
+			s.pending = append(s.pending, slot{
+				chain:     watched.Chain,
+				address:   watched.Address,
+				at:        day,
+				daily:     true,
+				notBefore: day.Add(SLOT_SETTLE_DELAY),
+			})
+		}
+	}
+	return nil
+}
+
+// repair enqueues the missing and errored daily snapshots of the repairDays
+// days before today.
+func (s *Scheduler) repair(ctx context.Context) {
+	if s.repairDays == 0 {
+		return
+	}
+	today := s.now().UTC().Truncate(24 * time.Hour)
+
+	for _, watched := range s.addresses {
+		report, err := checkSeries(ctx, s.store, watched.Chain, watched.Address, today.AddDate(0, 0, -s.repairDays), today.AddDate(0, 0, -1))
+		if err != nil {
+			log.WithError(err).Warningf("failed to check the snapshots of %s on %s", watched.Address, watched.Chain)
+			continue
+		}
+
+		s.mtx.Lock()
+	days:
+		for _, day := range report.Broken() {
+			for _, sl := range s.pending {
+				if sl.daily && sl.chain == watched.Chain && sl.address == watched.Address && sl.at.Equal(day) {
+					continue days
+				}
+			}
+			s.pending = append(s.pending, slot{
+				chain:     watched.Chain,
+				address:   watched.Address,
+				at:        day,
+				daily:     true,
+				repair:    true,
+				notBefore: s.now(),
+			})
+		}
+		s.mtx.Unlock()
+	}
+}
+
+func (s *Scheduler) enqueue(at time.Time, daily bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, watched := range s.addresses {
+		s.pending = append(s.pending, slot{
+			chain:     watched.Chain,
+			address:   watched.Address,
+			at:        at,
+			daily:     daily,
+			notBefore: at.Add(SLOT_SETTLE_DELAY),
+		})
+	}
+}
+
+func (s *Scheduler) nextRetry() (time.Time, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var next time.Time
+	for _, sl := range s.pending {
+		if next.IsZero() || sl.notBefore.Before(next) {
+			next = sl.notBefore
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// runDue takes the snapshots whose slot has come, oldest first, and
+// reschedules the failed ones with exponential backoff. A slot whose height
+// the node pruned is dropped, since retrying can't bring it back.
+func (s *Scheduler) runDue(ctx context.Context) {
+	s.mtx.Lock()
+	now := s.now()
+	var due, waiting []slot
+	for _, sl := range s.pending {
+		if sl.notBefore.After(now) {
+			waiting = append(waiting, sl)
+		} else {
+			due = append(due, sl)
+		}
+	}
+	s.pending = waiting
+	s.mtx.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+
+	for _, sl := range due {
+		if ctx.Err() != nil {
+			s.retry(sl)
+			continue
+		}
+
+		if err := s.take(ctx, sl); err != nil {
+			sl.attempts++
+			if isPrunedError(err) || (sl.repair && sl.attempts >= BACKFILL_ATTEMPTS) {
+				log.WithError(err).Errorf("the snapshot of %s on %s at %s is unrecoverable", sl.address, sl.chain, sl.at.Format(time.RFC3339))
+				continue
+			}
+			log.WithError(err).Warningf("failed to take the snapshot of %s on %s at %s, attempt %d", sl.address, sl.chain, sl.at.Format(time.RFC3339), sl.attempts)
+
+			backoff := s.retryInterval << min(sl.attempts-1, 16)
+			if backoff > MAX_RETRY_INTERVAL {
+				backoff = MAX_RETRY_INTERVAL
+			}
+			sl.notBefore = s.now().Add(backoff)
+			s.retry(sl)
+		}
+	}
+}
+
+func (s *Scheduler) retry(sl slot) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.pending = append(s.pending, sl)
+}
+
+// take snapshots the balances of the slot's address at the last block at or
+// before the slot.
+func (s *Scheduler) take(ctx context.Context, sl slot) error {
+	if sl.repair {
+		ctx = withArchive(ctx)
+	}
+	snapshot, err := takeSnapshot(ctx, s.store, sl.chain, sl.address, sl.at, sl.daily)
+	if err != nil {
+		return err
+	}
+	if observeSnapshot(snapshot) && alertEngine != nil {
+		alertEngine.Evaluate(ctx, snapshot)
+	}
+	return nil
+}
+
+// takeSnapshot stores the balances of address at the last block at or before
+// at, see resolveHeight, as the daily snapshot of that day when daily is set.
+func takeSnapshot(ctx context.Context, store SnapshotStore, chain, address string, at time.Time, daily bool) (Snapshot, error) {
+	height, err := resolveHeight(ctx, chain, at)
+	if err != nil {
+		return Snapshot{}, errors.Wrap(err, "failed to resolve height")
+	}
+
+	snapshot, err := collectCompleteSnapshot(ctx, chain, address, height)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if daily {
+		snapshot.Date = at
+		snapshot.Daily = true
+	}
+
+	return snapshot, store.Put(ctx, snapshot)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerCatchUp(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	startFakeNode(t, dailyBalanceChain(t, address))
+
+	store := NewMemoryStore()
+	// The 31st is stored already and must not be taken again.
+	stored := Snapshot{
+		Chain:     "testchain",
+		Address:   address,
+		Date:      time.Date(2024, 10, 31, 0, 0, 0, 0, time.UTC),
+		Height:    1,
+		BlockTime: time.Date(2024, 10, 31, 0, 0, 0, 0, time.UTC),
+		Daily:     true,
+	}
+	if err := store.Put(context.Background(), stored); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler, err := NewScheduler(WatchConfig{
+		CatchUpDays: 3,
+		Addresses:   []WatchedAddress{{Chain: "testchain", Address: address}},
+	}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.now = func() time.Time { return e2eNow }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- scheduler.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		series, err := store.Series(ctx, "testchain", address, e2eGenesis, e2eNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(series) == 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for day, expected := range map[int]string{30: "3900utest", 31: "", 1: "4100utest"} {
+		date := time.Date(2024, 10, day, 0, 0, 0, 0, time.UTC)
+		if day == 1 {
+			date = time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+		}
+		snapshot, ok, err := store.Daily(context.Background(), "testchain", address, date)
+		if err != nil || !ok {
+			t.Fatalf("%s: no daily snapshot: %v", date.Format(time.DateOnly), err)
+		}
+		if got := snapshot.Balances[COSMOSSDK_BANK_BALANCE].String(); got != expected {
+			t.Errorf("%s: expected bank balance %q, got %q", date.Format(time.DateOnly), expected, got)
+		}
+	}
+}
+
+func TestSchedulerDropsPrunedSlots(t *testing.T) {
+	address := e2eAddress(t, "cosmos", 1)
+	chain := dailyBalanceChain(t, address)
+	chain.Prune(chain.HeightAt(time.Date(2024, 10, 25, 0, 0, 0, 0, time.UTC)))
+	chain.FailQuery("/cosmos.bank.v1beta1.Query/AllBalances", "internal error")
+	startFakeNode(t, chain)
+
+	scheduler, err := NewScheduler(WatchConfig{Addresses: []WatchedAddress{{Chain: "testchain", Address: address}}}, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.now = func() time.Time { return e2eNow }
+
+	pruned := slot{chain: "testchain", address: address, at: time.Date(2024, 10, 20, 0, 0, 0, 0, time.UTC), daily: true}
+	failing := slot{chain: "testchain", address: address, at: time.Date(2024, 10, 30, 0, 0, 0, 0, time.UTC), daily: true}
+	scheduler.pending = []slot{pruned, failing}
+	scheduler.runDue(context.Background())
+
+	// The pruned day can't be taken anymore, the failing one is retried.
+	if len(scheduler.pending) != 1 || !scheduler.pending[0].at.Equal(failing.at) || scheduler.pending[0].attempts != 1 {
+		t.Errorf("expected only the failing slot to be retried, got %+v", scheduler.pending)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+// Secret is a configuration value that is either written inline or loaded
+// from an environment variable or a file:
+//
+//	apiKey: plain-value
+//	apiKey: {env: RPC_API_KEY}
+//	apiKey: {file: /run/secrets/rpc-api-key}
+type Secret struct {
+	Value string `yaml:"value"`
+	Env   string `yaml:"env"`
+	File  string `yaml:"file"`
+}
+
+func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*s = Secret{Value: value}
+		return nil
+	}
+
+	type plain Secret
+	return unmarshal((*plain)(s))
+}
+
+func (s Secret) IsZero() bool {
+	return s.Value == "" && s.Env == "" && s.File == ""
+}
+
+// Resolve returns the secret's value, reading the environment or file it
+// points to.
+func (s Secret) Resolve() (string, error) {
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", errors.Errorf("environment variable %s is not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		b, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read secret file %s", s.File)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	default:
+		return s.Value, nil
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretResolve(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api-key")
+	if err := os.WriteFile(path, []byte("from-file\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET", "from-env")
+	t.Setenv("TEST_SECRET_EMPTY", "")
+
+	for _, tc := range []struct {
+		name     string
+		secret   Secret
+		expected string
+		fails    bool
+	}{
+		{name: "inline", secret: Secret{Value: "inline"}, expected: "inline"},
+		{name: "env", secret: Secret{Env: "TEST_SECRET"}, expected: "from-env"},
+		{name: "empty env", secret: Secret{Env: "TEST_SECRET_EMPTY"}, expected: ""},
+		{name: "unset env", secret: Secret{Env: "TEST_SECRET_UNSET"}, fails: true},
+		// The file's trailing newline is not part of the secret.
+		{name: "file", secret: Secret{File: path}, expected: "from-file"},
+		{name: "missing file", secret: Secret{File: filepath.Join(dir, "missing")}, fails: true},
+		// env takes precedence over the other forms.
+		{name: "env and value", secret: Secret{Value: "inline", Env: "TEST_SECRET"}, expected: "from-env"},
+	} {
+		value, err := tc.secret.Resolve()
+		if tc.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", tc.name, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		} else if value != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, value)
+		}
+	}
+}
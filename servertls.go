@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CERT_CHECK_INTERVAL is how often, at most, the certificate files are
+// checked for changes.
+var CERT_CHECK_INTERVAL = 10 * time.Second
+
+// certReloader holds the certificate and client CAs of the server, read
+// again from their files on a handshake once they changed.
+type certReloader struct {
+	config *ServerTLSConfig
+
+	mtx       sync.Mutex
+	checkedAt time.Time
+	modTimes  []time.Time
+	tlsConfig *tls.Config
+}
+
+// newServerTLSConfig returns the TLS configuration of the server, which
+// reloads the files of config as they change.
+func newServerTLSConfig(config *ServerTLSConfig) (*tls.Config, error) {
+	r := &certReloader{config: config}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.getConfigForClient,
+	}, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if time.Since(r.checkedAt) >= CERT_CHECK_INTERVAL {
+		r.checkedAt = time.Now()
+		if modTimes, err := r.stat(); err == nil && !slices.EqualFunc(modTimes, r.modTimes, time.Time.Equal) {
+			// Keep serving the previous certificate until the new one loads,
+			// e.g. while the certificate and key are written one by one.
+			if err := r.loadLocked(); err != nil {
+				log.WithError(err).Warningln("failed to reload the server certificate")
+			} else {
+				log.Infoln("reloaded the server certificate")
+			}
+		}
+	}
+	return r.tlsConfig, nil
+}
+
+func (r *certReloader) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+func (r *certReloader) load() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.checkedAt = time.Now()
+	return r.loadLocked()
+}
+
+func (r *certReloader) loadLocked() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to read the certificate files")
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load the server certificate")
+	}
+	// ListenAndServeTLS offers h2 on the server's config only, not on the one
+	// returned for each client.
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if r.config.ClientCAFile != "" {
+		b, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read clientCAFile")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.Errorf("no certificates found in %s", r.config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		if len(r.config.AllowedSubjects) > 0 {
+			allowed := r.config.AllowedSubjects
+			tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+				subject := state.PeerCertificates[0].Subject
+				if slices.Contains(allowed, subject.CommonName) || slices.Contains(allowed, subject.String()) {
+					return nil
+				}
+				return errors.Errorf("client certificate subject %s is not allowed", subject)
+			}
+		}
+	}
+
+	r.modTimes = modTimes
+	r.tlsConfig = tlsConfig
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate issues a certificate for name, signed by parent or
+// self-signed when parent is nil.
+func testCertificate(t *testing.T, serial int64, name string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	parentCert, parentKey := template, interface{}(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeCertificate(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	t.Helper()
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	interval := CERT_CHECK_INTERVAL
+	CERT_CHECK_INTERVAL = 0
+	t.Cleanup(func() { CERT_CHECK_INTERVAL = interval })
+
+	dir := t.TempDir()
+	config := &ServerTLSConfig{
+		CertFile:        filepath.Join(dir, "server.pem"),
+		KeyFile:         filepath.Join(dir, "server-key.pem"),
+		ClientCAFile:    filepath.Join(dir, "ca.pem"),
+		AllowedSubjects: []string{"accounting"},
+	}
+
+	ca := testCertificate(t, 1, "test ca", true, nil)
+	writeCertificate(t, ca, config.ClientCAFile, "")
+	writeCertificate(t, testCertificate(t, 2, "server", false, &ca), config.CertFile, config.KeyFile)
+
+	tlsConfig, err := newServerTLSConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), TLSConfig: tlsConfig}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	get := func(client *tls.Certificate) (*http.Response, error) {
+		clientConfig := &tls.Config{RootCAs: roots}
+		if client != nil {
+			clientConfig.Certificates = []tls.Certificate{*client}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig, DisableKeepAlives: true, ForceAttemptHTTP2: true}}
+		res, err := c.Get("https://" + ln.Addr().String())
+		if err == nil {
+			res.Body.Close()
+		}
+		return res, err
+	}
+
+	accounting := testCertificate(t, 3, "accounting", false, &ca)
+	res, err := get(&accounting)
+	if err != nil {
+		t.Fatalf("expected an allowed client to connect: %s", err)
+	}
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Errorf("expected the certificate 2, got %d", serial)
+	}
+	if res.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", res.Proto)
+	}
+
+	if _, err := get(nil); err == nil {
+		t.Error("expected a client without certificate to be rejected")
+	}
+	other := testCertificate(t, 4, "other", false, &ca)
+	if _, err := get(&other); err == nil {
+		t.Error("expected a client with another subject to be rejected")
+	}
+	stranger := testCertificate(t, 5, "accounting", false, nil)
+	if _, err := get(&stranger); err == nil {
+		t.Error("expected a client certificate of another CA to be rejected")
+	}
+
+	// Rotate the server certificate.
+	writeCertificate(t, testCertificate(t, 6, "server", false, &ca), config.CertFile, config.KeyFile)
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{config.CertFile, config.KeyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err = get(&accounting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 6 {
+		t.Errorf("expected the rotated certificate 6, got %d", serial)
+	}
+}
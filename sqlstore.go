@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"cosmossdk.io/math"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/cosmos/cosmos-sdk/types"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	STORE_MEMORY   = "memory"
+	STORE_SQLITE   = "sqlite"
+	STORE_POSTGRES = "postgres"
+)
+
+// sqlDialect holds what differs between the SQL databases a SQLStore runs on.
+type sqlDialect struct {
+	driver string
+	// numbered placeholders ($1, $2...) instead of ?.
+	numbered bool
+	// cast is appended to a column read as text, for columns of other types.
+	cast string
+	// migrations are applied in order; their index + 1 is the schema version.
+	migrations []string
+}
+
+var sqliteDialect = sqlDialect{
+	driver: "sqlite",
+	migrations: []string{
+		`CREATE TABLE snapshots (
+			chain TEXT NOT NULL,
+			address TEXT NOT NULL,
+			height INTEGER NOT NULL,
+			block_time TIMESTAMP NOT NULL,
+			date TIMESTAMP NOT NULL,
+			daily BOOLEAN NOT NULL,
+			collected_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (chain, address, height)
+		);
+		CREATE INDEX snapshots_block_time ON snapshots (chain, address, block_time);
+		CREATE INDEX snapshots_daily ON snapshots (chain, address, date) WHERE daily;
+		CREATE TABLE balances (
+			chain TEXT NOT NULL,
+			address TEXT NOT NULL,
+			height INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			denom TEXT NOT NULL,
+			amount TEXT NOT NULL,
+			PRIMARY KEY (chain, address, height, source, denom),
+			FOREIGN KEY (chain, address, height) REFERENCES snapshots ON DELETE CASCADE
+		);`,
+		`CREATE TABLE backfill_checkpoints (
+			chain TEXT NOT NULL,
+			address TEXT NOT NULL,
+			from_date TIMESTAMP NOT NULL,
+			to_date TIMESTAMP NOT NULL,
+			next_date TIMESTAMP NOT NULL,
+			stored INTEGER NOT NULL,
+			skipped INTEGER NOT NULL,
+			gaps TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (chain, address)
+		);`,
+		`CREATE TABLE firing_alerts (
+			alert_key TEXT NOT NULL PRIMARY KEY,
+			fired_at TIMESTAMP NOT NULL
+		);`,
+	},
+}
+
+var postgresDialect = sqlDialect{
+	driver:   "pgx",
+	numbered: true,
+	cast:     "::text",
+	migrations: []string{
+		`CREATE TABLE snapshots (
+			chain TEXT NOT NULL,
+			address TEXT NOT NULL,
+			height BIGINT NOT NULL,
+			block_time TIMESTAMPTZ NOT NULL,
+			date TIMESTAMPTZ NOT NULL,
+			daily BOOLEAN NOT NULL,
+			collected_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (chain, address, height)
+		);
+		CREATE INDEX snapshots_block_time ON snapshots (chain, address, block_time);
+		CREATE INDEX snapshots_daily ON snapshots (chain, address, date) WHERE daily;
+		CREATE TABLE balances (
+			chain TEXT NOT NULL,
+			address TEXT NOT NULL,
+			height BIGINT NOT NULL,
+			source TEXT NOT NULL,
+			denom TEXT NOT NULL,
+			amount NUMERIC(78, 0) NOT NULL,
+			PRIMARY KEY (chain, address, height, source, denom),
+			FOREIGN KEY (chain, address, height) REFERENCES snapshots ON DELETE CASCADE
+		);`,
+		`CREATE TABLE backfill_checkpoints (
+			chain TEXT NOT NULL,
+			address TEXT NOT NULL,
+			from_date TIMESTAMPTZ NOT NULL,
+			to_date TIMESTAMPTZ NOT NULL,
+			next_date TIMESTAMPTZ NOT NULL,
+			stored INTEGER NOT NULL,
+			skipped INTEGER NOT NULL,
+			gaps JSONB NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (chain, address)
+		);`,
+		`CREATE TABLE firing_alerts (
+			alert_key TEXT NOT NULL PRIMARY KEY,
+			fired_at TIMESTAMPTZ NOT NULL
+		);`,
+	},
+}
+
+// text reads column as text.
+func (d sqlDialect) text(column string) string {
+	return column + d.cast
+}
+
+// rebind rewrites the ? placeholders of query for the dialect.
+func (d sqlDialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SQLStore is a SnapshotStore in a SQLite or PostgreSQL database. Each
+// snapshot is a row of the snapshots table and each of its denoms a row of
+// the balances table, so that history can be queried with SQL.
+type SQLStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLiteStore opens the SQLite database at path, creating it if needed.
+func NewSQLiteStore(ctx context.Context, path string) (*SQLStore, error) {
+	dsn := fmt.Sprintf("file:%s?_time_format=sqlite&_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	return openSQLStore(ctx, sqliteDialect, dsn)
+}
+
+// NewPostgresStore connects to the PostgreSQL database at dsn, a connection
+// string or URL.
+func NewPostgresStore(ctx context.Context, dsn string) (*SQLStore, error) {
+	return openSQLStore(ctx, postgresDialect, dsn)
+}
+
+func openSQLStore(ctx context.Context, dialect sqlDialect, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the database")
+	}
+	if dialect.driver == sqliteDialect.driver {
+		// SQLite serializes writers anyway; one connection avoids busy errors.
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &SQLStore{db: db, dialect: dialect}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate brings the schema to the latest version, one transaction per
+// migration.
+func (s *SQLStore) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the schema_migrations table")
+	}
+
+	var version int
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the schema version")
+	}
+	if version > len(s.dialect.migrations) {
+		return errors.Errorf("schema version %d is newer than this collector supports", version)
+	}
+
+	for i := version; i < len(s.dialect.migrations); i++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, s.dialect.migrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), i+1, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate the schema to version %d", i+1)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Put(ctx context.Context, snapshot Snapshot) error {
+	collectedAt := snapshot.CollectedAt
+	if collectedAt.IsZero() {
+		collectedAt = time.Now()
+	}
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if snapshot.Daily {
+			_, err := tx.ExecContext(ctx, s.dialect.rebind(`
+				UPDATE snapshots SET daily = ?
+				WHERE chain = ? AND address = ? AND daily AND date = ? AND height <> ?`),
+				false, snapshot.Chain, snapshot.Address, snapshot.Date.UTC(), snapshot.Height)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO snapshots (chain, address, height, block_time, date, daily, collected_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (chain, address, height) DO UPDATE SET
+				block_time = excluded.block_time,
+				date = excluded.date,
+				daily = excluded.daily,
+				collected_at = excluded.collected_at`),
+			snapshot.Chain, snapshot.Address, snapshot.Height,
+			snapshot.BlockTime.UTC(), snapshot.Date.UTC(), snapshot.Daily, collectedAt.UTC())
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM balances WHERE chain = ? AND address = ? AND height = ?`),
+			snapshot.Chain, snapshot.Address, snapshot.Height)
+		if err != nil {
+			return err
+		}
+
+		for source, coins := range snapshot.Balances {
+			for _, coin := range coins {
+				_, err := tx.ExecContext(ctx, s.dialect.rebind(`
+					INSERT INTO balances (chain, address, height, source, denom, amount)
+					VALUES (?, ?, ?, ?, ?, ?)`),
+					snapshot.Chain, snapshot.Address, snapshot.Height, source.String(), coin.Denom, coin.Amount.String())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return errors.Wrapf(err, "failed to store the snapshot of %s on %s at height %d", snapshot.Address, snapshot.Chain, snapshot.Height)
+}
+
+func (s *SQLStore) Series(ctx context.Context, chain, address string, from, to time.Time) ([]Snapshot, error) {
+	return s.query(ctx, `WHERE chain = ? AND address = ? AND block_time >= ? AND block_time < ? ORDER BY height`,
+		chain, address, from.UTC(), to.UTC())
+}
+
+func (s *SQLStore) Latest(ctx context.Context, chain, address string) (Snapshot, bool, error) {
+	snapshots, err := s.query(ctx, `WHERE chain = ? AND address = ? ORDER BY height DESC LIMIT 1`, chain, address)
+	if err != nil || len(snapshots) == 0 {
+		return Snapshot{}, false, err
+	}
+	return snapshots[0], true, nil
+}
+
+func (s *SQLStore) Daily(ctx context.Context, chain, address string, day time.Time) (Snapshot, bool, error) {
+	snapshots, err := s.query(ctx, `WHERE chain = ? AND address = ? AND daily AND date = ? ORDER BY height LIMIT 1`,
+		chain, address, day.UTC())
+	if err != nil || len(snapshots) == 0 {
+		return Snapshot{}, false, err
+	}
+	return snapshots[0], true, nil
+}
+
+// query reads the snapshots selected by where, a clause on the snapshots
+// table, along with their balances.
+func (s *SQLStore) query(ctx context.Context, where string, args ...interface{}) ([]Snapshot, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`
+		SELECT chain, address, height, block_time, date, daily, collected_at
+		FROM snapshots `+where), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query snapshots")
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var snapshot Snapshot
+		err := rows.Scan(&snapshot.Chain, &snapshot.Address, &snapshot.Height,
+			&snapshot.BlockTime, &snapshot.Date, &snapshot.Daily, &snapshot.CollectedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read a snapshot")
+		}
+		snapshot.BlockTime = snapshot.BlockTime.UTC()
+		snapshot.Date = snapshot.Date.UTC()
+		snapshot.CollectedAt = snapshot.CollectedAt.UTC()
+		snapshots = append(snapshots, snapshot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to query snapshots")
+	}
+
+	for i := range snapshots {
+		balances, err := s.balances(ctx, snapshots[i].Chain, snapshots[i].Address, snapshots[i].Height)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[i].Balances = balances
+	}
+	return snapshots, nil
+}
+
+func (s *SQLStore) balances(ctx context.Context, chain, address string, height int64) (map[BalanceSource]types.Coins, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`
+		SELECT source, denom, `+s.dialect.text("amount")+`
+		FROM balances WHERE chain = ? AND address = ? AND height = ?
+		ORDER BY source, denom`), chain, address, height)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query balances")
+	}
+	defer rows.Close()
+
+	// Every source was queried, even those holding nothing.
+	var result = make(map[BalanceSource]types.Coins)
+	for source := range methods {
+		result[source] = types.Coins{}
+	}
+	for rows.Next() {
+		var name, denom, amount string
+		if err := rows.Scan(&name, &denom, &amount); err != nil {
+			return nil, errors.Wrap(err, "failed to read a balance")
+		}
+		source, err := parseBalanceSource(name)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := math.NewIntFromString(amount)
+		if !ok {
+			return nil, errors.Errorf("invalid amount %s of %s at height %d", amount, denom, height)
+		}
+		result[source] = append(result[source], types.NewCoin(denom, value))
+	}
+	return result, errors.Wrap(rows.Err(), "failed to query balances")
+}
+
+func (s *SQLStore) PutCheckpoint(ctx context.Context, checkpoint BackfillCheckpoint) error {
+	gaps, err := json.Marshal(checkpoint.Gaps)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO backfill_checkpoints (chain, address, from_date, to_date, next_date, stored, skipped, gaps, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chain, address) DO UPDATE SET
+			from_date = excluded.from_date,
+			to_date = excluded.to_date,
+			next_date = excluded.next_date,
+			stored = excluded.stored,
+			skipped = excluded.skipped,
+			gaps = excluded.gaps,
+			updated_at = excluded.updated_at`),
+		checkpoint.Chain, checkpoint.Address, checkpoint.From.UTC(), checkpoint.To.UTC(), checkpoint.Next.UTC(),
+		checkpoint.Stored, checkpoint.Skipped, string(gaps), checkpoint.UpdatedAt.UTC())
+	return errors.Wrapf(err, "failed to store the backfill checkpoint of %s on %s", checkpoint.Address, checkpoint.Chain)
+}
+
+func (s *SQLStore) Checkpoint(ctx context.Context, chain, address string) (BackfillCheckpoint, bool, error) {
+	var (
+		checkpoint = BackfillCheckpoint{Chain: chain, Address: address}
+		gaps       string
+	)
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`
+		SELECT from_date, to_date, next_date, stored, skipped, `+s.dialect.text("gaps")+`, updated_at
+		FROM backfill_checkpoints WHERE chain = ? AND address = ?`), chain, address).
+		Scan(&checkpoint.From, &checkpoint.To, &checkpoint.Next, &checkpoint.Stored, &checkpoint.Skipped, &gaps, &checkpoint.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return BackfillCheckpoint{}, false, nil
+	}
+	if err != nil {
+		return BackfillCheckpoint{}, false, errors.Wrap(err, "failed to read the backfill checkpoint")
+	}
+	if err := json.Unmarshal([]byte(gaps), &checkpoint.Gaps); err != nil {
+		return BackfillCheckpoint{}, false, errors.Wrap(err, "failed to read the backfill gaps")
+	}
+
+	checkpoint.From = checkpoint.From.UTC()
+	checkpoint.To = checkpoint.To.UTC()
+	checkpoint.Next = checkpoint.Next.UTC()
+	checkpoint.UpdatedAt = checkpoint.UpdatedAt.UTC()
+	return checkpoint, true, nil
+}
+
+func (s *SQLStore) PutAlert(ctx context.Context, key string, firing bool) error {
+	var err error
+	if firing {
+		_, err = s.db.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO firing_alerts (alert_key, fired_at) VALUES (?, ?)
+			ON CONFLICT (alert_key) DO NOTHING`), key, time.Now().UTC())
+	} else {
+		_, err = s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM firing_alerts WHERE alert_key = ?`), key)
+	}
+	return errors.Wrapf(err, "failed to store the state of the alert %s", key)
+}
+
+func (s *SQLStore) FiringAlerts(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT alert_key FROM firing_alerts ORDER BY alert_key`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query the firing alerts")
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, errors.Wrap(err, "failed to read a firing alert")
+		}
+		keys = append(keys, key)
+	}
+	return keys, errors.Wrap(rows.Err(), "failed to query the firing alerts")
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	log "github.com/xlab/suplog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// snapshotStore keeps the snapshots taken by the scheduler. It is nil unless
+// addresses are watched.
+var snapshotStore SnapshotStore
+
+// SnapshotStore persists snapshots of watched addresses.
+type SnapshotStore interface {
+	// Put stores snapshot, replacing any snapshot of the same address at the
+	// same height. A daily snapshot supersedes the previous daily snapshot of
+	// its day, which is kept as an ordinary one.
+	Put(ctx context.Context, snapshot Snapshot) error
+	// Series returns the snapshots of address on chain whose block time is in
+	// [from, to), ordered by height.
+	Series(ctx context.Context, chain, address string, from, to time.Time) ([]Snapshot, error)
+	// Latest returns the snapshot of address on chain with the highest height.
+	Latest(ctx context.Context, chain, address string) (Snapshot, bool, error)
+	// Daily returns the snapshot taken at the start of day.
+	Daily(ctx context.Context, chain, address string, day time.Time) (Snapshot, bool, error)
+	// PutCheckpoint stores the progress of the backfill of an address,
+	// replacing the previous one.
+	PutCheckpoint(ctx context.Context, checkpoint BackfillCheckpoint) error
+	// Checkpoint returns the progress of the latest backfill of address.
+	Checkpoint(ctx context.Context, chain, address string) (BackfillCheckpoint, bool, error)
+	// PutAlert records whether the alert with key is firing, see alertKey.
+	PutAlert(ctx context.Context, key string, firing bool) error
+	// FiringAlerts returns the keys of the alerts firing.
+	FiringAlerts(ctx context.Context) ([]string, error)
+	Close() error
+}
+
+// MemoryStore is a SnapshotStore that lives as long as the process.
+type MemoryStore struct {
+	mtx         sync.RWMutex
+	snapshots   map[string][]Snapshot
+	checkpoints map[string]BackfillCheckpoint
+	alerts      map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		snapshots:   make(map[string][]Snapshot),
+		checkpoints: make(map[string]BackfillCheckpoint),
+		alerts:      make(map[string]bool),
+	}
+}
+
+func memoryStoreKey(chain, address string) string {
+	return chain + "/" + address
+}
+
+func (s *MemoryStore) Put(ctx context.Context, snapshot Snapshot) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := memoryStoreKey(snapshot.Chain, snapshot.Address)
+	series := s.snapshots[key]
+	if snapshot.Daily {
+		for i := range series {
+			if series[i].Daily && series[i].Date.Equal(snapshot.Date) {
+				series[i].Daily = false
+			}
+		}
+	}
+	i := sort.Search(len(series), func(i int) bool { return series[i].Height >= snapshot.Height })
+	if i < len(series) && series[i].Height == snapshot.Height {
+		series[i] = snapshot
+		return nil
+	}
+
+	series = append(series, Snapshot{})
+	copy(series[i+1:], series[i:])
+	series[i] = snapshot
+	s.snapshots[key] = series
+	return nil
+}
+
+func (s *MemoryStore) Series(ctx context.Context, chain, address string, from, to time.Time) ([]Snapshot, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var result []Snapshot
+	for _, snapshot := range s.snapshots[memoryStoreKey(chain, address)] {
+		if !snapshot.BlockTime.Before(from) && snapshot.BlockTime.Before(to) {
+			result = append(result, snapshot)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) Latest(ctx context.Context, chain, address string) (Snapshot, bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	series := s.snapshots[memoryStoreKey(chain, address)]
+	if len(series) == 0 {
+		return Snapshot{}, false, nil
+	}
+	return series[len(series)-1], true, nil
+}
+
+func (s *MemoryStore) Daily(ctx context.Context, chain, address string, day time.Time) (Snapshot, bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for _, snapshot := range s.snapshots[memoryStoreKey(chain, address)] {
+		if snapshot.Daily && snapshot.Date.Equal(day) {
+			return snapshot, true, nil
+		}
+	}
+	return Snapshot{}, false, nil
+}
+
+func (s *MemoryStore) PutCheckpoint(ctx context.Context, checkpoint BackfillCheckpoint) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	checkpoint.Gaps = append([]BackfillGap(nil), checkpoint.Gaps...)
+	s.checkpoints[memoryStoreKey(checkpoint.Chain, checkpoint.Address)] = checkpoint
+	return nil
+}
+
+func (s *MemoryStore) Checkpoint(ctx context.Context, chain, address string) (BackfillCheckpoint, bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	checkpoint, ok := s.checkpoints[memoryStoreKey(chain, address)]
+	return checkpoint, ok, nil
+}
+
+func (s *MemoryStore) PutAlert(ctx context.Context, key string, firing bool) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if firing {
+		s.alerts[key] = true
+	} else {
+		delete(s.alerts, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) FiringAlerts(ctx context.Context) ([]string, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var keys = make([]string, 0, len(s.alerts))
+	for key := range s.alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// storedDailySnapshot returns the snapshot the scheduler took at the start of
+// day, so that reports over watched addresses need no RPC calls.
+func storedDailySnapshot(ctx context.Context, chain, address string, day time.Time) (Snapshot, bool) {
+	if snapshotStore == nil {
+		return Snapshot{}, false
+	}
+
+	snapshot, ok, err := snapshotStore.Daily(ctx, chain, address, day)
+	if err != nil {
+		log.WithError(err).Warningf("failed to read the snapshot of %s on %s", address, chain)
+		return Snapshot{}, false
+	}
+	return snapshot, ok
+}
+
+// getSnapshots answers the stored snapshots of an address whose block time is
+// between the "from" and "to" query parameters, each a date or an RFC 3339
+// time, and both optional.
+func getSnapshots(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if snapshotStore == nil {
+		respondError(c, c.Request.Context(), errSnapshotsNotStored)
+		return
+	}
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	var bounds = [2]time.Time{{}, time.Now()}
+	for i, value := range []string{c.Query("from"), c.Query("to")} {
+		if value == "" {
+			continue
+		}
+		parsed, err := parseTime(value)
+		if err != nil {
+			respondError(c, c.Request.Context(), invalidParameter(errors.Wrap(err, "failed to parse time")))
+			return
+		}
+		bounds[i] = parsed
+	}
+	if bounds[1].Before(bounds[0]) {
+		respondError(c, c.Request.Context(), invalidDateRange("to is before from"))
+		return
+	}
+
+	series, err := snapshotStore.Series(c.Request.Context(), chainParam, addressParam, bounds[0], bounds[1])
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+	if series == nil {
+		series = []Snapshot{}
+	}
+
+	respond(c, http.StatusOK, series)
+}
+
+// getLatestSnapshot answers the stored snapshot of an address with the
+// highest height.
+func getLatestSnapshot(c *gin.Context) {
+	chainParam := c.Param("chain")
+	addressParam := c.Param("address")
+
+	if snapshotStore == nil {
+		respondError(c, c.Request.Context(), errSnapshotsNotStored)
+		return
+	}
+	if err := validateAddress(chainParam, addressParam); err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+
+	snapshot, ok, err := snapshotStore.Latest(c.Request.Context(), chainParam, addressParam)
+	if err != nil {
+		respondError(c, c.Request.Context(), err)
+		return
+	}
+	if !ok {
+		respondError(c, c.Request.Context(), notFound("no snapshot of %s is stored", addressParam))
+		return
+	}
+
+	respond(c, http.StatusOK, snapshot)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"cosmossdk.io/math"
+	"github.com/cosmos/cosmos-sdk/types"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshots.db")
+
+	for name, open := range map[string]func() (SnapshotStore, error){
+		"memory": func() (SnapshotStore, error) { return NewMemoryStore(), nil },
+		"sqlite": func() (SnapshotStore, error) { return NewSQLiteStore(context.Background(), path) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			day := time.Date(2024, 10, 31, 0, 0, 0, 0, time.UTC)
+			snapshot := func(height int64, blockTime time.Time, amount int64) Snapshot {
+				return Snapshot{
+					Chain:     "testchain",
+					Address:   "cosmos1test",
+					Date:      blockTime.Truncate(24 * time.Hour),
+					Height:    height,
+					BlockTime: blockTime,
+					Balances: map[BalanceSource]types.Coins{
+						COSMOSSDK_BANK_BALANCE:        types.NewCoins(types.NewCoin("utest", math.NewInt(amount)), types.NewCoin("uother", math.NewInt(7))),
+						COSMOSSDK_STAKING_DELEGATION:  types.NewCoins(types.NewCoin("utest", math.NewInt(1000))),
+						COSMOSSDK_STAKING_UNBONDING:   {},
+						COSMOSSDK_DISTRIBUTION_REWARD: {},
+					},
+					CollectedAt: time.Date(2024, 11, 2, 12, 0, 0, 0, time.UTC),
+				}
+			}
+
+			daily := snapshot(10, day.Add(-5*time.Second), 100)
+			daily.Date, daily.Daily = day, true
+			for _, s := range []Snapshot{
+				snapshot(20, day.Add(6*time.Hour), 150),
+				daily,
+				snapshot(30, day.Add(30*time.Hour), 200),
+				snapshot(20, day.Add(6*time.Hour), 175),
+			} {
+				if err := store.Put(ctx, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			series, err := store.Series(ctx, "testchain", "cosmos1test", day.Add(-time.Hour), day.Add(24*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(series) != 2 || series[0].Height != 10 || series[1].Height != 20 {
+				t.Fatalf("unexpected series %+v", series)
+			}
+			if got := series[1].Balances[COSMOSSDK_BANK_BALANCE].String(); got != "7uother,175utest" {
+				t.Errorf("expected the replaced bank balance 7uother,175utest, got %s", got)
+			}
+			if got := series[1].Balances[COSMOSSDK_STAKING_UNBONDING]; got == nil || !got.IsZero() {
+				t.Errorf("expected an empty unbonding balance, got %v", got)
+			}
+			if !series[1].CollectedAt.Equal(time.Date(2024, 11, 2, 12, 0, 0, 0, time.UTC)) {
+				t.Errorf("unexpected collectedAt %s", series[1].CollectedAt)
+			}
+
+			latest, ok, err := store.Latest(ctx, "testchain", "cosmos1test")
+			if err != nil || !ok {
+				t.Fatalf("expected a latest snapshot, got %v, %v", ok, err)
+			}
+			if latest.Height != 30 || !latest.BlockTime.Equal(day.Add(30*time.Hour)) {
+				t.Errorf("unexpected latest snapshot %+v", latest)
+			}
+
+			stored, ok, err := store.Daily(ctx, "testchain", "cosmos1test", day)
+			if err != nil || !ok {
+				t.Fatalf("expected a daily snapshot, got %v, %v", ok, err)
+			}
+			if stored.Height != 10 || stored.Balances[COSMOSSDK_BANK_BALANCE].AmountOf("utest").Int64() != 100 {
+				t.Errorf("unexpected daily snapshot %+v", stored)
+			}
+			if _, ok, _ := store.Daily(ctx, "testchain", "cosmos1test", day.Add(24*time.Hour)); ok {
+				t.Error("expected no daily snapshot on Nov 1")
+			}
+			if _, ok, _ := store.Latest(ctx, "testchain", "cosmos1other"); ok {
+				t.Error("expected no snapshot of another address")
+			}
+		})
+	}
+
+	// The SQLite history survives a restart and its schema is not migrated
+	// twice.
+	store, err := NewSQLiteStore(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	latest, ok, err := store.Latest(context.Background(), "testchain", "cosmos1test")
+	if err != nil || !ok || latest.Height != 30 {
+		t.Fatalf("expected the stored history after reopening, got %+v, %v, %v", latest, ok, err)
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "fmt"
+
 type BlockResponse struct {
 	Result  BlockResult `json:"result"`
 	ID      int64       `json:"id"`
@@ -121,3 +123,50 @@ type PubKey struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
 }
+
+type TxSearchResponse struct {
+	Result  *TxSearchResult `json:"result"`
+	Error   *RPCError       `json:"error"`
+	ID      int64           `json:"id"`
+	Jsonrpc string          `json:"jsonrpc"`
+}
+
+type RPCError struct {
+	Code    int64  `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Message, e.Data)
+}
+
+type TxSearchResult struct {
+	Txs        []TxResult `json:"txs"`
+	TotalCount string     `json:"total_count"`
+}
+
+type TxResult struct {
+	Hash     string       `json:"hash"`
+	Height   string       `json:"height"`
+	Index    int64        `json:"index"`
+	TxResult ExecTxResult `json:"tx_result"`
+}
+
+type ExecTxResult struct {
+	Code      int64   `json:"code"`
+	Codespace string  `json:"codespace"`
+	Log       string  `json:"log"`
+	Events    []Event `json:"events"`
+}
+
+type Event struct {
+	Type       string           `json:"type"`
+	Attributes []EventAttribute `json:"attributes"`
+}
+
+type EventAttribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Index bool   `json:"index"`
+}